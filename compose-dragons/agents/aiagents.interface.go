@@ -21,6 +21,8 @@ type AIAgent interface {
 	StreamCompletionWithSimilaritySearch(ctx context.Context, config Config, userMessage string, callback ai.ModelStreamCallback) (string, error)
 	DetectAndExecuteToolCalls(ctx context.Context, config Config, userMessage string) (*ToolCallsResult, error)
 	DetectAndExecuteToolCallsWithConfirmation(ctx context.Context, config Config, userMessage string) (*ToolCallsResult, error)
+	StreamDetectAndExecuteToolCalls(ctx context.Context, config Config, userMessage string, callback ToolEventCallback) (*ToolCallsResult, error)
+	StreamDetectAndExecuteToolCallsWithConfirmation(ctx context.Context, config Config, userMessage string, callback ToolEventCallback) (*ToolCallsResult, error)
 	ResetMessages()
 	GetHistory() []*ai.Message
 	DisplayHistory()
@@ -0,0 +1,231 @@
+package agents
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ToolApproval is the decision of a ToolApprover
+type ToolApproval string
+
+const (
+	// ToolApproved executes the tool
+	ToolApproved ToolApproval = "approved"
+	// ToolDenied skips the tool, the model is told that the execution was cancelled
+	ToolDenied ToolApproval = "denied"
+	// ToolStopped skips the tool and stops the tool calls loop
+	ToolStopped ToolApproval = "stopped"
+)
+
+// ToolApprovalRequest describes the tool call to approve
+type ToolApprovalRequest struct {
+	AgentName string
+	ToolName  string
+	ToolRef   string
+	Input     any
+}
+
+// ToolApprover decides if a tool call requested by the model can be executed.
+// It is used by DetectAndExecuteToolCallsWithConfirmation (see Config.ToolApprover).
+// Returning an error stops the tool calls loop.
+type ToolApprover interface {
+	Approve(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error)
+}
+
+// ToolApproverFunc is a callback-based approver (e.g. to forward the request to a remote UI)
+type ToolApproverFunc func(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error)
+
+// Approve calls the function
+func (f ToolApproverFunc) Approve(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+	return f(ctx, request)
+}
+
+// AlwaysAllowApprover approves every tool call
+type AlwaysAllowApprover struct{}
+
+// Approve always returns ToolApproved
+func (AlwaysAllowApprover) Approve(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+	return ToolApproved, nil
+}
+
+// ToolListApprover approves or denies the tool calls by tool name.
+// The deny list wins over the allow list, the other tools are sent to the fallback approver
+// (denied if there is no fallback).
+//
+// Example: no confirmation for the read-only tools, ask for the others
+//
+//	approver := &agents.ToolListApprover{
+//		Allow:    []string{"get_dungeon_map", "get_player_info"},
+//		Fallback: &ui.TUIToolApprover{Color: ui.Yellow},
+//	}
+type ToolListApprover struct {
+	Allow    []string
+	Deny     []string
+	Fallback ToolApprover
+}
+
+// Approve checks the deny list, then the allow list, then asks the fallback approver
+func (approver *ToolListApprover) Approve(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+	if slices.Contains(approver.Deny, request.ToolName) {
+		return ToolDenied, nil
+	}
+	if slices.Contains(approver.Allow, request.ToolName) {
+		return ToolApproved, nil
+	}
+	if approver.Fallback == nil {
+		return ToolDenied, nil
+	}
+	return approver.Fallback.Approve(ctx, request)
+}
+
+// AskOnceApprover asks the wrapped approver only once per tool name
+// and remembers the decision for the session (ToolStopped is never remembered)
+type AskOnceApprover struct {
+	Approver ToolApprover
+
+	mu        sync.Mutex
+	decisions map[string]ToolApproval
+}
+
+// NewAskOnceApprover creates an AskOnceApprover wrapping the given approver
+func NewAskOnceApprover(approver ToolApprover) *AskOnceApprover {
+	return &AskOnceApprover{
+		Approver:  approver,
+		decisions: map[string]ToolApproval{},
+	}
+}
+
+// Approve returns the remembered decision for the tool or asks the wrapped approver
+func (approver *AskOnceApprover) Approve(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+	approver.mu.Lock()
+	defer approver.mu.Unlock()
+
+	if approver.decisions == nil {
+		approver.decisions = map[string]ToolApproval{}
+	}
+	if decision, exists := approver.decisions[request.ToolName]; exists {
+		return decision, nil
+	}
+
+	decision, err := approver.Approver.Approve(ctx, request)
+	if err != nil {
+		return decision, err
+	}
+	if decision != ToolStopped {
+		approver.decisions[request.ToolName] = decision
+	}
+	return decision, nil
+}
+
+// Reset forgets the decisions (e.g. at the start of a new session)
+func (approver *AskOnceApprover) Reset() {
+	approver.mu.Lock()
+	defer approver.mu.Unlock()
+	approver.decisions = map[string]ToolApproval{}
+}
+
+// StdinApprover asks the user on the standard input (y/n/q).
+// It is the default approver when Config.ToolApprover is not set.
+// The end of the input (Ctrl-D, closed or piped stdin) stops the tool calls loop.
+type StdinApprover struct{}
+
+// Approve reads the answer of the user, line by line, until y, n or q (or the cancellation of ctx)
+func (StdinApprover) Approve(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+	return askApproval(ctx, request, stdinLines(), os.Stdout)
+}
+
+// askApproval asks the approval of the tool call until the answer is y, n or q
+func askApproval(ctx context.Context, request ToolApprovalRequest, lines *lineReader, output io.Writer) (ToolApproval, error) {
+	for {
+		fmt.Fprintf(output, "Do you want to execute tool %q - %s? (y/n/q): ", request.ToolName, request.Input)
+		line, err := lines.readLine(ctx)
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(output)
+			return ToolStopped, nil
+		}
+		if err != nil {
+			return ToolStopped, err
+		}
+		response := strings.ToLower(strings.TrimSpace(line))
+		if response == "y" || response == "n" || response == "q" {
+			return ChoiceToApproval(response), nil
+		}
+		fmt.Fprintln(output, "Please enter 'y', 'n' or 'q'.")
+	}
+}
+
+// lineReader reads the lines of an input without blocking the cancellation of the readers:
+// a line read after a cancellation is returned to the next reader (it is not lost)
+type lineReader struct {
+	mu      sync.Mutex
+	scanner *bufio.Scanner
+	pending chan lineResult
+}
+
+type lineResult struct {
+	line string
+	err  error
+}
+
+func newLineReader(input io.Reader) *lineReader {
+	return &lineReader{scanner: bufio.NewScanner(input)}
+}
+
+// stdinLines is the line reader shared by all the StdinApprover
+var stdinLines = sync.OnceValue(func() *lineReader {
+	return newLineReader(os.Stdin)
+})
+
+// readLine returns the next line, io.EOF at the end of the input, or the error of ctx
+func (reader *lineReader) readLine(ctx context.Context) (string, error) {
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+
+	if reader.pending == nil {
+		pending := make(chan lineResult, 1)
+		reader.pending = pending
+		go func() {
+			if reader.scanner.Scan() {
+				pending <- lineResult{line: reader.scanner.Text()}
+				return
+			}
+			err := reader.scanner.Err()
+			if err == nil {
+				err = io.EOF
+			}
+			pending <- lineResult{err: err}
+		}()
+	}
+
+	select {
+	case result := <-reader.pending:
+		if !errors.Is(result.err, io.EOF) {
+			// The next read scans a new line (the end of the input is kept)
+			reader.pending = nil
+		} else {
+			reader.pending <- result
+		}
+		return result.line, result.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// ChoiceToApproval converts a y/n/q answer to a ToolApproval
+func ChoiceToApproval(choice string) ToolApproval {
+	switch choice {
+	case "y":
+		return ToolApproved
+	case "q":
+		return ToolStopped
+	default:
+		return ToolDenied
+	}
+}
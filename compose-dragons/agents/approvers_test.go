@@ -0,0 +1,116 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToolListApprover(t *testing.T) {
+	asked := 0
+	fallback := ToolApproverFunc(func(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+		asked++
+		return ToolApproved, nil
+	})
+
+	tests := []struct {
+		name     string
+		approver *ToolListApprover
+		tool     string
+		want     ToolApproval
+		asked    int
+	}{
+		{"allowed", &ToolListApprover{Allow: []string{"get_map"}}, "get_map", ToolApproved, 0},
+		{"denied", &ToolListApprover{Deny: []string{"attack"}, Fallback: fallback}, "attack", ToolDenied, 0},
+		{"deny wins over allow", &ToolListApprover{Allow: []string{"attack"}, Deny: []string{"attack"}}, "attack", ToolDenied, 0},
+		{"fallback", &ToolListApprover{Allow: []string{"get_map"}, Fallback: fallback}, "move", ToolApproved, 1},
+		{"no fallback", &ToolListApprover{Allow: []string{"get_map"}}, "move", ToolDenied, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			asked = 0
+			got, err := test.approver.Approve(context.Background(), ToolApprovalRequest{ToolName: test.tool})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want || asked != test.asked {
+				t.Fatalf("Approve(%q) = %s (asked %d), want %s (asked %d)", test.tool, got, asked, test.want, test.asked)
+			}
+		})
+	}
+}
+
+func TestAskOnceApprover(t *testing.T) {
+	answers := []ToolApproval{ToolDenied, ToolStopped, ToolApproved}
+	asked := 0
+	approver := NewAskOnceApprover(ToolApproverFunc(func(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+		answer := answers[asked]
+		asked++
+		return answer, nil
+	}))
+
+	ctx := context.Background()
+	for _, want := range []ToolApproval{ToolDenied, ToolDenied} {
+		if got, _ := approver.Approve(ctx, ToolApprovalRequest{ToolName: "attack"}); got != want {
+			t.Fatalf("attack: %s, want %s", got, want)
+		}
+	}
+	// ToolStopped is not remembered
+	for _, want := range []ToolApproval{ToolStopped, ToolApproved, ToolApproved} {
+		if got, _ := approver.Approve(ctx, ToolApprovalRequest{ToolName: "move"}); got != want {
+			t.Fatalf("move: %s, want %s", got, want)
+		}
+	}
+	if asked != 3 {
+		t.Fatalf("asked %d times, want 3", asked)
+	}
+}
+
+func TestAskApproval(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  ToolApproval
+	}{
+		{"yes", "y\n", ToolApproved},
+		{"no", "N\n", ToolDenied},
+		{"quit", "q\n", ToolStopped},
+		{"empty line and invalid answer", "\nmaybe\ny\n", ToolApproved},
+		{"end of input", "", ToolStopped},
+		{"end of input after invalid answer", "maybe", ToolStopped},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := askApproval(context.Background(), ToolApprovalRequest{ToolName: "move"}, newLineReader(strings.NewReader(test.input)), io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("askApproval(%q) = %s, want %s", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAskApprovalCancelled(t *testing.T) {
+	input, writer := io.Pipe()
+	defer writer.Close()
+	lines := newLineReader(input)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	got, err := askApproval(ctx, ToolApprovalRequest{ToolName: "move"}, lines, io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) || got != ToolStopped {
+		t.Fatalf("askApproval = %s, %v, want stopped and the deadline error", got, err)
+	}
+
+	// The line typed after the cancellation is read by the next approval
+	go writer.Write([]byte("y\n"))
+	got, err = askApproval(context.Background(), ToolApprovalRequest{ToolName: "move"}, lines, io.Discard)
+	if err != nil || got != ToolApproved {
+		t.Fatalf("askApproval = %s, %v, want approved", got, err)
+	}
+}
@@ -3,66 +3,110 @@ package agents
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
-	"github.com/firebase/genkit/go/plugins/compat_oai/openai"
-	"github.com/openai/openai-go/option"
 )
 
-func getEmbedder(ctx context.Context, engineURL string, embeddingModelId string) ai.Embedder {
-	oaiPlugin := &openai.OpenAI{
-		APIKey: "I💙DockerModelRunner",
-		Opts: []option.RequestOption{
-			option.WithBaseURL(engineURL),
-		},
+// embedFunc returns the embedding function of the vector store rebuilds
+// with the (shared) genkit instance and embedder
+func embedFunc(g *genkit.Genkit, embedder ai.Embedder) rag.EmbedFunc {
+	return func(ctx context.Context, chunk string) ([]float32, error) {
+		resp, err := genkit.Embed(ctx, g,
+			ai.WithEmbedder(embedder),
+			ai.WithTextDocs(chunk),
+		)
+		if err != nil {
+			msg.DisplayError("😡 Error generating embedding:", err)
+			return nil, err
+		}
+		if len(resp.Embeddings) == 0 {
+			return nil, fmt.Errorf("no embedding for the chunk")
+		}
+		msg.DisplayEmbeddingsMessages(
+			fmt.Sprintf("💾 Embedded chunk: %s", chunk),
+		)
+		return resp.Embeddings[0].Embedding, nil
 	}
-	genkit.Init(ctx, genkit.WithPlugins(oaiPlugin))
-	embedder := oaiPlugin.DefineEmbedder(embeddingModelId, nil)
-	return embedder
 }
 
-func generateEmbeddings(ctx context.Context, engineURL string, embeddingModelId string, chunks []string) (ai.Embedder, rag.MemoryVectorStore, error) {
-	store := rag.MemoryVectorStore{
-		Records: make(map[string]rag.VectorRecord),
-	}
-
-	oaiPlugin := &openai.OpenAI{
-		APIKey: "I💙DockerModelRunner",
-		Opts: []option.RequestOption{
-			option.WithBaseURL(engineURL),
-		},
-	}
-	g := genkit.Init(ctx, genkit.WithPlugins(oaiPlugin))
-	embedder := oaiPlugin.DefineEmbedder(embeddingModelId, nil)
-
-	for _, chunk := range chunks {
+// BatchEmbedFunc returns the embedding function of the batched rebuilds (one request for several chunks)
+// with the (shared) genkit instance and embedder
+func BatchEmbedFunc(g *genkit.Genkit, embedder ai.Embedder) rag.BatchEmbedFunc {
+	return func(ctx context.Context, chunks []string) ([][]float32, error) {
 		resp, err := genkit.Embed(ctx, g,
 			ai.WithEmbedder(embedder),
-			ai.WithTextDocs(chunk),
+			ai.WithTextDocs(chunks...),
 		)
 		if err != nil {
-			msg.DisplayError("😡 Error generating embedding:", err)
-			return nil, rag.MemoryVectorStore{}, err
+			return nil, err
 		}
-		for i, emb := range resp.Embeddings {
-			// Store the embedding in the vector store
-			record, errSave := store.Save(rag.VectorRecord{
-				Prompt:    chunk,
-				Embedding: emb.Embedding,
-			})
-			if errSave != nil {
-				msg.DisplayError("😡 Error saving vector record:", errSave)
-				return nil, rag.MemoryVectorStore{}, errSave
-			}
+		embeddings := make([][]float32, 0, len(resp.Embeddings))
+		for _, embedding := range resp.Embeddings {
+			embeddings = append(embeddings, embedding.Embedding)
+		}
+		return embeddings, nil
+	}
+}
+
+// VectorStoreOptions defines the file and the rebuild of BuildVectorStore
+type VectorStoreOptions struct {
+	// Path is the store file, JSON or binary (see SaveVectorStore), the store is not saved if empty
+	Path string
+	// Encoding is the encoding of the embeddings of a binary file
+	Encoding       rag.VectorEncoding
+	EmbeddingModel string
+	Embed          rag.BatchEmbedFunc
+	// Rebuild defines the batches, the concurrency and the progress (the Checkpoint saves the file if not set)
+	Rebuild rag.RebuildOptions
+}
 
-			msg.DisplayEmbeddingsMessages(
-				fmt.Sprintf("💾 %d Saved record: %s %s", i, record.Prompt, record.Id),
-			)
+// BuildVectorStore updates the store with the ingested documents and saves it, unless it is up to date.
+// Only the chunks changed since the last build (or embedded with another model) are embedded,
+// and the partial store is saved if the rebuild fails, so the next build only embeds the missing chunks.
+//
+//	ingested documents ─ IsUpToDate? ─ no ─ RebuildWithOptions (checkpoints) ─ SaveVectorStore
+//	                                 └ yes ─ rebuilt: false
+func BuildVectorStore(ctx context.Context, store *rag.MemoryVectorStore, ingested rag.IngestResult, options VectorStoreOptions) (stats rag.RebuildStats, rebuilt bool, err error) {
+	if store.IsUpToDate(ingested.SourceHash, options.EmbeddingModel) {
+		return stats, false, nil
+	}
+	rebuildOptions := options.Rebuild
+	if rebuildOptions.Checkpoint == nil && options.Path != "" {
+		rebuildOptions.Checkpoint = func() error {
+			return SaveVectorStore(store, options.Path, options.Encoding)
 		}
 	}
-	return embedder, store, nil
-	// TODO: save to a JSON file and retrive from there
+	stats, err = store.RebuildWithOptions(ctx, ingested.SourceHash, ingested.Chunks, options.EmbeddingModel, options.Embed, rebuildOptions)
+	if err != nil {
+		return stats, false, err
+	}
+	if options.Path != "" {
+		if err := SaveVectorStore(store, options.Path, options.Encoding); err != nil {
+			return stats, true, err
+		}
+	}
+	return stats, true, nil
+}
+
+// LoadVectorStore reads a vector store file (JSON or binary, the format is detected)
+func LoadVectorStore(path string) (*rag.MemoryVectorStore, error) {
+	store := &rag.MemoryVectorStore{Records: map[string]rag.VectorRecord{}}
+	if err := store.LoadFromFile(path); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SaveVectorStore writes a vector store file: JSON for the .json extension, binary otherwise
+// (see rag.DefaultVectorStorePath)
+func SaveVectorStore(store *rag.MemoryVectorStore, path string, encoding rag.VectorEncoding) error {
+	if filepath.Ext(path) == ".json" {
+		return store.SaveJSONToFile(path)
+	}
+	return store.SaveBinaryToFile(path, encoding)
 }
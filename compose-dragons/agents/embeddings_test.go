@@ -0,0 +1,93 @@
+package agents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func TestBatchEmbedFunc(t *testing.T) {
+	g := genkit.Init(context.Background())
+	embed := BatchEmbedFunc(g, rag.DefineHashingEmbedder(g, "hashing-32", 32))
+	embeddings, err := embed(context.Background(), []string{"the guard", "the merchant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := rag.HashingEmbedFunc(32)(context.Background(), "the merchant")
+	if len(embeddings) != 2 || !slices.Equal(embeddings[1], want) {
+		t.Fatalf("embeddings %v, want the hashing embeddings of the 2 chunks", embeddings)
+	}
+}
+
+func TestBuildVectorStore(t *testing.T) {
+	tests := []struct {
+		name string
+		// path returns the store file of the document
+		path  func(document string) string
+		magic string
+	}{
+		{"json", func(document string) string { return rag.DefaultVectorStorePath(document, false) }, "{"},
+		{"binary", func(document string) string { return rag.DefaultVectorStorePath(document, true) }, "CDVS"},
+		{"in memory", func(document string) string { return "" }, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			document := filepath.Join(directory, "guard.md")
+			if err := os.WriteFile(document, []byte("# Guard\nThe guard keeps the gate.\n## Secrets\nThe password is moonlight."), 0644); err != nil {
+				t.Fatal(err)
+			}
+			path := test.path(document)
+			ingested, err := rag.IngestFiles(document, rag.IngestOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			embedded := 0
+			embed := rag.HashingEmbedFunc(32).Batched()
+			options := VectorStoreOptions{
+				Path:           path,
+				Encoding:       rag.Float16Encoding,
+				EmbeddingModel: "hashing-32",
+				Embed: func(ctx context.Context, chunks []string) ([][]float32, error) {
+					embedded += len(chunks)
+					return embed(ctx, chunks)
+				},
+			}
+
+			store := &rag.MemoryVectorStore{Records: map[string]rag.VectorRecord{}}
+			stats, rebuilt, err := BuildVectorStore(context.Background(), store, ingested, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rebuilt || stats.Embedded != 2 || embedded != 2 {
+				t.Fatalf("first build: rebuilt %t, %s, %d embedded chunks", rebuilt, stats, embedded)
+			}
+
+			// The store of the file (or the same store) is up to date
+			if path != "" {
+				data, err := os.ReadFile(path)
+				if err != nil || !strings.HasPrefix(string(data), test.magic) {
+					t.Fatalf("the file does not start with %q (%v)", test.magic, err)
+				}
+				if store, err = LoadVectorStore(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, rebuilt, err := BuildVectorStore(context.Background(), store, ingested, options); err != nil || rebuilt || embedded != 2 {
+				t.Fatalf("second build: rebuilt %t (%v), %d embedded chunks", rebuilt, err, embedded)
+			}
+			if path == "" {
+				if files, _ := os.ReadDir(directory); len(files) != 1 {
+					t.Fatalf("%d files, the store is saved", len(files))
+				}
+			}
+		})
+	}
+}
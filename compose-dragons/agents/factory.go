@@ -0,0 +1,132 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/compat_oai/openai"
+	"github.com/openai/openai-go/option"
+)
+
+// AgentFactory shares one genkit instance, one OpenAI compatible plugin
+// and the embedders between all the agents it creates.
+//
+// IMPORTANT: genkit panics when the same action name is registered twice
+// in one registry, so the factory keeps track of the agent and retriever names
+// and returns an error on collisions instead.
+type AgentFactory struct {
+	EngineURL string
+
+	genKitInstance *genkit.Genkit
+	oaiPlugin      *openai.OpenAI
+
+	mu         sync.Mutex
+	embedders  map[string]ai.Embedder
+	agents     map[string]*NPCAgent
+	retrievers map[string]bool
+}
+
+// NewAgentFactory initializes the shared genkit instance for the given engine URL
+func NewAgentFactory(ctx context.Context, engineURL string) *AgentFactory {
+	oaiPlugin := &openai.OpenAI{
+		APIKey: "I💙DockerModelRunner",
+		Opts: []option.RequestOption{
+			option.WithBaseURL(engineURL),
+		},
+	}
+	g := genkit.Init(ctx, genkit.WithPlugins(oaiPlugin))
+
+	return &AgentFactory{
+		EngineURL:      engineURL,
+		genKitInstance: g,
+		oaiPlugin:      oaiPlugin,
+		embedders:      map[string]ai.Embedder{},
+		agents:         map[string]*NPCAgent{},
+		retrievers:     map[string]bool{},
+	}
+}
+
+var (
+	sharedFactoriesMu sync.Mutex
+	// sharedFactories are the factories of the deprecated NPCAgent.Initialize, by engine URL
+	sharedFactories = map[string]*AgentFactory{}
+)
+
+// sharedFactory returns the factory of the engine URL, it is created only once
+func sharedFactory(ctx context.Context, engineURL string) *AgentFactory {
+	sharedFactoriesMu.Lock()
+	defer sharedFactoriesMu.Unlock()
+
+	if factory, exists := sharedFactories[engineURL]; exists {
+		return factory
+	}
+	factory := NewAgentFactory(ctx, engineURL)
+	sharedFactories[engineURL] = factory
+	return factory
+}
+
+// Genkit returns the shared genkit instance (e.g. to build the MCP tools catalog)
+func (factory *AgentFactory) Genkit() *genkit.Genkit {
+	return factory.genKitInstance
+}
+
+// NewAgent creates an agent using the shared genkit instance.
+// The agent id (lower case name) must be unique for the factory.
+func (factory *AgentFactory) NewAgent(name string) (*NPCAgent, error) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	agentId := strings.ToLower(strings.TrimSpace(name))
+	if _, exists := factory.agents[agentId]; exists {
+		return nil, fmt.Errorf("agent %q is already registered", name)
+	}
+
+	agent := &NPCAgent{
+		Name:           name,
+		factory:        factory,
+		genKitInstance: factory.genKitInstance,
+		messages:       []*ai.Message{},
+	}
+	factory.agents[agentId] = agent
+	return agent, nil
+}
+
+// Embedder returns the embedder for the given model id, it is defined only once
+func (factory *AgentFactory) Embedder(embeddingModelId string) ai.Embedder {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	if embedder, exists := factory.embedders[embeddingModelId]; exists {
+		return embedder
+	}
+	embedder := factory.oaiPlugin.DefineEmbedder(embeddingModelId, nil)
+	factory.embedders[embeddingModelId] = embedder
+	return embedder
+}
+
+// DefineRetriever registers a memory vector retriever with a unique name
+func (factory *AgentFactory) DefineRetriever(name string, vectorStore *rag.MemoryVectorStore, embedder ai.Embedder) (ai.Retriever, error) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	if factory.retrievers[name] {
+		return nil, fmt.Errorf("retriever %q is already registered", name)
+	}
+	retriever, err := rag.DefineNamedMemoryVectorRetriever(factory.genKitInstance, name, vectorStore, embedder)
+	if err != nil {
+		return nil, err
+	}
+	factory.retrievers[name] = true
+	return retriever, nil
+}
+
+// retrieverName builds the retriever name of an agent
+func retrieverName(agentName string) string {
+	return "memoryVectorStoreRetriever/" + strings.ToLower(strings.ReplaceAll(strings.TrimSpace(agentName), " ", "-"))
+}
@@ -0,0 +1,107 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+func TestFactoryEmbedderCache(t *testing.T) {
+	factory := NewAgentFactory(context.Background(), "http://localhost:0/v1")
+
+	// genkit panics if an embedder is defined twice: the concurrent calls share one embedder
+	embedders := make([]ai.Embedder, 8)
+	var wg sync.WaitGroup
+	for i := range embedders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			embedders[i] = factory.Embedder("ai/mxbai-embed-large")
+		}()
+	}
+	wg.Wait()
+	for i, embedder := range embedders {
+		if embedder != embedders[0] {
+			t.Fatalf("embedder %d is another embedder", i)
+		}
+	}
+
+	other := factory.Embedder("ai/embeddinggemma")
+	if other == embedders[0] || other.Name() == factory.Embedder("ai/mxbai-embed-large").Name() {
+		t.Fatalf("the embedder of another model is %q", other.Name())
+	}
+}
+
+func TestFactoryAgentNameCollision(t *testing.T) {
+	factory := NewAgentFactory(context.Background(), "http://localhost:0/v1")
+	elara, err := factory.NewAgent("Elara")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The agent id is the lower case name
+	for _, name := range []string{"Elara", " elara ", "ELARA"} {
+		if _, err := factory.NewAgent(name); err == nil || !strings.Contains(err.Error(), "is already registered") {
+			t.Fatalf("NewAgent(%q): error %v, want a collision", name, err)
+		}
+	}
+	kael, err := factory.NewAgent("Kael")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elara.genKitInstance != factory.Genkit() || kael.genKitInstance != factory.Genkit() {
+		t.Fatal("the agents do not share the genkit instance of the factory")
+	}
+
+	// Another factory has its own names
+	if _, err := NewAgentFactory(context.Background(), "http://localhost:0/v1").NewAgent("Elara"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFactoryRetrieverNameCollision(t *testing.T) {
+	factory := NewAgentFactory(context.Background(), "http://localhost:0/v1")
+	embedder := rag.DefineHashingEmbedder(factory.Genkit(), "hashing-64", 64)
+	store := &rag.MemoryVectorStore{Records: map[string]rag.VectorRecord{}}
+
+	name := retrieverName(" Elara Moonwhisper ")
+	if name != "memoryVectorStoreRetriever/elara-moonwhisper" {
+		t.Fatalf("retriever name %q", name)
+	}
+	if _, err := factory.DefineRetriever(name, store, embedder); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := factory.DefineRetriever(retrieverName("elara moonwhisper"), store, embedder); err == nil || !strings.Contains(err.Error(), "is already registered") {
+		t.Fatalf("error %v, want a collision", err)
+	}
+	if _, err := factory.DefineRetriever(retrieverName("Kael"), store, embedder); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitializeSharesTheFactory(t *testing.T) {
+	config := Config{EngineURL: "http://localhost:1/v1"}
+	elara, kael, other := &NPCAgent{}, &NPCAgent{}, &NPCAgent{}
+	elara.Initialize(context.Background(), config, "Elara")
+	kael.Initialize(context.Background(), config, "Kael")
+	other.Initialize(context.Background(), Config{EngineURL: "http://localhost:2/v1"}, "Elara")
+
+	if elara.factory != kael.factory || elara.genKitInstance != kael.genKitInstance {
+		t.Fatal("the agents of the same engine do not share the factory")
+	}
+	if other.factory == elara.factory || other.factory.EngineURL != "http://localhost:2/v1" {
+		t.Fatal("the agents of another engine share the factory")
+	}
+	if elara.Name != "Elara" || kael.Name != "Kael" {
+		t.Fatalf("names %q and %q", elara.Name, kael.Name)
+	}
+
+	// The embedders are defined once for all the agents
+	if elara.factory.Embedder("ai/mxbai-embed-large") != kael.factory.Embedder("ai/mxbai-embed-large") {
+		t.Fatal("the agents have their own embedders")
+	}
+}
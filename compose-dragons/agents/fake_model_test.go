@@ -0,0 +1,69 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// fakeModel answers the requests with scripted messages, in order, and records the requests
+type fakeModel struct {
+	mu       sync.Mutex
+	answers  []func(req *ai.ModelRequest) *ai.Message
+	requests []*ai.ModelRequest
+}
+
+func (model *fakeModel) generate(ctx context.Context, req *ai.ModelRequest, callback ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+	model.mu.Lock()
+	defer model.mu.Unlock()
+	model.requests = append(model.requests, req)
+	if len(model.answers) == 0 {
+		return nil, fmt.Errorf("no scripted answer for request %d", len(model.requests))
+	}
+	answer := model.answers[0](req)
+	model.answers = model.answers[1:]
+	if callback != nil {
+		if err := callback(ctx, &ai.ModelResponseChunk{Content: answer.Content}); err != nil {
+			return nil, err
+		}
+	}
+	return &ai.ModelResponse{Request: req, Message: answer, FinishReason: ai.FinishReasonStop}, nil
+}
+
+// newFakeAgent returns an agent of a factory using a fake chat model ("test/fake")
+func newFakeAgent(t *testing.T, answers ...func(req *ai.ModelRequest) *ai.Message) (*NPCAgent, Config, *fakeModel) {
+	t.Helper()
+	factory := NewAgentFactory(context.Background(), "http://localhost:0/v1")
+	model := &fakeModel{answers: answers}
+	genkit.DefineModel(factory.Genkit(), "test/fake", &ai.ModelOptions{
+		Supports: &ai.ModelSupports{Multiturn: true, SystemRole: true, Tools: true, Constrained: ai.ConstrainedSupportNone},
+	}, model.generate)
+
+	agent, err := factory.NewAgent(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent, Config{ChatModelId: "test/fake"}, model
+}
+
+// text returns a scripted text answer
+func text(answer string) func(req *ai.ModelRequest) *ai.Message {
+	return func(req *ai.ModelRequest) *ai.Message {
+		return ai.NewModelTextMessage(answer)
+	}
+}
+
+// toolRequests returns a scripted answer requesting the tools
+func toolRequests(requests ...*ai.ToolRequest) func(req *ai.ModelRequest) *ai.Message {
+	return func(req *ai.ModelRequest) *ai.Message {
+		parts := []*ai.Part{}
+		for _, request := range requests {
+			parts = append(parts, ai.NewToolRequestPart(request))
+		}
+		return ai.NewModelMessage(parts...)
+	}
+}
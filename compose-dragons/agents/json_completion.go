@@ -0,0 +1,141 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// JsonValidationError lists the problems of a JSON completion
+// (invalid JSON, JSON schema violations, custom validators errors)
+type JsonValidationError struct {
+	Problems []string
+	Response string
+}
+
+func (e *JsonValidationError) Error() string {
+	return "invalid JSON completion: " + strings.Join(e.Problems, "; ")
+}
+
+// JsonCompletionAs runs a JSON completion and decodes the answer of the model into a T value.
+// The answer is validated against the JSON schema generated from T and the optional custom validators.
+// If the answer is not valid, the model is re-prompted with the validation errors
+// up to config.JsonRepairAttempts times.
+//
+// Example:
+//
+//	room, err := agents.JsonCompletionAs[data.Room](ctx, agent, config, "Create a dungeon entrance room",
+//		func(room data.Room) error {
+//			if room.Name == "" {
+//				return errors.New("the room name is required")
+//			}
+//			return nil
+//		},
+//	)
+func JsonCompletionAs[T any](ctx context.Context, agent *NPCAgent, config Config, userMessage string, validators ...func(T) error) (T, error) {
+	var value T
+	schema := core.InferSchemaMap(value)
+
+	// [HISTORY] the invalid answers and the repair prompts are not kept in the messages:
+	// the history is restored after a failed attempt, and the valid answer follows the original request
+	history := slices.Clone(agent.messages)
+
+	prompt := userMessage
+	var lastErr error
+	for attempt := 0; attempt <= config.JsonRepairAttempts; attempt++ {
+		if attempt > 0 {
+			msg.DisplayError(fmt.Sprintf("🔧 JSON completion attempt %d/%d is not valid:", attempt, config.JsonRepairAttempts+1), lastErr)
+			prompt = repairPrompt(userMessage, lastErr)
+		}
+
+		value, lastErr = jsonCompletionAttempt(ctx, agent, config, prompt, schema, validators)
+		if lastErr == nil {
+			if attempt > 0 {
+				answer := agent.messages[len(agent.messages)-1]
+				agent.messages = append(history, ai.NewUserTextMessage(strings.TrimSpace(userMessage)), answer)
+			}
+			return value, nil
+		}
+		agent.messages = slices.Clone(history)
+
+		// Only the validation errors can be repaired by the model
+		var validationErr *JsonValidationError
+		if !errors.As(lastErr, &validationErr) {
+			return value, lastErr
+		}
+	}
+	return value, lastErr
+}
+
+// jsonCompletionAttempt runs one JSON completion and validates the answer
+func jsonCompletionAttempt[T any](ctx context.Context, agent *NPCAgent, config Config, prompt string, schema map[string]any, validators []func(T) error) (T, error) {
+	var value T
+
+	resp, err := agent.jsonCompletion(ctx, config, value, prompt)
+	if errors.Is(err, errInvalidJSON) {
+		return value, &JsonValidationError{Problems: []string{err.Error()}}
+	}
+	if err != nil {
+		return value, err
+	}
+
+	// Extract the JSON (the answer can be in a markdown code block)
+	var raw json.RawMessage
+	if err := resp.Output(&raw); err != nil {
+		return value, &JsonValidationError{Problems: []string{err.Error()}, Response: resp.Text()}
+	}
+
+	problems := validateAgainstSchema(raw, schema)
+	if len(problems) == 0 {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) == 0 {
+		for _, validator := range validators {
+			if err := validator(value); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return value, &JsonValidationError{Problems: problems, Response: string(raw)}
+	}
+	return value, nil
+}
+
+// validateAgainstSchema returns the list of the JSON schema violations
+func validateAgainstSchema(raw json.RawMessage, schema map[string]any) []string {
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewBytesLoader(raw))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	problems := []string{}
+	for _, resultErr := range result.Errors() {
+		problems = append(problems, resultErr.String())
+	}
+	return problems
+}
+
+// repairPrompt asks the model to fix its previous answer
+func repairPrompt(userMessage string, err error) string {
+	problems := []string{err.Error()}
+	var validationErr *JsonValidationError
+	if errors.As(err, &validationErr) {
+		problems = validationErr.Problems
+	}
+	return fmt.Sprintf(`Your previous answer is not valid:
+- %s
+
+Fix these problems and answer again with valid JSON only, for this request:
+%s`, strings.Join(problems, "\n- "), userMessage)
+}
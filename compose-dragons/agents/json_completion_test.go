@@ -0,0 +1,64 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testRoom struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func TestJsonCompletionAsRepairKeepsHistoryClean(t *testing.T) {
+	agent, config, model := newFakeAgent(t,
+		text(`{"name": "", "description": "A dark room"}`),
+		text(`{"name": "Hall", "description": "A dark room"}`),
+	)
+	config.JsonRepairAttempts = 1
+
+	room, err := JsonCompletionAs(context.Background(), agent, config, "Create a room", func(room testRoom) error {
+		if room.Name == "" {
+			return errors.New("the room name is required")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Name != "Hall" {
+		t.Fatalf("room name = %q, want Hall", room.Name)
+	}
+
+	// The repair request does not contain the invalid answer
+	for _, message := range model.requests[1].Messages {
+		if strings.Contains(message.Text(), `"name": ""`) {
+			t.Fatalf("the invalid answer is in the repair request: %q", message.Text())
+		}
+	}
+
+	// Only the original request and the valid answer are kept
+	messages := agent.messages
+	if len(messages) != 2 {
+		t.Fatalf("%d messages in the history, want 2", len(messages))
+	}
+	if messages[0].Text() != "Create a room" || !strings.Contains(messages[1].Text(), "Hall") {
+		t.Fatalf("unexpected history: %q, %q", messages[0].Text(), messages[1].Text())
+	}
+}
+
+func TestJsonCompletionAsFailureRestoresHistory(t *testing.T) {
+	agent, config, _ := newFakeAgent(t, text(`{"name": 1}`), text(`{"name": 2}`))
+	config.JsonRepairAttempts = 1
+
+	_, err := JsonCompletionAs[testRoom](context.Background(), agent, config, "Create a room")
+	var validationErr *JsonValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("err = %v, want a JsonValidationError", err)
+	}
+	if messages := agent.messages; len(messages) != 0 {
+		t.Fatalf("%d messages in the history, want 0", len(messages))
+	}
+}
@@ -0,0 +1,97 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// StopReason explains why the tool calls loop stopped
+type StopReason string
+
+const (
+	// StopReasonCompleted: the model did not request any more tool
+	StopReasonCompleted StopReason = "completed"
+	// StopReasonUserStopped: the user (or the approver) stopped the loop
+	StopReasonUserStopped StopReason = "user_stopped"
+	// StopReasonError: the model request failed
+	StopReasonError StopReason = "error"
+	// StopReasonMaxIterations: the model was called ToolLoopGuards.MaxIterations times
+	StopReasonMaxIterations StopReason = "max_iterations"
+	// StopReasonMaxToolCalls: the next tool calls would exceed ToolLoopGuards.MaxToolCalls
+	StopReasonMaxToolCalls StopReason = "max_tool_calls"
+	// StopReasonRepeatedCall: the same tool was requested with the same arguments too many times
+	StopReasonRepeatedCall StopReason = "repeated_call"
+	// StopReasonTimeout: the loop exceeded ToolLoopGuards.MaxDuration
+	StopReasonTimeout StopReason = "timeout"
+)
+
+// ToolLoopGuards limits the tool calls loop, so a model that keeps requesting tools cannot spin forever.
+// The zero value uses the default limits: 10 iterations and 3 identical calls, no calls limit and no time limit.
+type ToolLoopGuards struct {
+	// MaxIterations is the maximum number of model requests (default 10)
+	MaxIterations int
+	// MaxToolCalls is the maximum number of tool calls (0 means no limit)
+	MaxToolCalls int
+	// MaxIdenticalCalls is the maximum number of calls of the same tool with the same arguments (default 3)
+	MaxIdenticalCalls int
+	// MaxDuration is the wall-clock budget of the loop (0 means no limit)
+	MaxDuration time.Duration
+}
+
+func (guards ToolLoopGuards) maxIterations() int {
+	if guards.MaxIterations > 0 {
+		return guards.MaxIterations
+	}
+	return 10
+}
+
+func (guards ToolLoopGuards) maxIdenticalCalls() int {
+	if guards.MaxIdenticalCalls > 0 {
+		return guards.MaxIdenticalCalls
+	}
+	return 3
+}
+
+// toolCallsCounter counts the calls by tool name and arguments
+type toolCallsCounter map[string]int
+
+// add counts the call and returns the number of identical calls (this one included)
+func (counter toolCallsCounter) add(req *ai.ToolRequest) int {
+	arguments, err := json.Marshal(req.Input)
+	if err != nil {
+		arguments = []byte(fmt.Sprintf("%v", req.Input))
+	}
+	key := req.Name + " " + string(arguments)
+	counter[key]++
+	return counter[key]
+}
+
+// unknownToolCall builds the call of a tool that does not exist:
+// the model gets an error tool response instead of a silent skip
+func unknownToolCall(req *ai.ToolRequest) *toolCall {
+	err := fmt.Errorf("tool %q not found, use one of the available tools", req.Name)
+	return &toolCall{
+		req:     req,
+		history: []*ai.Message{toolErrorMessage(req, ToolCallUnknownTool, err)},
+		results: []ToolCallRecord{{
+			ToolName: req.Name,
+			ToolRef:  req.Ref,
+			Input:    req.Input,
+			Status:   ToolCallUnknownTool,
+			Err:      err,
+		}},
+	}
+}
+
+// loopStopReason returns the stop reason when the loop context is done:
+// the caller cancelled the request (error) or the wall-clock budget is exceeded
+func loopStopReason(ctx context.Context) StopReason {
+	if ctx.Err() != nil {
+		return StopReasonError
+	}
+	return StopReasonTimeout
+}
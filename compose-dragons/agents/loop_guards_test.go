@@ -0,0 +1,134 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func TestToolCallsCounter(t *testing.T) {
+	counter := toolCallsCounter{}
+	tests := []struct {
+		name  string
+		input any
+		want  int
+	}{
+		{"move", map[string]any{"direction": "north"}, 1},
+		{"move", map[string]any{"direction": "north"}, 2},
+		{"move", map[string]any{"direction": "south"}, 1},
+		{"look", map[string]any{"direction": "north"}, 1},
+		{"move", map[string]any{"direction": "north"}, 3},
+	}
+	for i, test := range tests {
+		if got := counter.add(&ai.ToolRequest{Name: test.name, Input: test.input}); got != test.want {
+			t.Fatalf("call %d (%s %v): %d identical calls, want %d", i, test.name, test.input, got, test.want)
+		}
+	}
+}
+
+type pingInput struct {
+	N       int `json:"n"`
+	DelayMs int `json:"delay_ms"`
+}
+
+func ping(n, delayMs int) *ai.ToolRequest {
+	return &ai.ToolRequest{Name: "ping", Ref: fmt.Sprint(n), Input: map[string]any{"n": n, "delay_ms": delayMs}}
+}
+
+func TestToolLoopGuards(t *testing.T) {
+	tests := []struct {
+		name       string
+		guards     ToolLoopGuards
+		answers    []func(req *ai.ModelRequest) *ai.Message
+		wantReason StopReason
+		wantCalls  int
+	}{
+		{
+			name:       "completed",
+			answers:    []func(req *ai.ModelRequest) *ai.Message{toolRequests(ping(1, 0)), text("pong")},
+			wantReason: StopReasonCompleted,
+			wantCalls:  1,
+		},
+		{
+			name:       "max iterations",
+			guards:     ToolLoopGuards{MaxIterations: 2},
+			answers:    []func(req *ai.ModelRequest) *ai.Message{toolRequests(ping(1, 0)), toolRequests(ping(2, 0)), toolRequests(ping(3, 0))},
+			wantReason: StopReasonMaxIterations,
+			wantCalls:  2,
+		},
+		{
+			name:       "max tool calls",
+			guards:     ToolLoopGuards{MaxToolCalls: 2},
+			answers:    []func(req *ai.ModelRequest) *ai.Message{toolRequests(ping(1, 0)), toolRequests(ping(2, 0), ping(3, 0))},
+			wantReason: StopReasonMaxToolCalls,
+			wantCalls:  1,
+		},
+		{
+			name:       "repeated call",
+			guards:     ToolLoopGuards{MaxIdenticalCalls: 2},
+			answers:    []func(req *ai.ModelRequest) *ai.Message{toolRequests(ping(1, 0)), toolRequests(ping(1, 0)), toolRequests(ping(1, 0))},
+			wantReason: StopReasonRepeatedCall,
+			wantCalls:  2,
+		},
+		{
+			name:       "timeout",
+			guards:     ToolLoopGuards{MaxDuration: 20 * time.Millisecond},
+			answers:    []func(req *ai.ModelRequest) *ai.Message{toolRequests(ping(1, 50)), text("too late")},
+			wantReason: StopReasonTimeout,
+			wantCalls:  1,
+		},
+		{
+			name:       "model error",
+			answers:    []func(req *ai.ModelRequest) *ai.Message{toolRequests(ping(1, 0))},
+			wantReason: StopReasonError,
+			wantCalls:  1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			agent, config, _ := newFakeAgent(t, test.answers...)
+			pingTool := genkit.DefineTool(agent.genKitInstance, "ping", "ping", func(ctx *ai.ToolContext, input pingInput) (string, error) {
+				select {
+				case <-time.After(time.Duration(input.DelayMs) * time.Millisecond):
+				case <-ctx.Done():
+				}
+				return fmt.Sprint("pong ", input.N), nil
+			})
+			config.ToolsModelId = config.ChatModelId
+			config.Tools = []ai.ToolRef{pingTool}
+			config.ToolLoopGuards = test.guards
+
+			result, err := agent.StreamDetectAndExecuteToolCalls(context.Background(), config, "ping", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.StopReason != test.wantReason || result.TotalCalls != test.wantCalls {
+				t.Fatalf("stop reason %s after %d calls, want %s after %d calls", result.StopReason, result.TotalCalls, test.wantReason, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestUnknownToolCall(t *testing.T) {
+	agent, config, model := newFakeAgent(t,
+		toolRequests(&ai.ToolRequest{Name: "teleport", Ref: "1", Input: map[string]any{}}),
+		text("I can't teleport"),
+	)
+	config.ToolsModelId = config.ChatModelId
+
+	result, err := agent.StreamDetectAndExecuteToolCalls(context.Background(), config, "teleport me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StopReason != StopReasonCompleted || len(result.Results) != 1 || result.Results[0].Status != ToolCallUnknownTool {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	// The model gets an error tool response
+	if len(model.requests) != 2 {
+		t.Fatalf("%d model requests, want 2", len(model.requests))
+	}
+}
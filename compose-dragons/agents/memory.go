@@ -0,0 +1,190 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// MemoryConfig defines the long-term memory of the NPC agents:
+// the notable facts of the conversations of each NPC and the game events shared by all the NPCs
+// are recalled with the lore on the next turns (see rag.MemoryStore).
+//
+//	player ──► NPC answer ──► RememberExchange ──► facts ──► NPC memory  ─┐
+//	DM tool calls ──────────► game events ───────────────► world memory ─┤
+//	next question ──► SimilaritySearch: lore + recalled memories ◄───────┘
+type MemoryConfig struct {
+	Enabled bool
+	// Directory of the memory files of the NPCs (<name>.memory.json), the memories are not saved if empty
+	Directory string
+	// Limit is the minimum cosine similarity of the recalled memories (SimilaritySearchLimit if 0)
+	Limit float64
+	// MaxResults is the number of memories recalled from each memory (3 if 0)
+	MaxResults int
+	// Options defines the deduplication and the decay of the memories
+	Options rag.MemoryOptions
+	// World is the memory of the game events shared by all the NPCs (optional)
+	World *rag.MemoryStore
+	// ModelId extracts the facts from the conversations (ChatModelId if empty)
+	ModelId string
+}
+
+// memoryFacts is the output of the facts extraction
+type memoryFacts struct {
+	Facts []string `json:"facts"`
+}
+
+// InitializeMemory creates the long-term memory of the agent and loads its file (see MemoryConfig)
+func (agent *NPCAgent) InitializeMemory(ctx context.Context, config Config) error {
+	embed := embedFunc(agent.genKitInstance, agent.factory.Embedder(config.EmbeddingsModelId))
+	agent.memories = rag.NewMemoryStore(embed, config.EmbeddingsModelId, config.Memory.Options)
+	if config.Memory.Directory == "" {
+		return nil
+	}
+	agent.memoriesPath = filepath.Join(config.Memory.Directory, memoryFileName(agent.Name))
+	if err := agent.memories.Load(ctx, agent.memoriesPath); err != nil {
+		return err
+	}
+	msg.DisplayEmbeddingsMessages(fmt.Sprintf("🧠 %s remembers %d facts\n", agent.Name, agent.memories.Len()))
+	return nil
+}
+
+// Memories returns the long-term memory of the agent (nil if not initialized)
+func (agent *NPCAgent) Memories() *rag.MemoryStore {
+	return agent.memories
+}
+
+// RememberExchange extracts the notable facts of a question of the player and of the answer of the agent
+// (promises, bribes, gifts, threats, names...) and saves them in the memory of the agent.
+// It returns the remembered facts. The conversation history is not changed.
+func (agent *NPCAgent) RememberExchange(ctx context.Context, config Config, userMessage, answer string) ([]string, error) {
+	if agent.memories == nil {
+		return nil, nil
+	}
+	modelId := config.Memory.ModelId
+	if modelId == "" {
+		modelId = config.ChatModelId
+	}
+
+	prompt := fmt.Sprintf(`Extract the notable facts to remember from this exchange between the player and %s.
+A notable fact is something the player said or did, or something %s promised or revealed:
+names, bribes, gifts, deals, threats, quests, secrets. Ignore the greetings and the small talk.
+Write each fact as a short sentence in the third person (e.g. "The player bribed %s with 10 gold coins").
+Answer with an empty list if there is nothing notable.
+
+PLAYER: %s
+
+%s: %s`, agent.Name, agent.Name, agent.Name, userMessage, strings.ToUpper(agent.Name), answer)
+
+	resp, err := agent.generate(ctx, config, modelId, validateJSONResponse, nil,
+		ai.WithPrompt(prompt),
+		ai.WithConfig(map[string]any{"temperature": 0.0}),
+		ai.WithOutputType(memoryFacts{}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var output memoryFacts
+	if err := resp.Output(&output); err != nil {
+		return nil, err
+	}
+
+	remembered := []string{}
+	for _, fact := range output.Facts {
+		if fact = strings.TrimSpace(fact); fact == "" {
+			continue
+		}
+		_, isNew, err := agent.memories.Remember(ctx, fact, rag.RecordMetadata{
+			Source: "conversation",
+			Owner:  agent.Name,
+			Tags:   []string{"conversation"},
+		})
+		if err != nil {
+			return remembered, err
+		}
+		if isNew {
+			msg.DisplayEmbeddingsMessages(fmt.Sprintf("🧠 %s remembers: %s", agent.Name, fact))
+		} else {
+			msg.DisplayEmbeddingsMessages(fmt.Sprintf("🧠 %s remembers again: %s", agent.Name, fact))
+		}
+		remembered = append(remembered, fact)
+	}
+
+	if agent.memoriesPath != "" && len(remembered) > 0 {
+		if err := agent.memories.Save(agent.memoriesPath); err != nil {
+			return remembered, err
+		}
+	}
+	return remembered, nil
+}
+
+// recallMemories returns the memories of the agent and of the world related to the query,
+// formatted for the context of the next completion (empty if none), and the recalled memories
+func (agent *NPCAgent) recallMemories(ctx context.Context, config Config, query string) (string, []rag.RecalledMemory, error) {
+	limit := config.Memory.Limit
+	if limit == 0 {
+		limit = config.SimilaritySearchLimit
+	}
+	maxResults := config.Memory.MaxResults
+	if maxResults == 0 {
+		maxResults = 3
+	}
+
+	lines := []string{}
+	allRecalled := []rag.RecalledMemory{}
+	for _, memories := range []*rag.MemoryStore{agent.memories, config.Memory.World} {
+		if memories == nil {
+			continue
+		}
+		recalled, err := memories.Recall(ctx, query, limit, maxResults, nil)
+		if err != nil {
+			return "", nil, err
+		}
+		allRecalled = append(allRecalled, recalled...)
+		for _, memory := range recalled {
+			when := timeAgo(time.Since(memory.LastSeen()))
+			if mentions := memory.Mentions(); mentions > 1 {
+				when += fmt.Sprintf(", %d times", mentions)
+			}
+			lines = append(lines, fmt.Sprintf("- (%s) %s", when, memory.Record.Prompt))
+			msg.DisplaySimilarityMessages(
+				fmt.Sprintf("🧠 Memory (Score: %.4f, Strength: %.2f): %s", memory.Score, memory.Strength, memory.Record.Prompt),
+			)
+		}
+	}
+	return strings.Join(lines, "\n"), allRecalled, nil
+}
+
+// timeAgo formats the age of a memory for the model ("2 hours ago", "yesterday"...)
+func timeAgo(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%d minutes ago", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%d hours ago", int(age.Hours()))
+	case age < 48*time.Hour:
+		return "yesterday"
+	default:
+		return fmt.Sprintf("%d days ago", int(age.Hours()/24))
+	}
+}
+
+// memoryFileName returns the name of the memory file of an agent ("Grim Stonefist" → "grim-stonefist.memory.json")
+func memoryFileName(agentName string) string {
+	return strings.Join(strings.Fields(strings.ToLower(agentName)), "-") + ".memory.json"
+}
+
+// NewMemoryStore creates a memory store embedding the memories with the (shared) embedder of the model,
+// e.g. the world memory of MemoryConfig
+func (factory *AgentFactory) NewMemoryStore(embeddingModelId string, options rag.MemoryOptions) *rag.MemoryStore {
+	return rag.NewMemoryStore(embedFunc(factory.Genkit(), factory.Embedder(embeddingModelId)), embeddingModelId, options)
+}
@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/helpers"
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
@@ -15,31 +18,78 @@ import (
 	"github.com/firebase/genkit/go/ai"
 
 	"github.com/firebase/genkit/go/genkit"
-	"github.com/firebase/genkit/go/plugins/compat_oai/openai"
-	"github.com/openai/openai-go/option"
 )
 
 type Config struct {
 	EngineURL                  string
 	SimilaritySearchLimit      float64
 	SimilaritySearchMaxResults int
+	// SimilarityIndex enables the HNSW index of the vector store (nil means the exact search on all the records)
+	SimilarityIndex *rag.HNSWOptions
+	// SimilarityHybridSearch merges the BM25 keyword results with the vector results
+	SimilarityHybridSearch bool
+	// SimilarityReranker reranks the candidates of the similarity search (optional, see rag.NewLLMReranker)
+	SimilarityReranker rag.Reranker
+	// SimilarityFilter selects the records of the similarity search from their metadata (optional),
+	// so several NPCs can share the same lore with different knowledge
+	SimilarityFilter *rag.MetadataFilter
+
+	// ChunkMaxTokens is the approximate maximum size of the chunks of the context (no limit if 0):
+	// the markdown sections longer than the input of the embedding model are split
+	ChunkMaxTokens     int
+	ChunkOverlapTokens int
+
+	// VectorStoreBinary saves the vector stores in the compact binary format (<context>.vectorstore.bin)
+	// with the VectorStoreEncoding instead of JSON, the JSON files are migrated on load
+	VectorStoreBinary   bool
+	VectorStoreEncoding rag.VectorEncoding
 
 	Temperature float64
 	TopP        float64
 
+	// EmbeddingBatchSize is the number of chunks of one embedding request (1 if 0),
+	// EmbeddingConcurrency the number of embedding requests running at the same time (1 if 0)
+	EmbeddingBatchSize   int
+	EmbeddingConcurrency int
+
 	ChatModelId       string
 	EmbeddingsModelId string
 	ToolsModelId      string
 
 	Tools []ai.ToolRef
+
+	// JsonRepairAttempts is the number of times JsonCompletionAs re-prompts
+	// the model with the validation errors of its answer (0 means no re-prompt)
+	JsonRepairAttempts int
+
+	// ParallelToolCalls is the maximum number of tool calls of one model turn running concurrently
+	// (0 or 1 means sequential). Only the tools listed in ParallelSafeTools run concurrently.
+	ParallelToolCalls int
+	// ParallelSafeTools are the names of the read-only (or parallel-safe) tools
+	ParallelSafeTools []string
+
+	// ToolLoopGuards limits the tool calls loop (iterations, calls, identical calls, duration)
+	ToolLoopGuards ToolLoopGuards
+
+	// ToolApprover decides which tool calls can be executed by DetectAndExecuteToolCallsWithConfirmation
+	// (the user is asked on the standard input if not set)
+	ToolApprover ToolApprover
+
+	// Resilience defines the timeouts, retries and fallback models of the completions
+	Resilience ResiliencePolicy
+
+	// Memory is the long-term memory of the NPC (conversation facts) and of the world (game events)
+	Memory MemoryConfig
 }
 
 // ToolCallsResult holds the result of tool calls detection and execution
 type ToolCallsResult struct {
 	TotalCalls int
-	//Results     []any
-	Results     []map[string]any
+	// Results holds one record per tool call, in the order of the tool requests
+	Results     []ToolCallRecord
 	LastMessage string
+	// StopReason explains why the loop stopped (see ToolLoopGuards)
+	StopReason StopReason
 }
 
 // IMPORTANT: the conversation history is automatically managed
@@ -47,6 +97,8 @@ type ToolCallsResult struct {
 type NPCAgent struct {
 	Name string
 
+	// factory shares the genkit instance and the embedders with the other agents
+	factory        *AgentFactory
 	genKitInstance *genkit.Genkit
 
 	messages []*ai.Message
@@ -58,18 +110,23 @@ type NPCAgent struct {
 	memoryVectorStore rag.MemoryVectorStore
 	embedder          ai.Embedder
 	memoryRetriever   ai.Retriever
+
+	// memories are the facts remembered from the conversations (see InitializeMemory)
+	memories     *rag.MemoryStore
+	memoriesPath string
+
+	// lastRetrieval is the trace of the last similarity search (see LastRetrieval)
+	lastRetrieval *RetrievalTrace
 }
 
+// Initialize creates an agent using the factory shared by all the agents initialized
+// with the same engine URL (see sharedFactory).
+//
+// Deprecated: use AgentFactory.NewAgent, it reports the name collisions.
 func (agent *NPCAgent) Initialize(ctx context.Context, config Config, name string) {
 	// Initialization logic for the NPC agent
-	oaiPlugin := &openai.OpenAI{
-		APIKey: "I💙DockerModelRunner",
-		Opts: []option.RequestOption{
-			option.WithBaseURL(config.EngineURL),
-		},
-	}
-	g := genkit.Init(ctx, genkit.WithPlugins(oaiPlugin))
-	agent.genKitInstance = g
+	agent.factory = sharedFactory(ctx, config.EngineURL)
+	agent.genKitInstance = agent.factory.Genkit()
 
 	agent.Name = name
 	agent.messages = []*ai.Message{}
@@ -100,50 +157,105 @@ func (agent *NPCAgent) SetSystemInstructions(systemInstructions string) {
 
 func (agent *NPCAgent) InitializeVectorStoreFromFile(ctx context.Context, config Config, backgroundContextPath string) error {
 
-	jsonVectoreStore := rag.MemoryVectorStore{}
-	err := jsonVectoreStore.LoadFromJSONFile(backgroundContextPath + ".vectorstore.json")
-	if err == nil {
-		agent.memoryVectorStore = jsonVectoreStore
-		agent.embedder = getEmbedder(ctx, config.EngineURL, config.EmbeddingsModelId)
-		agent.memoryRetriever = rag.DefineMemoryVectorRetriever(agent.genKitInstance, &jsonVectoreStore, agent.embedder)
-		return nil
-
-	} else {
+	embedder := agent.factory.Embedder(config.EmbeddingsModelId)
+
+	agent.embedder = embedder
+	// backgroundContextPath can be a file, a directory or a glob pattern (see rag.IngestFiles)
+	jsonVectorStorePath := rag.DefaultVectorStorePath(backgroundContextPath, false)
+	vectorStorePath := rag.DefaultVectorStorePath(backgroundContextPath, config.VectorStoreBinary)
+
+	vectorStore := rag.MemoryVectorStore{Records: make(map[string]rag.VectorRecord)}
+	errLoad := vectorStore.LoadFromFile(vectorStorePath)
+	migrated := false
+	if errLoad != nil && config.VectorStoreBinary {
+		// [MIGRATION] the JSON file is converted to the binary format
+		vectorStore.Records = make(map[string]rag.VectorRecord)
+		if err := vectorStore.LoadFromJSONFile(jsonVectorStorePath); err == nil {
+			errLoad = nil
+			migrated = true
+		}
+	}
+	if errLoad != nil {
+		vectorStore.Records = make(map[string]rag.VectorRecord)
+	}
 
-		backgroundContext, err := helpers.ReadTextFile(backgroundContextPath)
-		if err != nil {
+	// [INGESTION] markdown, text, JSON, JSONL, CSV and HTML documents
+	ingested, err := rag.IngestFiles(backgroundContextPath, rag.IngestOptions{
+		MaxTokens:     config.ChunkMaxTokens,
+		OverlapTokens: config.ChunkOverlapTokens,
+	})
+	if err != nil {
+		if errLoad != nil {
 			return err
 		}
-		chunks := rag.ChunkWithMarkdownHierarchy(backgroundContext)
-
-		embedder, vectorStore, err := generateEmbeddings(ctx, config.EngineURL, config.EmbeddingsModelId, chunks)
+		// The vector store file is enough without the documents
+		msg.DisplayError("😡 Error reading the context documents, using the vector store file:", err)
+		if migrated {
+			if err := SaveVectorStore(&vectorStore, vectorStorePath, config.VectorStoreEncoding); err != nil {
+				msg.DisplayError("😡 Error saving vector store to file:", err)
+				return err
+			}
+		}
+		agent.memoryVectorStore = vectorStore
+	} else {
+		// [RAG] Only the chunks changed since the last run (or embedded with another model) are embedded,
+		// [RESUME] the partial store is saved if the rebuild fails, the next run only embeds the missing chunks
+		stats, rebuilt, err := BuildVectorStore(ctx, &vectorStore, ingested, VectorStoreOptions{
+			Path:           vectorStorePath,
+			Encoding:       config.VectorStoreEncoding,
+			EmbeddingModel: config.EmbeddingsModelId,
+			Embed:          BatchEmbedFunc(agent.genKitInstance, embedder),
+			Rebuild: rag.RebuildOptions{
+				BatchSize:   config.EmbeddingBatchSize,
+				Concurrency: config.EmbeddingConcurrency,
+				Progress: func(embedded, total int) {
+					msg.DisplayEmbeddingsMessages(fmt.Sprintf("💾 Embedded %d/%d chunks", embedded, total))
+				},
+			},
+		})
 		if err != nil {
+			msg.DisplayError("😡 Error building the vector store:", err)
 			return err
 		}
-		agent.embedder = embedder
+		if rebuilt {
+			msg.DisplayEmbeddingsMessages(
+				fmt.Sprintf("🧠 Updated vector store with %d records from %d documents (%s)\n", len(vectorStore.Records), len(ingested.Files), stats),
+			)
+		} else if migrated {
+			// [RAG] Save the vector store to a file
+			if err := SaveVectorStore(&vectorStore, vectorStorePath, config.VectorStoreEncoding); err != nil {
+				msg.DisplayError("😡 Error saving vector store to file:", err)
+				return err
+			}
+		}
 		agent.memoryVectorStore = vectorStore
+	}
 
-		memoryRetriever := rag.DefineMemoryVectorRetriever(agent.genKitInstance, &vectorStore, embedder)
-		agent.memoryRetriever = memoryRetriever
-
-		msg.DisplayEmbeddingsMessages(
-			fmt.Sprintf("🧠 Created vector store with %d records\n", len(vectorStore.Records)),
-		)
-		// [RAG] Save the vector store to a file
-		err = agent.memoryVectorStore.SaveJSONToFile(backgroundContextPath + ".vectorstore.json")
-		if err != nil {
-			msg.DisplayError("😡 Error saving vector store to file:", err)
+	// [HNSW] approximate similarity search for the large documents
+	if config.SimilarityIndex != nil {
+		if err := agent.memoryVectorStore.UseIndex(rag.NewHNSWIndex(*config.SimilarityIndex)); err != nil {
 			return err
 		}
 	}
 
+	// [BM25] keyword index for the hybrid search
+	if config.SimilarityHybridSearch {
+		agent.memoryVectorStore.UseKeywordIndex(rag.NewBM25Index(rag.BM25Options{}))
+	}
+
+	// IMPORTANT: the retriever name is unique per agent (the genkit instance can be shared)
+	memoryRetriever, err := agent.factory.DefineRetriever(retrieverName(agent.Name), &agent.memoryVectorStore, agent.embedder)
+	if err != nil {
+		return err
+	}
+	agent.memoryRetriever = memoryRetriever
+
 	return nil
 }
 
 func (agent *NPCAgent) Completion(ctx context.Context, config Config, userMessage string) (string, error) {
 
-	fullResponse, err := genkit.Generate(ctx, agent.genKitInstance,
-		ai.WithModelName(config.ChatModelId),
+	fullResponse, err := agent.generate(ctx, config, config.ChatModelId, nil, nil,
 		ai.WithSystem(agent.systemInstructions),
 		// WithMessages sets the messages.
 		// These messages will be sandwiched between the system and user prompts.
@@ -170,8 +282,16 @@ func (agent *NPCAgent) Completion(ctx context.Context, config Config, userMessag
 }
 
 func (agent *NPCAgent) JsonCompletion(ctx context.Context, config Config, outputType any, userMessage string) (string, error) {
-	fullResponse, err := genkit.Generate(ctx, agent.genKitInstance,
-		ai.WithModelName(config.ChatModelId),
+	fullResponse, err := agent.jsonCompletion(ctx, config, outputType, userMessage)
+	if err != nil {
+		return "", err
+	}
+	return fullResponse.Text(), nil
+}
+
+// jsonCompletion returns the model response of a JSON completion (see JsonCompletion and JsonCompletionAs)
+func (agent *NPCAgent) jsonCompletion(ctx context.Context, config Config, outputType any, userMessage string) (*ai.ModelResponse, error) {
+	fullResponse, err := agent.generate(ctx, config, config.ChatModelId, validateJSONResponse, nil,
 		ai.WithSystem(agent.systemInstructions),
 		// WithMessages sets the messages.
 		// These messages will be sandwiched between the system and user prompts.
@@ -187,7 +307,7 @@ func (agent *NPCAgent) JsonCompletion(ctx context.Context, config Config, output
 	)
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Append user message to history
@@ -195,12 +315,11 @@ func (agent *NPCAgent) JsonCompletion(ctx context.Context, config Config, output
 	// Append assistant response to history
 	agent.messages = append(agent.messages, ai.NewModelTextMessage(strings.TrimSpace(fullResponse.Text())))
 
-	return fullResponse.Text(), nil
+	return fullResponse, nil
 }
 
 func (agent *NPCAgent) JsonStreamCompletion(ctx context.Context, config Config, outputType any, userMessage string, callback ai.ModelStreamCallback) (string, error) {
-	fullResponse, err := genkit.Generate(ctx, agent.genKitInstance,
-		ai.WithModelName(config.ChatModelId),
+	fullResponse, err := agent.generate(ctx, config, config.ChatModelId, validateJSONResponse, callback,
 		ai.WithSystem(agent.systemInstructions),
 		// WithMessages sets the messages.
 		// These messages will be sandwiched between the system and user prompts.
@@ -213,7 +332,6 @@ func (agent *NPCAgent) JsonStreamCompletion(ctx context.Context, config Config,
 			"top_p":       config.TopP,
 		}),
 		ai.WithOutputType(outputType),
-		ai.WithStreaming(callback),
 	)
 
 	if err != nil {
@@ -229,29 +347,75 @@ func (agent *NPCAgent) JsonStreamCompletion(ctx context.Context, config Config,
 }
 
 func (agent *NPCAgent) SimilaritySearch(ctx context.Context, config Config, userMessage string) (string, error) {
+	agent.lastRetrieval = nil
+	options := rag.MemoryVectorRetrieverOptions{
+		Limit:      config.SimilaritySearchLimit,
+		MaxResults: config.SimilaritySearchMaxResults,
+		Hybrid:     config.SimilarityHybridSearch,
+		Reranker:   config.SimilarityReranker,
+		Filter:     config.SimilarityFilter,
+	}
 	// Retrieve relevant context from the vector store
-	similarDocuments, err := retrieveSimilarDocuments(ctx, userMessage, agent.memoryRetriever, config.SimilaritySearchLimit, config.SimilaritySearchMaxResults)
+	similarDocuments, chunks, err := retrieveSimilarDocuments(ctx, userMessage, agent.memoryRetriever, options)
 	if err != nil {
 		return "", err
 	}
 	agent.messages = append(agent.messages, ai.NewSystemTextMessage(fmt.Sprintf("Relevant context to help you answer the next question:\n%s", similarDocuments)))
 
+	// [WHY] the retrieved chunks are recorded for the explanation of the answer
+	trace := &RetrievalTrace{
+		Query:      userMessage,
+		Time:       time.Now(),
+		Limit:      options.Limit,
+		MaxResults: options.MaxResults,
+		Hybrid:     options.Hybrid,
+		Filter:     options.Filter,
+		Chunks:     chunks,
+	}
+	agent.lastRetrieval = trace
+
+	// [MEMORY] what the NPC remembers of the previous conversations and of the game events
+	if config.Memory.Enabled {
+		memories, recalled, err := agent.recallMemories(ctx, config, userMessage)
+		if err != nil {
+			msg.DisplayError("😡 Error recalling the memories:", err)
+		} else if memories != "" {
+			agent.messages = append(agent.messages, ai.NewSystemTextMessage(fmt.Sprintf("What you remember (from the previous conversations and events):\n%s", memories)))
+			similarDocuments += "\n" + memories
+			trace.Memories = recalled
+		}
+	}
+
 	return similarDocuments, nil
 }
 
+// LastRetrieval returns what the last similarity search added to the context of the agent
+// (nil if there was no similarity search)
+func (agent *NPCAgent) LastRetrieval() *RetrievalTrace {
+	return agent.lastRetrieval
+}
+
+// recordAnswer links the answer of the completion to the last retrieval
+func (agent *NPCAgent) recordAnswer(answer string) {
+	if agent.lastRetrieval != nil {
+		agent.lastRetrieval.Answer = answer
+	}
+}
+
 func (agent *NPCAgent) CompletionWithSimilaritySearch(ctx context.Context, config Config, userMessage string) (string, error) {
 
 	// Retrieve relevant context from the vector store
 	agent.SimilaritySearch(ctx, config, userMessage)
 
-	return agent.Completion(ctx, config, userMessage)
+	answer, err := agent.Completion(ctx, config, userMessage)
+	agent.recordAnswer(answer)
+	return answer, err
 
 }
 
 func (agent *NPCAgent) StreamCompletion(ctx context.Context, config Config, userMessage string, callback ai.ModelStreamCallback) (string, error) {
 
-	fullResponse, err := genkit.Generate(ctx, agent.genKitInstance,
-		ai.WithModelName(config.ChatModelId),
+	fullResponse, err := agent.generate(ctx, config, config.ChatModelId, nil, callback,
 		ai.WithSystem(agent.systemInstructions),
 		// WithMessages sets the messages.
 		// These messages will be sandwiched between the system and user prompts.
@@ -263,8 +427,6 @@ func (agent *NPCAgent) StreamCompletion(ctx context.Context, config Config, user
 			"temperature": config.Temperature,
 			"top_p":       config.TopP,
 		}),
-
-		ai.WithStreaming(callback),
 	)
 
 	if err != nil {
@@ -284,7 +446,9 @@ func (agent *NPCAgent) StreamCompletionWithSimilaritySearch(ctx context.Context,
 	// Retrieve relevant context from the vector store
 	agent.SimilaritySearch(ctx, config, userMessage)
 
-	return agent.StreamCompletion(ctx, config, userMessage, callback)
+	answer, err := agent.StreamCompletion(ctx, config, userMessage, callback)
+	agent.recordAnswer(answer)
+	return answer, err
 
 }
 
@@ -305,21 +469,39 @@ func (agent *NPCAgent) StreamCompletionWithSimilaritySearch(ctx context.Context,
 //	┌─────────────────┐
 //	│ Append result   │
 //	│ to history      │
+//	│ (or the error)  │
 //	└─────────────────┘
-func (agent *NPCAgent) executeTool(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]any) error {
+func (agent *NPCAgent) executeTool(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]ToolCallRecord) error {
+	start := time.Now()
 	output, err := tool.RunRaw(ctx, req.Input)
+	if err == nil {
+		// The MCP tools report their errors in the output
+		err = mcpOutputError(output)
+	}
+	record := ToolCallRecord{
+		ToolName: req.Name,
+		ToolRef:  req.Ref,
+		Input:    req.Input,
+		Duration: time.Since(start),
+	}
+
 	if err != nil {
 		msg.DisplayError(fmt.Sprintf("😡 tool %q execution failed:", tool.Name()), err)
+
+		record.Status = ToolCallFailed
+		record.Err = err
+		*toolCallsResults = append(*toolCallsResults, record)
+
+		// Tell the model, so it can recover (e.g. with other arguments)
+		*history = append(*history, toolErrorMessage(req, ToolCallFailed, err))
 		return err
 	}
 
 	msg.DisplayToolMessages(fmt.Sprintf("🤖 Result: %v", output))
 
-	*toolCallsResults = append(*toolCallsResults, map[string]any{
-		"tool_name":   req.Name,
-		"tool_ref":    req.Ref,
-		"tool_output": output,
-	})
+	record.Status = ToolCallSucceeded
+	record.Output = output
+	*toolCallsResults = append(*toolCallsResults, record)
 
 	// Add tool response to history
 	part := ai.NewToolResponsePart(&ai.ToolResponse{
@@ -383,7 +565,7 @@ func (agent *NPCAgent) DirectExecuteTool(ctx context.Context, config Config, req
 
 }
 
-// executeToolWithConfirmation asks for user confirmation before executing a tool
+// executeToolWithConfirmation asks the approver before executing a tool
 //
 // Flow:
 //
@@ -393,72 +575,78 @@ func (agent *NPCAgent) DirectExecuteTool(ctx context.Context, config Config, req
 //	             │
 //	             ▼
 //	┌────────────────────────-──┐
-//	│ Prompt user: y/n/q?       │
+//	│ approver.Approve()        │
 //	└────────────┬────────────-─┘
 //	             │
-//	    ┌────────┼────────┐
-//	    │        │        │
-//	    ▼        ▼        ▼
-//	 ┌───┐   ┌───┐   ┌───┐
-//	 │ y │   │ n │   │ q │
-//	 └─┬─┘   └─┬─┘   └─┬─┘
-//	   │       │       │
-//	   │       │       └──► Set stopped=true
-//	   │       │
-//	   │       └──► Append "cancelled" to history
+//	    ┌────────┼─────────┐
+//	    │        │         │
+//	    ▼        ▼         ▼
+//	 ┌────────┐ ┌──────┐ ┌───────┐
+//	 │approved│ │denied│ │stopped│
+//	 └─┬──────┘ └─┬────┘ └─┬─────┘
+//	   │          │        │
+//	   │          │        └──► Set stopped=true (also on error)
+//	   │          │
+//	   │          └──► Append "cancelled" to history
 //	   │
 //	   └──► Call executeTool()
-func (agent *NPCAgent) executeToolWithConfirmation(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]any, stopped *bool) {
-	var response string
-	for {
-		fmt.Printf("Do you want to execute tool %q - %s? (y/n/q): ", req.Name, req.Input)
-		_, err := fmt.Scanln(&response)
-		if err != nil {
-			msg.DisplayError("😡 Error reading input:", err)
-			continue
-		}
-		response = strings.ToLower(strings.TrimSpace(response))
-
-		switch response {
-		case "q":
-			fmt.Println("👋 Exiting the program.")
-			*stopped = true
-			return
-		case "y":
-			agent.executeTool(ctx, req, tool, history, toolCallsResults)
-			return
-		case "n":
-
-			fmt.Println("⏩ Skipping tool execution.", req.Name, req.Ref)
-
-			*toolCallsResults = append(*toolCallsResults, map[string]any{
-				"tool_name":   req.Name,
-				"tool_ref":    req.Ref,
-				"tool_output": "Tool execution cancelled by user",
-			})
-
-			// Add tool response indicating the tool was not executed
-			part := ai.NewToolResponsePart(&ai.ToolResponse{
-				Name:   req.Name,
-				Ref:    req.Ref,
-				Output: map[string]any{"error": "Tool execution cancelled by user"},
-			})
-
-			*history = append(*history, ai.NewMessage(ai.RoleTool, nil, part))
-			return
-		default:
-			fmt.Println("Please enter 'y' or 'n'.")
-			continue
-		}
+func (agent *NPCAgent) executeToolWithConfirmation(ctx context.Context, approver ToolApprover, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]ToolCallRecord, stopped *bool) {
+	approval, err := approver.Approve(ctx, ToolApprovalRequest{
+		AgentName: agent.Name,
+		ToolName:  req.Name,
+		ToolRef:   req.Ref,
+		Input:     req.Input,
+	})
+	if err != nil {
+		msg.DisplayError(fmt.Sprintf("😡 tool %q approval failed:", req.Name), err)
+		*stopped = true
+		return
+	}
 
+	switch approval {
+	case ToolApproved:
+		agent.executeTool(ctx, req, tool, history, toolCallsResults)
+	case ToolStopped:
+		fmt.Println("👋 Exiting the program.")
+		*stopped = true
+	default:
+		fmt.Println("⏩ Skipping tool execution.", req.Name, req.Ref)
+
+		err := errors.New("Tool execution cancelled by user")
+		*toolCallsResults = append(*toolCallsResults, ToolCallRecord{
+			ToolName: req.Name,
+			ToolRef:  req.Ref,
+			Input:    req.Input,
+			Status:   ToolCallCancelled,
+			Err:      err,
+		})
+
+		// Add tool response indicating the tool was not executed
+		*history = append(*history, toolErrorMessage(req, ToolCallCancelled, err))
+	}
+}
+
+// toolApprover returns the approver of the config, the stdin prompt if not set.
+// The approvals are serialized because the parallel tool calls can ask at the same time.
+func toolApprover(config Config) ToolApprover {
+	var approver ToolApprover = StdinApprover{}
+	if config.ToolApprover != nil {
+		approver = config.ToolApprover
 	}
+	mu := &sync.Mutex{}
+	return ToolApproverFunc(func(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return approver.Approve(ctx, request)
+	})
 }
 
 // toolExecutorFunc is a function type for executing tools
-type toolExecutorFunc func(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]any, stopped *bool)
+type toolExecutorFunc func(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]ToolCallRecord, stopped *bool)
 
 // detectAndExecuteToolCallsLoop is the core loop for detecting and executing tool calls
 // It takes an executor function as parameter to customize the execution behavior
+// and an optional callback to stream the events of the loop (nil for the blocking versions)
 //
 // Flow:
 //
@@ -497,15 +685,29 @@ type toolExecutorFunc func(ctx context.Context, req *ai.ToolRequest, tool ai.Too
 //	                └────────┬─────────┘
 //	                         │
 //	                         └──► Loop back
-func (agent *NPCAgent) detectAndExecuteToolCallsLoop(ctx context.Context, config Config, userMessage string, executor toolExecutorFunc) (*ToolCallsResult, error) {
+func (agent *NPCAgent) detectAndExecuteToolCallsLoop(ctx context.Context, config Config, userMessage string, executor toolExecutorFunc, callback ToolEventCallback) (*ToolCallsResult, error) {
 
 	stopped := false
 	lastToolAssistantMessage := ""
 	totalOfToolsCalls := 0
-	toolCallsResults := []any{}
+	toolCallsResults := []ToolCallRecord{}
 
 	history := []*ai.Message{}
 
+	// [LOOP GUARDS] iterations, tool calls, identical calls and wall-clock budget
+	guards := config.ToolLoopGuards
+	stopReason := StopReasonCompleted
+	iterations := 0
+	identicalCalls := toolCallsCounter{}
+
+	// IMPORTANT: loopCtx is used for the model and the tools, ctx for the events
+	loopCtx := ctx
+	if guards.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		loopCtx, cancel = context.WithTimeout(ctx, guards.MaxDuration)
+		defer cancel()
+	}
+
 	// Only displayed if enabled via env var ...
 	displayToolsList(config.Tools)
 
@@ -516,9 +718,30 @@ func (agent *NPCAgent) detectAndExecuteToolCallsLoop(ctx context.Context, config
 
 	for !stopped {
 		//msg.DisplayToolMessages(fmt.Sprintf("\n🔄 Tool detection loop iteration - Current history length: %d\n", len(history)))
+		if loopCtx.Err() != nil {
+			stopReason = loopStopReason(ctx)
+			break
+		}
+		if iterations >= guards.maxIterations() {
+			stopReason = StopReasonMaxIterations
+			break
+		}
+		iterations++
+
+		// [STREAMING] forward the text chunks of the model to the callback
+		var streamCallback ai.ModelStreamCallback
+		var callbackErr error
+		if callback != nil {
+			streamCallback = func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+				if chunk.Text() == "" {
+					return nil
+				}
+				callbackErr = callback(ctx, ToolEvent{Type: ToolEventTextDelta, Text: chunk.Text()})
+				return callbackErr
+			}
+		}
 
-		resp, err := genkit.Generate(ctx, agent.genKitInstance,
-			ai.WithModelName(config.ToolsModelId),
+		resp, err := agent.generate(loopCtx, config, config.ToolsModelId, nil, streamCallback,
 			ai.WithSystem(agent.toolsSystemInstructions),
 			ai.WithMessages(history...),
 			//ai.WithPrompt(userMessage),
@@ -526,9 +749,16 @@ func (agent *NPCAgent) detectAndExecuteToolCallsLoop(ctx context.Context, config
 			ai.WithToolChoice(ai.ToolChoiceAuto),
 			ai.WithReturnToolRequests(true),
 		)
+		if callbackErr != nil {
+			return nil, callbackErr
+		}
 		if err != nil {
 			msg.DisplayError("🔴 [tools] Error:", err)
 			// break the loop on error
+			stopReason = StopReasonError
+			if loopCtx.Err() != nil {
+				stopReason = loopStopReason(ctx)
+			}
 			stopped = true
 			break
 		}
@@ -544,12 +774,30 @@ func (agent *NPCAgent) detectAndExecuteToolCallsLoop(ctx context.Context, config
 		}
 		//msg.DisplayToolMessages(fmt.Sprintf("✋ Number of tool requests: %v", len(toolRequests)))
 
+		if guards.MaxToolCalls > 0 && totalOfToolsCalls+len(toolRequests) > guards.MaxToolCalls {
+			stopReason = StopReasonMaxToolCalls
+			lastToolAssistantMessage = resp.Text()
+			break
+		}
+		repeated := false
+		for _, req := range toolRequests {
+			if identicalCalls.add(req) > guards.maxIdenticalCalls() {
+				repeated = true
+			}
+		}
+		if repeated {
+			stopReason = StopReasonRepeatedCall
+			lastToolAssistantMessage = resp.Text()
+			break
+		}
+
 		totalOfToolsCalls += len(toolRequests)
 
 		// Append the assistant message with tool requests to history
 		history = append(history, resp.Message)
 
 		// BEGIN: [TOOL CALLS] detection loop
+		calls := []*toolCall{}
 		for _, req := range toolRequests {
 			// STEP 1: find the tool by name
 			msg.DisplayToolMessages(fmt.Sprintf("🛠️ Tool request: %s Args: %v", req.Name, req.Input))
@@ -568,33 +816,78 @@ func (agent *NPCAgent) detectAndExecuteToolCallsLoop(ctx context.Context, config
 				}
 			}
 
-			// If not found, log error and continue
+			// If not found, tell the model with an error tool response
 			if tool == nil {
 				msg.DisplayToolMessages(fmt.Sprintf("🔴 tool %q not found\n", req.Name))
-				//break // [TODO]: continue?
+				calls = append(calls, unknownToolCall(req))
 				continue
 			}
 
-			// STEP 2: Execute tool using the provided executor
-			executor(ctx, req, tool, &history, &toolCallsResults, &stopped)
+			calls = append(calls, &toolCall{req: req, tool: tool})
 		}
 
-	} // END: of [TOOL CALLS] detection loop
+		// STEP 2: Execute the tools using the provided executor
+		// (the consecutive parallel-safe calls run concurrently, see Config.ParallelToolCalls)
+		for _, batch := range toolCallBatches(config, calls) {
+			for _, call := range batch {
+				err := emitToolEvent(ctx, callback, ToolEvent{
+					Type:     ToolEventToolRequest,
+					ToolName: call.req.Name,
+					ToolRef:  call.req.Ref,
+					Input:    call.req.Input,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
 
-	// [TOOL CALL RESULT]
-	// Convert toolCallsResults to []map[string]any
-	convertedResults := make([]map[string]any, len(toolCallsResults))
-	for i, result := range toolCallsResults {
-		if mapResult, ok := result.(map[string]any); ok {
-			convertedResults[i] = mapResult
+			runToolCalls(loopCtx, batch, executor, config.ParallelToolCalls)
+
+			// Keep the order of the tool requests in the history
+			for _, call := range batch {
+				history = append(history, call.history...)
+				toolCallsResults = append(toolCallsResults, call.results...)
+				if call.stopped {
+					stopped = true
+					stopReason = StopReasonUserStopped
+				}
+
+				// The executor adds a record when the tool is executed, fails or is cancelled
+				if len(call.results) == 0 {
+					continue
+				}
+				record := call.results[len(call.results)-1]
+				err := emitToolEvent(ctx, callback, ToolEvent{
+					Type:     ToolEventToolResult,
+					ToolName: call.req.Name,
+					ToolRef:  call.req.Ref,
+					Input:    call.req.Input,
+					Output:   record.Output,
+					Record:   &record,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
+
+	} // END: of [TOOL CALLS] detection loop
+
+	if stopReason != StopReasonCompleted {
+		msg.DisplayToolMessages(fmt.Sprintf("✋ Tool calls loop stopped: %s", stopReason))
 	}
 
-	return &ToolCallsResult{
+	// [TOOL CALL RESULT]
+	result := &ToolCallsResult{
 		TotalCalls:  totalOfToolsCalls,
-		Results:     convertedResults,
+		Results:     toolCallsResults,
 		LastMessage: lastToolAssistantMessage,
-	}, nil
+		StopReason:  stopReason,
+	}
+	if err := emitToolEvent(ctx, callback, ToolEvent{Type: ToolEventDone, Result: result}); err != nil {
+		return result, err
+	}
+	return result, nil
 }
 
 // DetectAndExecuteToolCalls detects and executes tool calls automatically (no confirmation)
@@ -628,13 +921,14 @@ func (agent *NPCAgent) detectAndExecuteToolCallsLoop(ctx context.Context, config
 //	│ Append result to history     │
 //	└──────────────────────────────┘
 func (agent *NPCAgent) DetectAndExecuteToolCalls(ctx context.Context, config Config, userMessage string) (*ToolCallsResult, error) {
-	executor := func(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]any, stopped *bool) {
+	executor := func(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]ToolCallRecord, stopped *bool) {
 		agent.executeTool(ctx, req, tool, history, toolCallsResults)
 	}
-	return agent.detectAndExecuteToolCallsLoop(ctx, config, userMessage, executor)
+	return agent.detectAndExecuteToolCallsLoop(ctx, config, userMessage, executor, nil)
 }
 
-// DetectAndExecuteToolCallsWithConfirmation detects and executes tool calls with user confirmation
+// DetectAndExecuteToolCallsWithConfirmation detects and executes tool calls with user confirmation.
+// The confirmation is delegated to config.ToolApprover (the standard input prompt if not set).
 //
 // Flow:
 //
@@ -659,14 +953,14 @@ func (agent *NPCAgent) DetectAndExecuteToolCalls(ctx context.Context, config Con
 //	│executeToolWithConfirmation   │
 //	└──────────────┬───────────────┘
 //	               │
-//	               │ Ask user: y/n/q?
+//	               │ Ask config.ToolApprover
 //	               │
 //	    ┌──────────┼──────────┐
 //	    │          │          │
 //	    ▼          ▼          ▼
-//	 ┌───┐      ┌───┐      ┌───┐
-//	 │ y │      │ n │      │ q │
-//	 └─┬─┘      └─┬─┘      └─┬─┘
+//	 ┌────────┐ ┌──────┐ ┌───────┐
+//	 │approved│ │denied│ │stopped│
+//	 └─┬──────┘ └─┬────┘ └─┬─────┘
 //	   │          │          │
 //	   │          │          └──► Set stopped=true
 //	   │          │
@@ -674,8 +968,11 @@ func (agent *NPCAgent) DetectAndExecuteToolCalls(ctx context.Context, config Con
 //	   │
 //	   └──► Call executeTool()
 func (agent *NPCAgent) DetectAndExecuteToolCallsWithConfirmation(ctx context.Context, config Config, userMessage string) (*ToolCallsResult, error) {
-	// IMPORTANT: agent.executeToolWithConfirmation == executor
-	return agent.detectAndExecuteToolCallsLoop(ctx, config, userMessage, agent.executeToolWithConfirmation)
+	approver := toolApprover(config)
+	executor := func(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]ToolCallRecord, stopped *bool) {
+		agent.executeToolWithConfirmation(ctx, approver, req, tool, history, toolCallsResults, stopped)
+	}
+	return agent.detectAndExecuteToolCallsLoop(ctx, config, userMessage, executor, nil)
 }
 
 func (agent *NPCAgent) ResetMessages() {
@@ -0,0 +1,63 @@
+package agents
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// toolCall is a tool request of one model turn with its own history and results,
+// they are merged in the order of the requests once the call is done
+type toolCall struct {
+	req  *ai.ToolRequest
+	tool ai.Tool
+
+	history []*ai.Message
+	results []ToolCallRecord
+	stopped bool
+}
+
+// toolCallBatches groups the consecutive parallel-safe calls,
+// the other calls are alone in their batch
+func toolCallBatches(config Config, calls []*toolCall) [][]*toolCall {
+	batches := [][]*toolCall{}
+	for _, call := range calls {
+		parallelSafe := config.ParallelToolCalls > 1 && slices.Contains(config.ParallelSafeTools, call.req.Name)
+		if parallelSafe && len(batches) > 0 {
+			last := batches[len(batches)-1]
+			if slices.Contains(config.ParallelSafeTools, last[0].req.Name) {
+				batches[len(batches)-1] = append(last, call)
+				continue
+			}
+		}
+		batches = append(batches, []*toolCall{call})
+	}
+	return batches
+}
+
+// runToolCalls executes the calls of a batch, at most `limit` at the same time
+// (the calls of unknown tools have no tool and are not executed)
+func runToolCalls(ctx context.Context, batch []*toolCall, executor toolExecutorFunc, limit int) {
+	batch = slices.DeleteFunc(slices.Clone(batch), func(call *toolCall) bool { return call.tool == nil })
+	if len(batch) <= 1 || limit <= 1 {
+		for _, call := range batch {
+			executor(ctx, call.req, call.tool, &call.history, &call.results, &call.stopped)
+		}
+		return
+	}
+
+	semaphore := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, call := range batch {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(call *toolCall) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			executor(ctx, call.req, call.tool, &call.history, &call.results, &call.stopped)
+		}(call)
+	}
+	wg.Wait()
+}
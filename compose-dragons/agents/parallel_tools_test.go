@@ -0,0 +1,111 @@
+package agents
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func TestToolCallBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		parallel int
+		safe     []string
+		tools    []string
+		want     [][]string
+	}{
+		{"sequential", 1, []string{"look"}, []string{"look", "look"}, [][]string{{"look"}, {"look"}}},
+		{"consecutive safe calls", 3, []string{"look", "map"}, []string{"look", "map", "look"}, [][]string{{"look", "map", "look"}}},
+		{"unsafe call splits the batches", 3, []string{"look"}, []string{"look", "look", "move", "look"}, [][]string{{"look", "look"}, {"move"}, {"look"}}},
+		{"unsafe calls are alone", 3, []string{"look"}, []string{"move", "attack"}, [][]string{{"move"}, {"attack"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := []*toolCall{}
+			for _, name := range test.tools {
+				calls = append(calls, &toolCall{req: &ai.ToolRequest{Name: name}})
+			}
+			config := Config{ParallelToolCalls: test.parallel, ParallelSafeTools: test.safe}
+			got := [][]string{}
+			for _, batch := range toolCallBatches(config, calls) {
+				names := []string{}
+				for _, call := range batch {
+					names = append(names, call.req.Name)
+				}
+				got = append(got, names)
+			}
+			if !slices.EqualFunc(got, test.want, slices.Equal) {
+				t.Fatalf("batches = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+type lookInput struct {
+	Room    string `json:"room"`
+	DelayMs int    `json:"delay_ms"`
+}
+
+func TestParallelToolCallsKeepTheOrderOfTheRequests(t *testing.T) {
+	agent, config, model := newFakeAgent(t,
+		toolRequests(
+			&ai.ToolRequest{Name: "look", Ref: "1", Input: map[string]any{"room": "first", "delay_ms": 40}},
+			&ai.ToolRequest{Name: "look", Ref: "2", Input: map[string]any{"room": "second", "delay_ms": 1}},
+			&ai.ToolRequest{Name: "look", Ref: "3", Input: map[string]any{"room": "third", "delay_ms": 20}},
+		),
+		text("done"),
+	)
+
+	var running, maxRunning atomic.Int32
+	look := genkit.DefineTool(agent.genKitInstance, "look", "look at a room", func(ctx *ai.ToolContext, input lookInput) (string, error) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			previous := maxRunning.Load()
+			if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(input.DelayMs) * time.Millisecond)
+		return "you see the " + input.Room + " room", nil
+	})
+	config.ToolsModelId = config.ChatModelId
+	config.Tools = []ai.ToolRef{look}
+	config.ParallelToolCalls = 3
+	config.ParallelSafeTools = []string{"look"}
+
+	result, err := agent.StreamDetectAndExecuteToolCalls(context.Background(), config, "look around", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxRunning.Load() < 2 {
+		t.Fatalf("the calls did not run concurrently (max %d)", maxRunning.Load())
+	}
+
+	outputs := []any{}
+	for _, record := range result.Results {
+		outputs = append(outputs, record.Output)
+	}
+	want := []any{"you see the first room", "you see the second room", "you see the third room"}
+	if !slices.Equal(outputs, want) {
+		t.Fatalf("outputs = %v, want %v", outputs, want)
+	}
+
+	// The tool responses sent to the model follow the order of the requests
+	refs := []string{}
+	for _, message := range model.requests[1].Messages {
+		for _, part := range message.Content {
+			if part.IsToolResponse() {
+				refs = append(refs, part.ToolResponse.Ref)
+			}
+		}
+	}
+	if !slices.Equal(refs, []string{"1", "2", "3"}) {
+		t.Fatalf("tool responses refs = %v, want [1 2 3]", refs)
+	}
+}
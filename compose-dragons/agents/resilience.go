@@ -0,0 +1,233 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/openai/openai-go"
+)
+
+// ResiliencePolicy defines how the completions of an agent handle the failures of the model runner.
+// The zero value keeps the default behavior: one attempt, no timeout and no fallback model.
+//
+// Flow:
+//
+//	for each model (primary model, then fallback models)
+//	  for each attempt (1 + MaxRetries)
+//	    generate with RequestTimeout
+//	    ✅ success → return
+//	    🔁 transient error → wait (exponential backoff) and retry
+//	    ⏭️ model unavailable or invalid JSON → next model
+type ResiliencePolicy struct {
+	// RequestTimeout is the timeout of one request (0 means no timeout)
+	RequestTimeout time.Duration
+	// MaxRetries is the number of retries on transient errors, for each model
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry (default 500ms)
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between two retries (default 10s)
+	MaxBackoff time.Duration
+	// BackoffMultiplier multiplies the delay after each retry (default 2)
+	BackoffMultiplier float64
+	// FallbackModelIds are tried in order when the primary model is unavailable,
+	// keeps failing or returns invalid JSON (same format as ChatModelId, e.g. "openai/ai/qwen2.5:latest")
+	FallbackModelIds []string
+	// IsTransient overrides the detection of the transient errors (optional)
+	IsTransient func(err error) bool
+}
+
+// errInvalidJSON is returned when a JSON completion is not valid JSON
+var errInvalidJSON = errors.New("the model did not return valid JSON")
+
+// responseValidator checks the response of the model before accepting it
+type responseValidator func(resp *ai.ModelResponse) error
+
+// validateJSONResponse accepts only responses with valid JSON text (optionally in a markdown code block)
+func validateJSONResponse(resp *ai.ModelResponse) error {
+	var output any
+	if err := resp.Output(&output); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidJSON, err)
+	}
+	return nil
+}
+
+// isOutputSchemaError reports whether genkit rejected the answer of the model
+// because it does not match the output type (see ai.WithOutputType)
+func isOutputSchemaError(err error) bool {
+	var genkitErr *core.GenkitError
+	return errors.As(err, &genkitErr) && strings.HasPrefix(genkitErr.Message, "model failed to generate output matching expected schema")
+}
+
+// generate calls genkit.Generate with the resilience policy of the config.
+// IMPORTANT: do not pass ai.WithModelName in opts, the model is set for each attempt.
+// With streaming, a request is not retried once chunks have been sent to the callback.
+func (agent *NPCAgent) generate(ctx context.Context, config Config, modelId string, validate responseValidator, callback ai.ModelStreamCallback, opts ...ai.GenerateOption) (*ai.ModelResponse, error) {
+	policy := config.Resilience
+
+	models := append([]string{modelId}, policy.FallbackModelIds...)
+
+	var lastErr error
+	for modelIndex, model := range models {
+		if modelIndex > 0 {
+			msg.DisplayError(fmt.Sprintf("⏭️ Falling back to model %q after error:", model), lastErr)
+		}
+
+		backoff := policy.initialBackoff()
+		for attempt := 0; attempt <= policy.MaxRetries; attempt++ {
+			if attempt > 0 {
+				msg.DisplayError(fmt.Sprintf("🔁 Retrying model %q (attempt %d/%d) in %v after error:", model, attempt+1, policy.MaxRetries+1, backoff), lastErr)
+				if err := sleepWithContext(ctx, backoff); err != nil {
+					return nil, err
+				}
+				backoff = policy.nextBackoff(backoff)
+			}
+
+			streamed := false
+			attemptOpts := append([]ai.GenerateOption{ai.WithModelName(model)}, opts...)
+			if callback != nil {
+				attemptOpts = append(attemptOpts, ai.WithStreaming(func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+					streamed = true
+					return callback(ctx, chunk)
+				}))
+			}
+
+			resp, err := agent.generateOnce(ctx, policy.RequestTimeout, attemptOpts...)
+			if err == nil && validate != nil {
+				err = validate(resp)
+			}
+			if validate != nil && isOutputSchemaError(err) {
+				// genkit validates the JSON output itself: the answer can be repaired, not retried
+				err = fmt.Errorf("%w: %v", errInvalidJSON, err)
+			}
+			if err == nil {
+				return resp, nil
+			}
+			lastErr = err
+
+			// The caller gave up: no retry, no fallback
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// The chunks already sent cannot be taken back
+			if streamed {
+				return nil, err
+			}
+			if !policy.isTransient(err) {
+				break
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// generateOnce runs one request with an optional timeout
+func (agent *NPCAgent) generateOnce(ctx context.Context, timeout time.Duration, opts ...ai.GenerateOption) (*ai.ModelResponse, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return genkit.Generate(ctx, agent.genKitInstance, opts...)
+}
+
+func (policy ResiliencePolicy) initialBackoff() time.Duration {
+	if policy.InitialBackoff > 0 {
+		return policy.InitialBackoff
+	}
+	return 500 * time.Millisecond
+}
+
+func (policy ResiliencePolicy) nextBackoff(backoff time.Duration) time.Duration {
+	multiplier := policy.BackoffMultiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+	next := time.Duration(float64(backoff) * multiplier)
+	if next > maxBackoff {
+		return maxBackoff
+	}
+	return next
+}
+
+func (policy ResiliencePolicy) isTransient(err error) bool {
+	if policy.IsTransient != nil {
+		return policy.IsTransient(err)
+	}
+	return IsTransientError(err)
+}
+
+// IsTransientError reports whether a request can be retried with the same model:
+// timeouts, network errors, rate limits and server errors (e.g. while a model is loading)
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, errInvalidJSON) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == 408 || apiErr.StatusCode == 429 || apiErr.StatusCode >= 500
+	}
+
+	var genkitErr *core.GenkitError
+	if errors.As(err, &genkitErr) {
+		switch genkitErr.Status {
+		case core.UNAVAILABLE, core.DEADLINE_EXCEEDED, core.RESOURCE_EXHAUSTED, core.INTERNAL:
+			return true
+		case core.NOT_FOUND, core.INVALID_ARGUMENT:
+			return false
+		}
+	}
+
+	// Transport errors: timeouts, refused or reset connections, connections closed by the model runner
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && (errors.Is(urlErr.Err, io.EOF) || errors.Is(urlErr.Err, io.ErrUnexpectedEOF)) {
+		return true
+	}
+
+	// The messages of the model runners without status (e.g. Docker Model Runner loading a model)
+	message := strings.ToLower(err.Error())
+	for _, hint := range []string{"loading model", "temporarily unavailable"} {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepWithContext waits for the given duration or until the context is done
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,225 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/openai/openai-go"
+)
+
+// errModelLoading is a transient error of the model runner
+var errModelLoading = core.NewError(core.UNAVAILABLE, "the model is loading")
+
+// countingModel is a chat model counting its requests, answering with respond
+type countingModel struct {
+	calls   int
+	respond func(ctx context.Context, callback ai.ModelStreamCallback) (*ai.Message, error)
+}
+
+// answer returns a model answering with the text
+func answer(text string) *countingModel {
+	return &countingModel{respond: func(ctx context.Context, callback ai.ModelStreamCallback) (*ai.Message, error) {
+		return ai.NewModelTextMessage(text), nil
+	}}
+}
+
+// failure returns a model failing with the error
+func failure(err error) *countingModel {
+	return &countingModel{respond: func(ctx context.Context, callback ai.ModelStreamCallback) (*ai.Message, error) {
+		return nil, err
+	}}
+}
+
+// define registers the model ("test/<name>") in the genkit instance of the agent
+func (model *countingModel) define(agent *NPCAgent, name string) string {
+	genkit.DefineModel(agent.genKitInstance, "test/"+name, &ai.ModelOptions{
+		Supports: &ai.ModelSupports{Multiturn: true, SystemRole: true},
+	}, func(ctx context.Context, req *ai.ModelRequest, callback ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		model.calls++
+		message, err := model.respond(ctx, callback)
+		if err != nil {
+			return nil, err
+		}
+		return &ai.ModelResponse{Request: req, Message: message, FinishReason: ai.FinishReasonStop}, nil
+	})
+	return "test/" + name
+}
+
+func TestGenerateWithResilience(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ResiliencePolicy
+		primary  *countingModel
+		validate responseValidator
+		stream   bool
+		// want is the text of the answer, the error is expected if empty
+		want              string
+		wantPrimaryCalls  int
+		wantFallbackCalls int
+	}{
+		{
+			name:             "success",
+			policy:           ResiliencePolicy{MaxRetries: 2},
+			primary:          answer("Welcome, traveler"),
+			want:             "Welcome, traveler",
+			wantPrimaryCalls: 1,
+		},
+		{
+			name:              "transient error retried, then fallback",
+			policy:            ResiliencePolicy{MaxRetries: 2, InitialBackoff: time.Millisecond},
+			primary:           failure(errModelLoading),
+			want:              `{"answer": "fallback"}`,
+			wantPrimaryCalls:  3,
+			wantFallbackCalls: 1,
+		},
+		{
+			name:              "permanent error not retried",
+			policy:            ResiliencePolicy{MaxRetries: 2, InitialBackoff: time.Millisecond},
+			primary:           failure(core.NewError(core.NOT_FOUND, "model not found")),
+			want:              `{"answer": "fallback"}`,
+			wantPrimaryCalls:  1,
+			wantFallbackCalls: 1,
+		},
+		{
+			name:              "invalid JSON not retried",
+			policy:            ResiliencePolicy{MaxRetries: 2, InitialBackoff: time.Millisecond},
+			primary:           answer("I am not JSON"),
+			validate:          validateJSONResponse,
+			want:              `{"answer": "fallback"}`,
+			wantPrimaryCalls:  1,
+			wantFallbackCalls: 1,
+		},
+		{
+			name:   "stream with chunks not retried",
+			policy: ResiliencePolicy{MaxRetries: 2, InitialBackoff: time.Millisecond},
+			primary: &countingModel{respond: func(ctx context.Context, callback ai.ModelStreamCallback) (*ai.Message, error) {
+				if err := callback(ctx, &ai.ModelResponseChunk{Content: []*ai.Part{ai.NewTextPart("Welcome")}}); err != nil {
+					return nil, err
+				}
+				return nil, errModelLoading
+			}},
+			stream:           true,
+			wantPrimaryCalls: 1,
+		},
+		{
+			name:   "stream without chunks retried",
+			policy: ResiliencePolicy{MaxRetries: 2, InitialBackoff: time.Millisecond},
+			primary: &countingModel{respond: func(ctx context.Context, callback ai.ModelStreamCallback) (*ai.Message, error) {
+				return nil, errModelLoading
+			}},
+			stream:            true,
+			want:              `{"answer": "fallback"}`,
+			wantPrimaryCalls:  3,
+			wantFallbackCalls: 1,
+		},
+		{
+			name:   "request timeout",
+			policy: ResiliencePolicy{RequestTimeout: 20 * time.Millisecond, InitialBackoff: time.Millisecond},
+			primary: &countingModel{respond: func(ctx context.Context, callback ai.ModelStreamCallback) (*ai.Message, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok || time.Until(deadline) > 20*time.Millisecond {
+					return nil, fmt.Errorf("deadline %v (%t), want the request timeout", deadline, ok)
+				}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}},
+			want:              `{"answer": "fallback"}`,
+			wantPrimaryCalls:  1,
+			wantFallbackCalls: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			agent, config, _ := newFakeAgent(t)
+			primary := test.primary.define(agent, "primary")
+			fallback := answer(`{"answer": "fallback"}`)
+			config.Resilience = test.policy
+			config.Resilience.FallbackModelIds = []string{fallback.define(agent, "fallback")}
+
+			var callback ai.ModelStreamCallback
+			if test.stream {
+				callback = func(ctx context.Context, chunk *ai.ModelResponseChunk) error { return nil }
+			}
+			resp, err := agent.generate(context.Background(), config, primary, test.validate, callback, ai.WithPrompt("Hello"))
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("answer %q, want an error", resp.Text())
+				}
+			} else if err != nil || resp.Text() != test.want {
+				t.Fatalf("answer %v (%v), want %q", resp, err, test.want)
+			}
+			if test.primary.calls != test.wantPrimaryCalls || fallback.calls != test.wantFallbackCalls {
+				t.Fatalf("%d primary and %d fallback calls, want %d and %d",
+					test.primary.calls, fallback.calls, test.wantPrimaryCalls, test.wantFallbackCalls)
+			}
+		})
+	}
+}
+
+func TestGenerateStopsWhenTheCallerGivesUp(t *testing.T) {
+	agent, config, _ := newFakeAgent(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	primary := &countingModel{respond: func(_ context.Context, callback ai.ModelStreamCallback) (*ai.Message, error) {
+		cancel()
+		return nil, errModelLoading
+	}}
+	fallback := answer("fallback")
+	config.Resilience = ResiliencePolicy{MaxRetries: 2, InitialBackoff: time.Millisecond, FallbackModelIds: []string{fallback.define(agent, "fallback")}}
+
+	_, err := agent.generate(ctx, config, primary.define(agent, "primary"), nil, nil, ai.WithPrompt("Hello"))
+	if !errors.Is(err, context.Canceled) || primary.calls != 1 || fallback.calls != 0 {
+		t.Fatalf("%v after %d primary and %d fallback calls, want canceled after 1 call", err, primary.calls, fallback.calls)
+	}
+}
+
+// timeoutError is a net.Error
+type timeoutError struct{ timeout bool }
+
+func (err timeoutError) Error() string   { return "i/o timeout" }
+func (err timeoutError) Timeout() bool   { return err.timeout }
+func (err timeoutError) Temporary() bool { return err.timeout }
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"deadline exceeded", fmt.Errorf("failed to create completion: %w", context.DeadlineExceeded), true},
+		{"network timeout", &url.Error{Op: "Post", URL: "http://localhost/v1", Err: timeoutError{timeout: true}}, true},
+		{"network error without timeout", timeoutError{timeout: false}, false},
+		{"connection refused", &url.Error{Op: "Post", URL: "http://localhost/v1", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, true},
+		{"connection reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"connection closed", &url.Error{Op: "Post", URL: "http://localhost/v1", Err: io.EOF}, true},
+		{"truncated response", &url.Error{Op: "Post", URL: "http://localhost/v1", Err: io.ErrUnexpectedEOF}, true},
+		{"truncated JSON body", fmt.Errorf("decoding the answer: %w", io.ErrUnexpectedEOF), false},
+		{"invalid JSON message", errors.New("invalid character: unexpected EOF in the JSON body"), false},
+		{"timeout in the message", errors.New("the timeout argument is invalid"), false},
+		{"invalid JSON answer", fmt.Errorf("%w: %v", errInvalidJSON, &json.SyntaxError{}), false},
+		{"rate limit", &openai.Error{StatusCode: 429}, true},
+		{"server error", &openai.Error{StatusCode: 503}, true},
+		{"bad request", &openai.Error{StatusCode: 400}, false},
+		{"unavailable", core.NewError(core.UNAVAILABLE, "unavailable"), true},
+		{"not found", core.NewError(core.NOT_FOUND, "model not found"), false},
+		{"loading model", errors.New("503: Loading model"), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsTransientError(test.err); got != test.want {
+				t.Fatalf("IsTransientError(%v) = %t, want %t", test.err, got, test.want)
+			}
+		})
+	}
+}
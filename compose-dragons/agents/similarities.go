@@ -3,32 +3,79 @@ package agents
 import (
 	"context"
 	"fmt"
+	"time"
+
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
 
 	"github.com/firebase/genkit/go/ai"
 )
 
-func retrieveSimilarDocuments(ctx context.Context, query string, retriever ai.Retriever, similarityThreshold float64, similarityMaxResults int) (string, error) {
+// RetrievedChunk is a chunk of the lore added to the context of a completion
+type RetrievedChunk struct {
+	Id       string
+	Source   string
+	Headings []string
+	Content  string
+	// CosineSimilarity is the similarity with the question,
+	// the other scores are set by the hybrid search and the rerank (0 if not used)
+	CosineSimilarity float64
+	BM25Score        float64
+	RRFScore         float64
+	RerankScore      float64
+}
+
+// RetrievalTrace records what the similarity search of a turn added to the context of the model:
+// the lore chunks and the memories, in the order of the context (see NPCAgent.LastRetrieval)
+type RetrievalTrace struct {
+	Query      string
+	Time       time.Time
+	Limit      float64
+	MaxResults int
+	Hybrid     bool
+	Filter     *rag.MetadataFilter
+	Chunks     []RetrievedChunk
+	Memories   []rag.RecalledMemory
+	// Answer is the answer of the completion using this context (see CompletionWithSimilaritySearch)
+	Answer string
+}
+
+// retrievedChunk reads the metadata of a document of the memory vector retriever
+func retrievedChunk(doc *ai.Document) RetrievedChunk {
+	chunk := RetrievedChunk{}
+	chunk.Id, _ = doc.Metadata["id"].(string)
+	chunk.Source, _ = doc.Metadata["source"].(string)
+	chunk.Headings, _ = doc.Metadata["headings"].([]string)
+	chunk.CosineSimilarity, _ = doc.Metadata["cosine_similarity"].(float64)
+	chunk.BM25Score, _ = doc.Metadata["bm25_score"].(float64)
+	chunk.RRFScore, _ = doc.Metadata["rrf_score"].(float64)
+	chunk.RerankScore, _ = doc.Metadata["rerank_score"].(float64)
+	for _, part := range doc.Content {
+		chunk.Content += part.Text
+	}
+	return chunk
+}
+
+// retrieveSimilarDocuments returns the text of the similar documents for the context of the completion
+// and the retrieved chunks
+func retrieveSimilarDocuments(ctx context.Context, query string, retriever ai.Retriever, options rag.MemoryVectorRetrieverOptions) (string, []RetrievedChunk, error) {
 	// Create a query document from the user question
 	queryDoc := ai.DocumentFromText(query, nil)
 
 	// Create a retriever request with custom options
 	request := &ai.RetrieverRequest{
-		Query: queryDoc,
-		Options: rag.MemoryVectorRetrieverOptions{
-			Limit:      similarityThreshold,  // Lower similarity threshold to get more results
-			MaxResults: similarityMaxResults, // Return top N results
-		},
+		Query:   queryDoc,
+		Options: options,
 	}
 
 	// Use the memory vector retriever to find similar documents
 	retrieveResponse, err := retriever.Retrieve(ctx, request)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	similarDocuments := ""
+	chunks := []RetrievedChunk{}
 
 	msg.DisplaySimilarityMessages(
 		"--------------------------------------------------",
@@ -36,16 +83,29 @@ func retrieveSimilarDocuments(ctx context.Context, query string, retriever ai.Re
 	)
 
 	for i, doc := range retrieveResponse.Documents {
-		similarity := doc.Metadata["cosine_similarity"].(float64)
-		id := doc.Metadata["id"].(string)
-		content := doc.Content[0].Text
+		chunk := retrievedChunk(doc)
+		id := chunk.Id
+		content := chunk.Content
+
+		scores := fmt.Sprintf("Similarity: %.4f", chunk.CosineSimilarity)
+		// [HYBRID] scores of the keyword search, the fusion and the rerank
+		if _, ok := doc.Metadata["bm25_score"]; ok {
+			scores += fmt.Sprintf(", BM25: %.4f", chunk.BM25Score)
+		}
+		if _, ok := doc.Metadata["rrf_score"]; ok {
+			scores += fmt.Sprintf(", RRF: %.4f", chunk.RRFScore)
+		}
+		if _, ok := doc.Metadata["rerank_score"]; ok {
+			scores += fmt.Sprintf(", Rerank: %.1f", chunk.RerankScore)
+		}
 
 		msg.DisplaySimilarityMessages(
-			fmt.Sprintf("%d. ID: %s, Similarity: %.4f\n", i+1, id, similarity),
+			fmt.Sprintf("%d. ID: %s, %s\n", i+1, id, scores),
 			fmt.Sprintf("   Content: %s\n\n", content),
 		)
 
 		similarDocuments += content
+		chunks = append(chunks, chunk)
 	}
 
 	msg.DisplaySimilarityMessages(
@@ -53,5 +113,5 @@ func retrieveSimilarDocuments(ctx context.Context, query string, retriever ai.Re
 		"",
 	)
 
-	return similarDocuments, nil
+	return similarDocuments, chunks, nil
 }
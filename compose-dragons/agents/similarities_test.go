@@ -0,0 +1,92 @@
+package agents
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+func TestRetrievalTraceOfAHybridSearch(t *testing.T) {
+	agent, config, _ := newFakeAgent(t)
+	embed := rag.HashingEmbedFunc(64)
+	agent.embedder = rag.DefineHashingEmbedder(agent.genKitInstance, "hashing-64", 64)
+	agent.memoryVectorStore = rag.MemoryVectorStore{Records: map[string]rag.VectorRecord{}}
+	for _, record := range []rag.VectorRecord{
+		{Id: "password", Prompt: "The secret password of the gate is moonlight.", Metadata: rag.RecordMetadata{Source: "lore/gate.md", Headings: []string{"Gate", "Password"}}},
+		{Id: "guard", Prompt: "The guard Grimbold keeps the gate of the dungeon.", Metadata: rag.RecordMetadata{Source: "lore/guard.md"}},
+		{Id: "dragon", Prompt: "A red dragon sleeps in the deepest room.", Metadata: rag.RecordMetadata{Source: "lore/dragon.md"}},
+	} {
+		record.Embedding, _ = embed(context.Background(), record.Prompt)
+		if _, err := agent.memoryVectorStore.Save(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	agent.memoryVectorStore.UseKeywordIndex(rag.NewBM25Index(rag.BM25Options{}))
+	retriever, err := agent.factory.DefineRetriever(retrieverName(agent.Name), &agent.memoryVectorStore, agent.embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.memoryRetriever = retriever
+
+	config.SimilaritySearchLimit = -1
+	config.SimilaritySearchMaxResults = 2
+	config.SimilarityHybridSearch = true
+	// The password first, then the other documents in the fused order
+	config.SimilarityReranker = rag.RerankerFunc(func(ctx context.Context, query string, documents []*ai.Document) ([]float64, error) {
+		scores := make([]float64, len(documents))
+		for i, document := range documents {
+			scores[i] = 1
+			if strings.Contains(document.Content[0].Text, "password") {
+				scores[i] = 9
+			}
+		}
+		return scores, nil
+	})
+
+	if _, err := agent.SimilaritySearch(context.Background(), config, "What is the password of the gate?"); err != nil {
+		t.Fatal(err)
+	}
+	trace := agent.LastRetrieval()
+	if trace == nil {
+		t.Fatal("no retrieval trace")
+	}
+	if trace.Query != "What is the password of the gate?" || !trace.Hybrid || trace.MaxResults != 2 || trace.Limit != -1 {
+		t.Fatalf("trace %+v", trace)
+	}
+	if len(trace.Chunks) != 2 {
+		t.Fatalf("%d chunks, want 2", len(trace.Chunks))
+	}
+
+	chunk := trace.Chunks[0]
+	if chunk.Id != "password" || chunk.Source != "lore/gate.md" || !slices.Equal(chunk.Headings, []string{"Gate", "Password"}) ||
+		chunk.Content != "The secret password of the gate is moonlight." {
+		t.Fatalf("first chunk %+v, want the password", chunk)
+	}
+	if chunk.BM25Score <= 0 || chunk.RRFScore <= 0 || chunk.RerankScore != 9 || chunk.CosineSimilarity == 0 {
+		t.Fatalf("scores of the first chunk: cosine %v, BM25 %v, RRF %v, rerank %v",
+			chunk.CosineSimilarity, chunk.BM25Score, chunk.RRFScore, chunk.RerankScore)
+	}
+	if chunk := trace.Chunks[1]; chunk.RerankScore != 1 || chunk.RRFScore <= 0 || chunk.Headings != nil {
+		t.Fatalf("second chunk %+v", chunk)
+	}
+}
+
+func TestRetrievedChunk(t *testing.T) {
+	// A document of a vector search only: no BM25, RRF and rerank scores
+	doc := ai.DocumentFromText("The guard Grimbold keeps the gate.", map[string]any{
+		"id":                "guard",
+		"source":            "lore/guard.md",
+		"cosine_similarity": 0.8,
+	})
+	chunk := retrievedChunk(doc)
+	want := RetrievedChunk{Id: "guard", Source: "lore/guard.md", Content: "The guard Grimbold keeps the gate.", CosineSimilarity: 0.8}
+	if chunk.Id != want.Id || chunk.Source != want.Source || chunk.Content != want.Content || chunk.CosineSimilarity != want.CosineSimilarity ||
+		chunk.Headings != nil || chunk.BM25Score != 0 || chunk.RRFScore != 0 || chunk.RerankScore != 0 {
+		t.Fatalf("chunk %+v, want %+v", chunk, want)
+	}
+}
@@ -0,0 +1,248 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/helpers"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/ai"
+	"gopkg.in/yaml.v3"
+)
+
+// AgentSpec is the declarative definition of an NPC agent (one YAML file per agent)
+//
+// The environment variables are expanded before parsing, with an optional default value:
+//
+//	name: ${GUARD_NAME:-Huey}
+//	model: ${GUARD_MODEL:-ai/qwen2.5:1.5B-F16}
+//	temperature: ${GUARD_MODEL_TEMPERATURE:-0.0}
+//	top_p: ${GUARD_MODEL_TOP_P:-0.9}
+//	system_instructions_path: ./data/guard_system_instructions.md
+//	context_path: ./data/guard_background_and_personality.md
+//	room: ${GUARD_ROOM:-room_0_2}
+//	request_timeout: 60s
+//	max_retries: 2
+//	fallback_models: [ai/qwen2.5:latest]
+//	tools: []
+type AgentSpec struct {
+	// Id of the agent, the file name without the extension if empty (e.g. "guard")
+	Id   string `yaml:"id"`
+	Name string `yaml:"name"`
+
+	Model           string  `yaml:"model"`
+	EmbeddingsModel string  `yaml:"embeddings_model"`
+	Temperature     float64 `yaml:"temperature"`
+	TopP            float64 `yaml:"top_p"`
+
+	SimilarityLimit      float64 `yaml:"similarity_limit"`
+	SimilarityMaxResults int     `yaml:"similarity_max_results"`
+	// SimilarityFilter selects the knowledge of the agent from the metadata of the chunks
+	SimilarityFilter *rag.MetadataFilter `yaml:"similarity_filter"`
+
+	SystemInstructionsPath string `yaml:"system_instructions_path"`
+	// ContextPath is a file, a directory or a glob pattern of documents (markdown, text, JSON, JSONL, CSV, HTML)
+	ContextPath string `yaml:"context_path"`
+
+	// Resilience settings (see ResiliencePolicy), the defaults config is used if not set
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	MaxRetries     int           `yaml:"max_retries"`
+	FallbackModels []string      `yaml:"fallback_models"`
+
+	// Names of the tools (from the default config tools) available for the agent,
+	// path.Match patterns are allowed (e.g. "local_*")
+	Tools []string `yaml:"tools"`
+	Room  string   `yaml:"room"`
+	// Color used by the UI to display the agent messages (e.g. "#A52A2A")
+	Color string `yaml:"color"`
+
+	// Path of the spec file
+	Path string `yaml:"-"`
+}
+
+// SpecAgent is an agent created from an AgentSpec with its own config
+type SpecAgent struct {
+	Agent  *NPCAgent
+	Config Config
+	Spec   AgentSpec
+}
+
+// LoadAgentSpec reads and parses an agent spec file (*.yaml or *.yml)
+func LoadAgentSpec(specPath string) (AgentSpec, error) {
+	content, err := helpers.ReadTextFile(specPath)
+	if err != nil {
+		return AgentSpec{}, err
+	}
+
+	var spec AgentSpec
+	err = yaml.Unmarshal([]byte(helpers.ExpandEnvWithDefaults(content)), &spec)
+	if err != nil {
+		return AgentSpec{}, fmt.Errorf("invalid agent spec %q: %w", specPath, err)
+	}
+	spec.Path = specPath
+
+	if spec.Id == "" {
+		spec.Id = strings.TrimSuffix(filepath.Base(specPath), filepath.Ext(specPath))
+		spec.Id = strings.TrimSuffix(spec.Id, ".agent")
+	}
+	if spec.Name == "" {
+		return AgentSpec{}, fmt.Errorf("invalid agent spec %q: name is required", specPath)
+	}
+	if spec.Model == "" {
+		return AgentSpec{}, fmt.Errorf("invalid agent spec %q: model is required", specPath)
+	}
+	return spec, nil
+}
+
+// LoadAgentSpecs loads all the agent specs of a directory (non-recursive), sorted by file name
+func LoadAgentSpecs(dirPath string) ([]AgentSpec, error) {
+	files, err := helpers.GetAllFilesInDirectory(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	specs := []AgentSpec{}
+	ids := map[string]string{}
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		spec, err := LoadAgentSpec(file)
+		if err != nil {
+			return nil, err
+		}
+		if otherFile, exists := ids[spec.Id]; exists {
+			return nil, fmt.Errorf("agent id %q is defined in %q and %q", spec.Id, otherFile, file)
+		}
+		ids[spec.Id] = file
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// NewAgentFromSpec creates and initializes an agent from its spec.
+// The defaults config provides the engine URL, the embeddings model,
+// the similarity settings and the tools catalog when the spec does not set them.
+func (factory *AgentFactory) NewAgentFromSpec(ctx context.Context, spec AgentSpec, defaults Config) (*SpecAgent, error) {
+	config := defaults
+	config.EngineURL = factory.EngineURL
+	config.Temperature = spec.Temperature
+	config.TopP = spec.TopP
+
+	config.ChatModelId = openAIModelId(spec.Model)
+	if spec.EmbeddingsModel != "" {
+		config.EmbeddingsModelId = spec.EmbeddingsModel
+	}
+	if spec.SimilarityLimit != 0 {
+		config.SimilaritySearchLimit = spec.SimilarityLimit
+	}
+	if spec.SimilarityMaxResults != 0 {
+		config.SimilaritySearchMaxResults = spec.SimilarityMaxResults
+	}
+	if spec.SimilarityFilter != nil {
+		config.SimilarityFilter = spec.SimilarityFilter
+	}
+
+	if spec.RequestTimeout != 0 {
+		config.Resilience.RequestTimeout = spec.RequestTimeout
+	}
+	if spec.MaxRetries != 0 {
+		config.Resilience.MaxRetries = spec.MaxRetries
+	}
+	if len(spec.FallbackModels) > 0 {
+		config.Resilience.FallbackModelIds = []string{}
+		for _, model := range spec.FallbackModels {
+			config.Resilience.FallbackModelIds = append(config.Resilience.FallbackModelIds, openAIModelId(model))
+		}
+	}
+
+	// Only keep the tools listed by the spec (names or patterns like "local_*")
+	config.Tools = []ai.ToolRef{}
+	for _, toolName := range spec.Tools {
+		found := false
+		for _, tool := range defaults.Tools {
+			if matched, _ := path.Match(toolName, tool.Name()); matched || tool.Name() == toolName {
+				if !slices.Contains(config.Tools, tool) {
+					config.Tools = append(config.Tools, tool)
+				}
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("agent %q: tool %q not found", spec.Id, toolName)
+		}
+	}
+	if len(config.Tools) > 0 && config.ToolsModelId == "" {
+		config.ToolsModelId = config.ChatModelId
+	}
+
+	agent, err := factory.NewAgent(spec.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// [SYSTEM INSTRUCTIONS] Load system instructions from file
+	err = agent.SetSystemInstructionsFromFile(spec.SystemInstructionsPath)
+	if err != nil {
+		return nil, fmt.Errorf("agent %q: %w", spec.Id, err)
+	}
+
+	// [RAG] Initialize vector store from file
+	if spec.ContextPath != "" {
+		err = agent.InitializeVectorStoreFromFile(ctx, config, spec.ContextPath)
+		if err != nil {
+			return nil, fmt.Errorf("agent %q: %w", spec.Id, err)
+		}
+	}
+
+	// [MEMORY] long-term memory of the conversations
+	if config.Memory.Enabled {
+		err = agent.InitializeMemory(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("agent %q: %w", spec.Id, err)
+		}
+	}
+
+	return &SpecAgent{
+		Agent:  agent,
+		Config: config,
+		Spec:   spec,
+	}, nil
+}
+
+// openAIModelId adds the "openai/" prefix of the genkit plugin to the model id if needed
+func openAIModelId(modelId string) string {
+	if strings.HasPrefix(modelId, "openai/") {
+		return modelId
+	}
+	return "openai/" + modelId
+}
+
+// LoadAgentsFromDirectory creates an agent for every spec file of the directory
+func (factory *AgentFactory) LoadAgentsFromDirectory(ctx context.Context, dirPath string, defaults Config) ([]*SpecAgent, error) {
+	specs, err := LoadAgentSpecs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	specAgents := make([]*SpecAgent, 0, len(specs))
+	for _, spec := range specs {
+		specAgent, err := factory.NewAgentFromSpec(ctx, spec, defaults)
+		if err != nil {
+			return nil, err
+		}
+		msg.Display("🤖 Agent loaded from spec:", fmt.Sprintf("%s (%s) %s", specAgent.Spec.Name, specAgent.Spec.Id, specAgent.Spec.Path))
+		specAgents = append(specAgents, specAgent)
+	}
+	return specAgents, nil
+}
@@ -0,0 +1,236 @@
+package agents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// writeSpecs writes the files (name → content) in a temporary directory
+func writeSpecs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	directory := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+func TestLoadAgentSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		want    AgentSpec
+		wantErr string
+	}{
+		{
+			name:    "defaults of the unset variables",
+			file:    "guard.yaml",
+			content: "name: ${TEST_GUARD_NAME:-Huey}\nmodel: ${TEST_GUARD_MODEL:-ai/qwen2.5:1.5B-F16}\ntemperature: ${TEST_GUARD_TEMPERATURE:-0.5}\n",
+			want:    AgentSpec{Id: "guard", Name: "Huey", Model: "ai/qwen2.5:1.5B-F16", Temperature: 0.5},
+		},
+		{
+			name:    "variables set",
+			file:    "guard.yaml",
+			content: "name: ${TEST_GUARD_NAME:-Huey}\nmodel: ${TEST_GUARD_MODEL:-ai/qwen2.5:1.5B-F16}\ntemperature: ${TEST_GUARD_TEMPERATURE:-0.5}\n",
+			env:     map[string]string{"TEST_GUARD_NAME": "Dewey", "TEST_GUARD_MODEL": "ai/qwen3", "TEST_GUARD_TEMPERATURE": "0.8"},
+			want:    AgentSpec{Id: "guard", Name: "Dewey", Model: "ai/qwen3", Temperature: 0.8},
+		},
+		{
+			name:    "variable without default",
+			file:    "guard.yaml",
+			content: "name: Huey\nmodel: ai/qwen3\nroom: ${TEST_GUARD_ROOM}\n",
+			want:    AgentSpec{Id: "guard", Name: "Huey", Model: "ai/qwen3"},
+		},
+		{
+			name:    "id of the .agent file",
+			file:    "merchant.agent.yml",
+			content: "name: Louie\nmodel: ai/qwen3\n",
+			want:    AgentSpec{Id: "merchant", Name: "Louie", Model: "ai/qwen3"},
+		},
+		{
+			name:    "explicit id",
+			file:    "merchant.yaml",
+			content: "id: shop\nname: Louie\nmodel: ai/qwen3\n",
+			want:    AgentSpec{Id: "shop", Name: "Louie", Model: "ai/qwen3"},
+		},
+		{
+			name:    "missing name",
+			file:    "guard.yaml",
+			content: "name: ${TEST_GUARD_NAME}\nmodel: ai/qwen3\n",
+			wantErr: "name is required",
+		},
+		{
+			name:    "missing model",
+			file:    "guard.yaml",
+			content: "name: Huey\n",
+			wantErr: "model is required",
+		},
+		{
+			name:    "invalid YAML",
+			file:    "guard.yaml",
+			content: "name: [Huey\n",
+			wantErr: "invalid agent spec",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"TEST_GUARD_NAME", "TEST_GUARD_MODEL", "TEST_GUARD_TEMPERATURE", "TEST_GUARD_ROOM"} {
+				t.Setenv(name, test.env[name])
+				if _, set := test.env[name]; !set {
+					os.Unsetenv(name)
+				}
+			}
+			specPath := filepath.Join(writeSpecs(t, map[string]string{test.file: test.content}), test.file)
+
+			spec, err := LoadAgentSpec(specPath)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.want.Path = specPath
+			if spec.Id != test.want.Id || spec.Name != test.want.Name || spec.Model != test.want.Model ||
+				spec.Temperature != test.want.Temperature || spec.Room != test.want.Room || spec.Path != test.want.Path {
+				t.Fatalf("spec %+v, want %+v", spec, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadAgentSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantIds []string
+		wantErr string
+	}{
+		{
+			name: "sorted by file name",
+			files: map[string]string{
+				"merchant.yaml":     "name: Louie\nmodel: ai/qwen3\n",
+				"guard.yml":         "name: Huey\nmodel: ai/qwen3\n",
+				"boss.agent.yaml":   "name: Dewey\nmodel: ai/qwen3\n",
+				"notes.md":          "# not an agent",
+				"sorcerer.yaml.bak": "name: Donald\n",
+			},
+			wantIds: []string{"boss", "guard", "merchant"},
+		},
+		{
+			name: "duplicate id",
+			files: map[string]string{
+				"guard.yaml":  "name: Huey\nmodel: ai/qwen3\n",
+				"keeper.yaml": "id: guard\nname: Dewey\nmodel: ai/qwen3\n",
+			},
+			wantErr: `agent id "guard" is defined in`,
+		},
+		{
+			name: "invalid spec",
+			files: map[string]string{
+				"guard.yaml":    "name: Huey\nmodel: ai/qwen3\n",
+				"merchant.yaml": "name: Louie\n",
+			},
+			wantErr: "model is required",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			specs, err := LoadAgentSpecs(writeSpecs(t, test.files))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, spec := range specs {
+				ids = append(ids, spec.Id)
+			}
+			if !slices.Equal(ids, test.wantIds) {
+				t.Fatalf("ids %v, want %v", ids, test.wantIds)
+			}
+		})
+	}
+}
+
+func TestNewAgentFromSpecTools(t *testing.T) {
+	defaults := Config{ChatModelId: "openai/ai/qwen3"}
+	for _, name := range []string{"local_roll_dice", "local_get_map", "c&d_move", "c&d_look"} {
+		defaults.Tools = append(defaults.Tools, ai.NewTool(name, name, func(ctx *ai.ToolContext, input any) (any, error) {
+			return nil, nil
+		}))
+	}
+	instructions := filepath.Join(writeSpecs(t, map[string]string{"instructions.md": "You are a guard."}), "instructions.md")
+
+	tests := []struct {
+		name          string
+		tools         []string
+		wantTools     []string
+		wantToolModel string
+		wantErr       string
+	}{
+		{"no tools", nil, []string{}, "", ""},
+		{"names", []string{"c&d_look", "local_roll_dice"}, []string{"c&d_look", "local_roll_dice"}, "openai/ai/guard", ""},
+		{"pattern", []string{"local_*"}, []string{"local_roll_dice", "local_get_map"}, "openai/ai/guard", ""},
+		{"overlapping patterns", []string{"local_get_map", "local_*", "*_get_*"}, []string{"local_get_map", "local_roll_dice"}, "openai/ai/guard", ""},
+		{"all the tools", []string{"*"}, []string{"local_roll_dice", "local_get_map", "c&d_move", "c&d_look"}, "openai/ai/guard", ""},
+		{"unknown tool", []string{"local_*", "c&d_fly"}, nil, "", `tool "c&d_fly" not found`},
+		{"pattern without tool", []string{"mcp_*"}, nil, "", `tool "mcp_*" not found`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			factory := NewAgentFactory(context.Background(), "http://localhost:0/v1")
+			spec := AgentSpec{
+				Id:                     "guard",
+				Name:                   "Huey",
+				Model:                  "ai/guard",
+				FallbackModels:         []string{"ai/qwen3", "openai/ai/llama"},
+				SystemInstructionsPath: instructions,
+				Tools:                  test.tools,
+			}
+			specAgent, err := factory.NewAgentFromSpec(context.Background(), spec, defaults)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, tool := range specAgent.Config.Tools {
+				names = append(names, tool.Name())
+			}
+			if !slices.Equal(names, test.wantTools) {
+				t.Fatalf("tools %v, want %v", names, test.wantTools)
+			}
+			config := specAgent.Config
+			if config.ChatModelId != "openai/ai/guard" || config.ToolsModelId != test.wantToolModel {
+				t.Fatalf("chat model %q and tools model %q", config.ChatModelId, config.ToolsModelId)
+			}
+			if !slices.Equal(config.Resilience.FallbackModelIds, []string{"openai/ai/qwen3", "openai/ai/llama"}) {
+				t.Fatalf("fallback models %v", config.Resilience.FallbackModelIds)
+			}
+			if len(defaults.Tools) != 4 {
+				t.Fatalf("the default tools were modified: %d tools", len(defaults.Tools))
+			}
+		})
+	}
+}
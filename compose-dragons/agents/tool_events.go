@@ -0,0 +1,113 @@
+package agents
+
+import (
+	"context"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// ToolEventType is the type of an event emitted by the streaming tool calls loop
+type ToolEventType string
+
+const (
+	// ToolEventTextDelta is a chunk of text generated by the model while it detects the tool calls
+	ToolEventTextDelta ToolEventType = "text_delta"
+	// ToolEventToolRequest is emitted when the model asks to call a tool, before its execution
+	ToolEventToolRequest ToolEventType = "tool_request"
+	// ToolEventConfirmationNeeded is emitted before asking the user to confirm a tool call
+	ToolEventConfirmationNeeded ToolEventType = "confirmation_needed"
+	// ToolEventToolResult is emitted after a tool call (executed or cancelled by the user)
+	ToolEventToolResult ToolEventType = "tool_result"
+	// ToolEventDone is the last event of the loop, it holds the result of the tool calls
+	ToolEventDone ToolEventType = "done"
+)
+
+// ToolEvent is an event emitted by the streaming tool calls loop.
+// Only the fields related to the event type are set.
+type ToolEvent struct {
+	Type ToolEventType
+
+	// Text is set for ToolEventTextDelta
+	Text string
+
+	// ToolName, ToolRef and Input are set for the tool events
+	ToolName string
+	ToolRef  string
+	Input    any
+	// Output and Record are set for ToolEventToolResult
+	Output any
+	Record *ToolCallRecord
+
+	// Result is set for ToolEventDone
+	Result *ToolCallsResult
+}
+
+// ToolEventCallback receives the events of the streaming tool calls loop.
+// Returning an error stops the loop and the error is returned to the caller.
+type ToolEventCallback func(ctx context.Context, event ToolEvent) error
+
+// StreamDetectAndExecuteToolCalls detects and executes tool calls automatically (no confirmation)
+// and emits the events of the loop (text deltas, tool requests, tool results, done) to the callback
+//
+// Example:
+//
+//	result, err := agent.StreamDetectAndExecuteToolCalls(ctx, config, userMessage,
+//		func(ctx context.Context, event agents.ToolEvent) error {
+//			switch event.Type {
+//			case agents.ToolEventTextDelta:
+//				fmt.Print(event.Text)
+//			case agents.ToolEventToolRequest:
+//				fmt.Println("🛠️", event.ToolName, event.Input)
+//			case agents.ToolEventToolResult:
+//				fmt.Println("🤖", event.Output)
+//			}
+//			return nil
+//		},
+//	)
+func (agent *NPCAgent) StreamDetectAndExecuteToolCalls(ctx context.Context, config Config, userMessage string, callback ToolEventCallback) (*ToolCallsResult, error) {
+	executor := func(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]ToolCallRecord, stopped *bool) {
+		agent.executeTool(ctx, req, tool, history, toolCallsResults)
+	}
+	return agent.detectAndExecuteToolCallsLoop(ctx, config, userMessage, executor, callback)
+}
+
+// StreamDetectAndExecuteToolCallsWithConfirmation is the streaming version of DetectAndExecuteToolCallsWithConfirmation.
+// A ToolEventConfirmationNeeded event is emitted before each confirmation prompt.
+func (agent *NPCAgent) StreamDetectAndExecuteToolCallsWithConfirmation(ctx context.Context, config Config, userMessage string, callback ToolEventCallback) (*ToolCallsResult, error) {
+	approver := toolApprover(config)
+	// The parallel tool calls can need a confirmation at the same time
+	var mu sync.Mutex
+	var callbackErr error
+	executor := func(ctx context.Context, req *ai.ToolRequest, tool ai.Tool, history *[]*ai.Message, toolCallsResults *[]ToolCallRecord, stopped *bool) {
+		mu.Lock()
+		if callbackErr == nil {
+			callbackErr = emitToolEvent(ctx, callback, ToolEvent{
+				Type:     ToolEventConfirmationNeeded,
+				ToolName: req.Name,
+				ToolRef:  req.Ref,
+				Input:    req.Input,
+			})
+		}
+		failed := callbackErr != nil
+		mu.Unlock()
+		if failed {
+			*stopped = true
+			return
+		}
+		agent.executeToolWithConfirmation(ctx, approver, req, tool, history, toolCallsResults, stopped)
+	}
+	result, err := agent.detectAndExecuteToolCallsLoop(ctx, config, userMessage, executor, callback)
+	if err == nil && callbackErr != nil {
+		return result, callbackErr
+	}
+	return result, err
+}
+
+// emitToolEvent sends the event to the callback (if any)
+func emitToolEvent(ctx context.Context, callback ToolEventCallback, event ToolEvent) error {
+	if callback == nil {
+		return nil
+	}
+	return callback(ctx, event)
+}
@@ -0,0 +1,138 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// textAndToolRequests returns a scripted answer with a text, then the tool requests
+func textAndToolRequests(answer string, requests ...*ai.ToolRequest) func(req *ai.ModelRequest) *ai.Message {
+	return func(req *ai.ModelRequest) *ai.Message {
+		parts := []*ai.Part{ai.NewTextPart(answer)}
+		for _, request := range requests {
+			parts = append(parts, ai.NewToolRequestPart(request))
+		}
+		return ai.NewModelMessage(parts...)
+	}
+}
+
+func TestToolEvents(t *testing.T) {
+	approve := ToolApproverFunc(func(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+		return ToolApproved, nil
+	})
+	reject := ToolApproverFunc(func(ctx context.Context, request ToolApprovalRequest) (ToolApproval, error) {
+		return ToolDenied, nil
+	})
+	tests := []struct {
+		name         string
+		confirmation bool
+		approver     ToolApprover
+		want         []ToolEventType
+		wantStatus   ToolCallStatus
+	}{
+		{
+			name: "without confirmation",
+			want: []ToolEventType{ToolEventTextDelta, ToolEventToolRequest, ToolEventToolResult, ToolEventTextDelta, ToolEventDone},
+		},
+		{
+			name:         "approved",
+			confirmation: true,
+			approver:     approve,
+			want:         []ToolEventType{ToolEventTextDelta, ToolEventToolRequest, ToolEventConfirmationNeeded, ToolEventToolResult, ToolEventTextDelta, ToolEventDone},
+		},
+		{
+			name:         "denied",
+			confirmation: true,
+			approver:     reject,
+			want:         []ToolEventType{ToolEventTextDelta, ToolEventToolRequest, ToolEventConfirmationNeeded, ToolEventToolResult, ToolEventTextDelta, ToolEventDone},
+			wantStatus:   ToolCallCancelled,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			agent, config, _ := newFakeAgent(t, textAndToolRequests("Let me ping.", ping(1, 0)), text("pong 1"))
+			pingTool := genkit.DefineTool(agent.genKitInstance, "ping", "ping", func(ctx *ai.ToolContext, input pingInput) (string, error) {
+				return fmt.Sprint("pong ", input.N), nil
+			})
+			config.ToolsModelId = config.ChatModelId
+			config.Tools = []ai.ToolRef{pingTool}
+			config.ToolApprover = test.approver
+
+			events := []ToolEvent{}
+			callback := func(ctx context.Context, event ToolEvent) error {
+				events = append(events, event)
+				return nil
+			}
+			var result *ToolCallsResult
+			var err error
+			if test.confirmation {
+				result, err = agent.StreamDetectAndExecuteToolCallsWithConfirmation(context.Background(), config, "ping", callback)
+			} else {
+				result, err = agent.StreamDetectAndExecuteToolCalls(context.Background(), config, "ping", callback)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			types := []ToolEventType{}
+			for _, event := range events {
+				types = append(types, event.Type)
+			}
+			if !slices.Equal(types, test.want) {
+				t.Fatalf("events %v, want %v", types, test.want)
+			}
+			if events[0].Text != "Let me ping." {
+				t.Fatalf("text delta %q", events[0].Text)
+			}
+			for _, event := range events[1 : len(events)-2] {
+				if event.ToolName != "ping" || event.ToolRef != "1" {
+					t.Fatalf("%s event of the tool %q (ref %q)", event.Type, event.ToolName, event.ToolRef)
+				}
+			}
+			toolResult := events[len(events)-3]
+			wantStatus := test.wantStatus
+			if wantStatus == "" {
+				wantStatus = ToolCallSucceeded
+			}
+			if toolResult.Record == nil || toolResult.Record.Status != wantStatus {
+				t.Fatalf("tool result record %+v, want %s", toolResult.Record, wantStatus)
+			}
+			if done := events[len(events)-1]; done.Result != result {
+				t.Fatalf("the done event holds %+v, want the result", done.Result)
+			}
+		})
+	}
+}
+
+func TestToolEventCallbackErrorStopsTheLoop(t *testing.T) {
+	agent, config, model := newFakeAgent(t, toolRequests(ping(1, 0)), text("pong 1"))
+	executed := 0
+	pingTool := genkit.DefineTool(agent.genKitInstance, "ping", "ping", func(ctx *ai.ToolContext, input pingInput) (string, error) {
+		executed++
+		return "pong", nil
+	})
+	config.ToolsModelId = config.ChatModelId
+	config.Tools = []ai.ToolRef{pingTool}
+	config.ToolApprover = AlwaysAllowApprover{}
+
+	errStop := errors.New("the player left")
+	_, err := agent.StreamDetectAndExecuteToolCallsWithConfirmation(context.Background(), config, "ping",
+		func(ctx context.Context, event ToolEvent) error {
+			if event.Type == ToolEventConfirmationNeeded {
+				return errStop
+			}
+			return nil
+		})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("error %v, want the error of the callback", err)
+	}
+	if executed != 0 || len(model.requests) != 1 {
+		t.Fatalf("%d executions and %d model requests after the callback error, want 0 and 1", executed, len(model.requests))
+	}
+}
@@ -0,0 +1,124 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// ToolCallStatus is the status of one tool call of the tool calls loop
+type ToolCallStatus string
+
+const (
+	// ToolCallSucceeded: the tool was executed without error
+	ToolCallSucceeded ToolCallStatus = "succeeded"
+	// ToolCallFailed: the tool returned an error, the model got an error tool response
+	ToolCallFailed ToolCallStatus = "failed"
+	// ToolCallCancelled: the tool call was denied by the approver
+	ToolCallCancelled ToolCallStatus = "cancelled"
+	// ToolCallUnknownTool: the model requested a tool that does not exist
+	ToolCallUnknownTool ToolCallStatus = "unknown_tool"
+)
+
+// ToolCallRecord is the result of one tool call
+type ToolCallRecord struct {
+	ToolName string
+	ToolRef  string
+	Input    any
+	// Output is the raw output of the tool (nil if the call did not succeed)
+	Output   any
+	Status   ToolCallStatus
+	Err      error
+	Duration time.Duration
+}
+
+// Succeeded reports whether the tool was executed without error
+func (record ToolCallRecord) Succeeded() bool {
+	return record.Status == ToolCallSucceeded
+}
+
+// Text returns the text of the output of an MCP tool (the first text content),
+// or the output formatted with %v for the other tools
+func (record ToolCallRecord) Text() string {
+	if record.Output == nil {
+		return ""
+	}
+	if text, ok := mcpOutputText(record.Output); ok {
+		return text
+	}
+	return fmt.Sprintf("%v", record.Output)
+}
+
+// LastSucceededCall returns the last successful call of the given tool (any tool if toolName is empty)
+func (result *ToolCallsResult) LastSucceededCall(toolName string) (ToolCallRecord, bool) {
+	for i := len(result.Results) - 1; i >= 0; i-- {
+		record := result.Results[i]
+		if record.Succeeded() && (toolName == "" || record.ToolName == toolName) {
+			return record, true
+		}
+	}
+	return ToolCallRecord{}, false
+}
+
+// Failed returns the calls that did not succeed
+func (result *ToolCallsResult) Failed() []ToolCallRecord {
+	failed := []ToolCallRecord{}
+	for _, record := range result.Results {
+		if !record.Succeeded() {
+			failed = append(failed, record)
+		}
+	}
+	return failed
+}
+
+// toolErrorMessage builds the tool response message sent to the model when a call did not succeed,
+// so every tool request of the history has a matching tool response
+func toolErrorMessage(req *ai.ToolRequest, status ToolCallStatus, err error) *ai.Message {
+	part := ai.NewToolResponsePart(&ai.ToolResponse{
+		Name: req.Name,
+		Ref:  req.Ref,
+		Output: map[string]any{
+			"status": string(status),
+			"error":  err.Error(),
+		},
+	})
+	return ai.NewMessage(ai.RoleTool, nil, part)
+}
+
+// mcpOutputError returns the error of an MCP tool output flagged with "isError"
+func mcpOutputError(output any) error {
+	outputMap, ok := output.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if isError, _ := outputMap["isError"].(bool); !isError {
+		return nil
+	}
+	if text, ok := mcpOutputText(output); ok && text != "" {
+		return errors.New(text)
+	}
+	return errors.New("the MCP tool returned an error")
+}
+
+// mcpOutputText extracts the first text content of an MCP tool output:
+// {"content": [{"type": "text", "text": "..."}]}
+func mcpOutputText(output any) (string, bool) {
+	outputMap, ok := output.(map[string]any)
+	if !ok {
+		return "", false
+	}
+	content, ok := outputMap["content"].([]any)
+	if !ok {
+		return "", false
+	}
+	for _, item := range content {
+		if itemMap, ok := item.(map[string]any); ok {
+			if text, ok := itemMap["text"].(string); ok {
+				return text, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,132 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// mcpOutput returns an MCP tool output with a text content
+func mcpOutput(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []any{map[string]any{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+func TestMCPToolError(t *testing.T) {
+	agent, config, model := newFakeAgent(t,
+		toolRequests(&ai.ToolRequest{Name: "open_door", Ref: "1", Input: map[string]any{}}),
+		text("The door is locked."),
+	)
+	openDoorTool := genkit.DefineTool(agent.genKitInstance, "open_door", "Open the door", func(ctx *ai.ToolContext, input any) (map[string]any, error) {
+		return mcpOutput("the door is locked", true), nil
+	})
+	config.ToolsModelId = config.ChatModelId
+	config.Tools = []ai.ToolRef{openDoorTool}
+
+	result, err := agent.DetectAndExecuteToolCalls(context.Background(), config, "open the door")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 1 {
+		t.Fatalf("%d tool calls, want 1", len(result.Results))
+	}
+	record := result.Results[0]
+	if record.Status != ToolCallFailed || record.Err == nil || record.Err.Error() != "the door is locked" || record.Output != nil {
+		t.Fatalf("record %+v, want a failed call with the text of the MCP output", record)
+	}
+	if _, found := result.LastSucceededCall(""); found {
+		t.Fatal("the failed call is a succeeded call")
+	}
+
+	// The model gets an error tool response
+	if len(model.requests) != 2 {
+		t.Fatalf("%d model requests, want 2", len(model.requests))
+	}
+	messages := model.requests[1].Messages
+	last := messages[len(messages)-1]
+	if last.Role != ai.RoleTool || len(last.Content) != 1 || !last.Content[0].IsToolResponse() {
+		t.Fatalf("last message %+v, want a tool response", last)
+	}
+	response := last.Content[0].ToolResponse
+	output, _ := response.Output.(map[string]any)
+	if response.Name != "open_door" || response.Ref != "1" || output["status"] != "failed" || output["error"] != "the door is locked" {
+		t.Fatalf("tool response %+v", response)
+	}
+}
+
+func TestMCPOutputError(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  any
+		wantErr string
+	}{
+		{"success", mcpOutput("6", false), ""},
+		{"error", mcpOutput("unknown room", true), "unknown room"},
+		{"error without text", map[string]any{"isError": true}, "the MCP tool returned an error"},
+		{"other output", "6", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := mcpOutputError(test.output)
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("error %v", err)
+			}
+			if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+				t.Fatalf("error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestToolCallRecordText(t *testing.T) {
+	tests := []struct {
+		name   string
+		output any
+		want   string
+	}{
+		{"no output", nil, ""},
+		{"MCP output", mcpOutput("room_0_2", false), "room_0_2"},
+		{"other output", 6, "6"},
+	}
+	for _, test := range tests {
+		if got := (ToolCallRecord{Output: test.output}).Text(); got != test.want {
+			t.Errorf("%s: text %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLastSucceededCall(t *testing.T) {
+	result := &ToolCallsResult{Results: []ToolCallRecord{
+		{ToolName: "move", ToolRef: "1", Status: ToolCallSucceeded},
+		{ToolName: "roll_dice", ToolRef: "2", Status: ToolCallSucceeded},
+		{ToolName: "move", ToolRef: "3", Status: ToolCallSucceeded},
+		{ToolName: "move", ToolRef: "4", Status: ToolCallFailed, Err: errors.New("wall")},
+		{ToolName: "look", ToolRef: "5", Status: ToolCallCancelled, Err: errors.New("denied")},
+		{ToolName: "fly", ToolRef: "6", Status: ToolCallUnknownTool, Err: errors.New("unknown")},
+	}}
+	tests := []struct {
+		toolName  string
+		wantRef   string
+		wantFound bool
+	}{
+		{"move", "3", true},
+		{"roll_dice", "2", true},
+		{"", "3", true},
+		{"look", "", false},
+		{"fly", "", false},
+	}
+	for _, test := range tests {
+		record, found := result.LastSucceededCall(test.toolName)
+		if found != test.wantFound || record.ToolRef != test.wantRef {
+			t.Errorf("last succeeded call of %q: ref %q (found %v), want %q", test.toolName, record.ToolRef, found, test.wantRef)
+		}
+	}
+	if failed := result.Failed(); len(failed) != 3 || failed[0].ToolRef != "4" {
+		t.Fatalf("failed calls %+v", failed)
+	}
+}
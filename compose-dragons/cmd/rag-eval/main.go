@@ -0,0 +1,231 @@
+// rag-eval measures the retrieval quality of the NPC stores with YAML suites of questions
+// (see rag.EvalSuite): recall@k, MRR and a sweep of the similarity threshold.
+//
+// Offline, with the hashing embedder (CI):
+//
+//	go run ./compose-dragons/cmd/rag-eval -fake ./dungeon-master/data/eval/*.eval.yaml
+//
+// With the embedding model of the Docker Model Runner, and the answers judged by a chat model:
+//
+//	go run ./compose-dragons/cmd/rag-eval -judge-model ai/qwen2.5:latest ./dungeon-master/data/eval/guard.eval.yaml
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/agents"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/helpers"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func main() {
+	fake := flag.Bool("fake", false, "use the offline hashing embedder instead of the embedding model")
+	dimensions := flag.Int("dimensions", 1024, "dimensions of the hashing embedder")
+	engineURL := flag.String("engine", helpers.GetEnvOrDefault("MODEL_RUNNER_BASE_URL", "http://localhost:12434/engines/llama.cpp/v1"), "URL of the OpenAI compatible engine")
+	embeddingModel := flag.String("embedding-model", helpers.GetEnvOrDefault("EMBEDDING_MODEL", "ai/mxbai-embed-large:latest"), "embedding model")
+	storePath := flag.String("store", "", "vector store file (JSON or binary) instead of embedding the context of the suite")
+	ks := flag.String("k", "1,2,3,5", "cut-offs of the recall")
+	limits := flag.String("limits", "0.3,0.4,0.5,0.6,0.7,0.8", "similarity thresholds of the sweep")
+	hybrid := flag.Bool("hybrid", false, "merge the BM25 keyword results with the vector results")
+	rerankModel := flag.String("rerank-model", "", "chat model reranking the candidates (no rerank if empty)")
+	chunkMaxTokens := flag.Int("chunk-max-tokens", 400, "approximate maximum size of the chunks")
+	chunkOverlapTokens := flag.Int("chunk-overlap-tokens", 64, "approximate size of the overlap of the chunks")
+	judgeModel := flag.String("judge-model", "", "chat model judging the answers (no judge if empty)")
+	chatModel := flag.String("chat-model", "", "chat model generating the answers (the judge model if empty)")
+	minRecall := flag.Float64("min-recall", 0, "exit with an error if the recall@max_results of a suite is lower")
+	minMRR := flag.Float64("min-mrr", 0, "exit with an error if the MRR of a suite is lower")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: rag-eval [flags] suite.yaml...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *fake && (*storePath != "" || *judgeModel != "" || *rerankModel != "") {
+		exit("😡 -fake runs offline: it can't be used with -store, -judge-model or -rerank-model", nil)
+	}
+
+	kValues, err := parseInts(*ks)
+	if err != nil {
+		exit("😡 Invalid -k:", err)
+	}
+	limitValues, err := parseFloats(*limits)
+	if err != nil {
+		exit("😡 Invalid -limits:", err)
+	}
+
+	ctx := context.Background()
+	var g *genkit.Genkit
+	var embedder ai.Embedder
+	modelId := *embeddingModel
+	if *fake {
+		g = genkit.Init(ctx)
+		modelId = fmt.Sprintf("hashing-%d", *dimensions)
+		embedder = rag.DefineHashingEmbedder(g, modelId, *dimensions)
+	} else {
+		factory := agents.NewAgentFactory(ctx, *engineURL)
+		g = factory.Genkit()
+		embedder = factory.Embedder(modelId)
+	}
+
+	options := rag.EvalOptions{K: kValues, Limits: limitValues}
+	if *judgeModel != "" {
+		options.Judge = rag.NewLLMJudge(g, "openai/"+*judgeModel)
+		answerModel := *chatModel
+		if answerModel == "" {
+			answerModel = *judgeModel
+		}
+		options.Answer = answerFunc(g, "openai/"+answerModel)
+	}
+	retrieverOptions := rag.MemoryVectorRetrieverOptions{Hybrid: *hybrid}
+	if *rerankModel != "" {
+		retrieverOptions.Reranker = rag.NewLLMReranker(g, "openai/"+*rerankModel)
+	}
+
+	failed := false
+	for i, suitePath := range flag.Args() {
+		suite, err := rag.LoadEvalSuite(suitePath)
+		if err != nil {
+			exit("😡 Error loading the suite:", err)
+		}
+
+		store := &rag.MemoryVectorStore{Records: map[string]rag.VectorRecord{}}
+		if *storePath != "" {
+			err = store.LoadFromFile(*storePath)
+		} else {
+			err = buildStore(ctx, store, suite.Context, modelId, agents.BatchEmbedFunc(g, embedder), rag.IngestOptions{
+				MaxTokens:     *chunkMaxTokens,
+				OverlapTokens: *chunkOverlapTokens,
+			})
+		}
+		if err != nil {
+			exit("😡 Error creating the vector store of "+suite.Name+":", err)
+		}
+
+		if *hybrid {
+			store.UseKeywordIndex(rag.NewBM25Index(rag.BM25Options{}))
+		}
+		retriever, err := rag.DefineNamedMemoryVectorRetriever(g, fmt.Sprintf("eval-%d-%s", i, suite.Name), store, embedder)
+		if err != nil {
+			exit("😡 Error defining the retriever:", err)
+		}
+		suiteOptions := retrieverOptions
+		suiteOptions.Filter = suite.Filter
+
+		// The sweep contains the threshold of the suite
+		suiteEvalOptions := options
+		if !slices.Contains(limitValues, suite.Limit) {
+			suiteEvalOptions.Limits = append(slices.Clone(limitValues), suite.Limit)
+			slices.Sort(suiteEvalOptions.Limits)
+		}
+
+		report, err := rag.EvaluateRetrieval(ctx, suite, rag.RetrieverFunc(retriever, suiteOptions), suiteEvalOptions)
+		if err != nil {
+			exit("😡 Error evaluating "+suite.Name+":", err)
+		}
+		fmt.Println(report)
+
+		// The recall of the settings of the suite
+		recall := 0.0
+		for _, result := range report.Sweep {
+			if result.Limit == suite.Limit {
+				recall = result.Recall
+			}
+		}
+		if recall < *minRecall || report.MRR < *minMRR {
+			fmt.Printf("❌ %s: recall %.3f (min %.3f), MRR %.3f (min %.3f)\n\n", suite.Name, recall, *minRecall, report.MRR, *minMRR)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// buildStore embeds the chunks of the context documents (in memory, see agents.BuildVectorStore)
+func buildStore(ctx context.Context, store *rag.MemoryVectorStore, contextPath, modelId string, embed rag.BatchEmbedFunc, options rag.IngestOptions) error {
+	if contextPath == "" {
+		return fmt.Errorf("the suite has no context")
+	}
+	ingested, err := rag.IngestFiles(contextPath, options)
+	if err != nil {
+		return err
+	}
+	stats, _, err := agents.BuildVectorStore(ctx, store, ingested, agents.VectorStoreOptions{
+		EmbeddingModel: modelId,
+		Embed:          embed,
+		Rebuild:        rag.RebuildOptions{BatchSize: 16},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🧠 %s: %d records from %d documents (%s)\n", contextPath, len(store.Records), len(ingested.Files), stats)
+	return nil
+}
+
+// answerFunc answers the question with the retrieved documents, as an NPC does
+func answerFunc(g *genkit.Genkit, modelId string) func(ctx context.Context, question string, documents []*ai.Document) (string, error) {
+	return func(ctx context.Context, question string, documents []*ai.Document) (string, error) {
+		var documentsText strings.Builder
+		for _, document := range documents {
+			for _, part := range document.Content {
+				documentsText.WriteString(part.Text)
+			}
+			documentsText.WriteString("\n")
+		}
+		resp, err := genkit.Generate(ctx, g,
+			ai.WithModelName(modelId),
+			ai.WithSystem("Answer the question with the context only. Say that you don't know if the context has no answer."),
+			ai.WithPrompt(fmt.Sprintf("CONTEXT:\n%s\nQUESTION: %s", documentsText.String(), question)),
+			ai.WithConfig(map[string]any{"temperature": 0.0}),
+		)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text(), nil
+	}
+}
+
+func parseInts(values string) ([]int, error) {
+	numbers := []int{}
+	for _, value := range helpers.SplitNonEmpty(values, ",") {
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf("%q is not a positive integer", value)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+func parseFloats(values string) ([]float64, error) {
+	numbers := []float64{}
+	for _, value := range helpers.SplitNonEmpty(values, ",") {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+func exit(message string, err error) {
+	if err != nil {
+		fmt.Println(message, err)
+	} else {
+		fmt.Println(message)
+	}
+	os.Exit(1)
+}
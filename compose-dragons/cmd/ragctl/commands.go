@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/agents"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/helpers"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// build embeds the documents, reusing the embeddings of the existing store (see agents.BuildVectorStore)
+func build(ctx context.Context, args []string) error {
+	flags := newFlagSet("build")
+	embedding := addEmbeddingFlags(flags, helpers.GetEnvOrDefault("EMBEDDING_MODEL", "ai/mxbai-embed-large:latest"))
+	out := flags.String("out", "", "vector store file (default: rag.DefaultVectorStorePath of the documents)")
+	binary := flags.Bool("binary", false, "binary store for the default -out")
+	encodingName := flags.String("encoding", "float32", "encoding of the embeddings of a binary store: float32, float16 or int8")
+	batchSize := flags.Int("batch-size", 16, "number of chunks of one embedding request")
+	concurrency := flags.Int("concurrency", 2, "number of embedding requests running at the same time")
+	chunkMaxTokens := flags.Int("chunk-max-tokens", 400, "approximate maximum size of the chunks")
+	chunkOverlapTokens := flags.Int("chunk-overlap-tokens", 64, "approximate size of the overlap of the chunks")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	encoding, err := rag.ParseVectorEncoding(*encodingName)
+	if err != nil {
+		return err
+	}
+	pattern := flags.Arg(0)
+	path := *out
+	if path == "" {
+		path = rag.DefaultVectorStorePath(pattern, *binary)
+	}
+
+	ingested, err := rag.IngestFiles(pattern, rag.IngestOptions{MaxTokens: *chunkMaxTokens, OverlapTokens: *chunkOverlapTokens})
+	if err != nil {
+		return err
+	}
+	store := &rag.MemoryVectorStore{Records: map[string]rag.VectorRecord{}}
+	if _, err := os.Stat(path); err == nil {
+		if err := store.LoadFromFile(path); err != nil {
+			return err
+		}
+	}
+
+	g, embedder, err := embedding.embedder(ctx, *embedding.model)
+	if err != nil {
+		return err
+	}
+	stats, rebuilt, err := agents.BuildVectorStore(ctx, store, ingested, agents.VectorStoreOptions{
+		Path:           path,
+		Encoding:       encoding,
+		EmbeddingModel: *embedding.model,
+		Embed:          agents.BatchEmbedFunc(g, embedder),
+		Rebuild: rag.RebuildOptions{
+			BatchSize:   *batchSize,
+			Concurrency: *concurrency,
+			Progress: func(embedded, total int) {
+				fmt.Printf("🧠 %d/%d chunks embedded\n", embedded, total)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !rebuilt {
+		fmt.Printf("✅ %s is up to date (%d records)\n", path, len(store.Records))
+		return nil
+	}
+	fmt.Printf("💾 %s: %d records from %d documents (%s)\n", path, len(store.Records), len(ingested.Files), stats)
+	return nil
+}
+
+// list displays one line by record
+func list(ctx context.Context, args []string) error {
+	flags := newFlagSet("list")
+	filters := addFilterFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	store, err := agents.LoadVectorStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	filter := filters.filter()
+
+	count := 0
+	for _, record := range sortedRecords(store) {
+		if filter != nil && !filter.Match(record) {
+			continue
+		}
+		count++
+		fmt.Printf("%s  %-32s  %4d tokens  %s\n", shortId(record.Id), preview(strings.Join(record.Metadata.Headings, " > "), 32), rag.EstimateTokens(record.Prompt), preview(record.Prompt, 60))
+	}
+	fmt.Printf("\n📝 %d/%d records, model %s\n", count, len(store.Records), storeModel(store))
+	return nil
+}
+
+// show displays the records with their metadata
+func show(ctx context.Context, args []string) error {
+	flags := newFlagSet("show")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	store, err := agents.LoadVectorStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	records, err := findRecords(store, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		fmt.Println("🆔 Id:", record.Id)
+		fmt.Println("   Source:", record.Metadata.Source)
+		fmt.Println("   Headings:", strings.Join(record.Metadata.Headings, " > "))
+		if len(record.Metadata.Tags) > 0 {
+			fmt.Println("   Tags:", strings.Join(record.Metadata.Tags, ", "))
+		}
+		if record.Metadata.Owner != "" {
+			fmt.Println("   Owner:", record.Metadata.Owner)
+		}
+		if record.Metadata.Spoiler > 0 {
+			fmt.Println("   Spoiler:", record.Metadata.Spoiler)
+		}
+		for _, key := range slices.Sorted(maps.Keys(record.Metadata.Extra)) {
+			fmt.Printf("   %s: %s\n", key, record.Metadata.Extra[key])
+		}
+		fmt.Printf("   Model: %s (%d dimensions)\n", record.EmbeddingModel, len(record.Embedding))
+		fmt.Println("   Content hash:", record.ContentHash)
+		fmt.Println("   Source hash:", record.SourceHash)
+		fmt.Printf("   Tokens: %d\n\n%s\n\n", rag.EstimateTokens(record.Prompt), record.Prompt)
+	}
+	return nil
+}
+
+// query runs the retriever of the NPCs on the store and displays the scores of the results
+func query(ctx context.Context, args []string) error {
+	flags := newFlagSet("query")
+	embedding := addEmbeddingFlags(flags, "")
+	filters := addFilterFlags(flags)
+	limit := flags.Float64("limit", 0.5, "minimum cosine similarity")
+	maxResults := flags.Int("max", 5, "maximum number of results")
+	hybrid := flags.Bool("hybrid", false, "merge the BM25 keyword results with the vector results")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	store, err := agents.LoadVectorStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	// The question is embedded with the model of the store by default
+	model := *embedding.model
+	if model == "" {
+		model = storeModel(store)
+	}
+	if model == "" {
+		return fmt.Errorf("the store has no embedding model: use -model")
+	}
+
+	g, embedder, err := embedding.embedder(ctx, model)
+	if err != nil {
+		return err
+	}
+	if *hybrid {
+		store.UseKeywordIndex(rag.NewBM25Index(rag.BM25Options{}))
+	}
+	retriever, err := rag.DefineNamedMemoryVectorRetriever(g, "ragctl", store, embedder)
+	if err != nil {
+		return err
+	}
+	resp, err := retriever.Retrieve(ctx, &ai.RetrieverRequest{
+		Query: ai.DocumentFromText(flags.Arg(1), nil),
+		Options: rag.MemoryVectorRetrieverOptions{
+			Limit:      *limit,
+			MaxResults: *maxResults,
+			Hybrid:     *hybrid,
+			Filter:     filters.filter(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔎 %d results (model %s, limit %.2f, max %d, hybrid %t)\n\n", len(resp.Documents), model, *limit, *maxResults, *hybrid)
+	for i, document := range resp.Documents {
+		id, _ := document.Metadata["id"].(string)
+		headings, _ := document.Metadata["headings"].([]string)
+		scores := []string{}
+		for _, score := range []string{"cosine_similarity", "bm25_score", "rrf_score", "rerank_score"} {
+			if value, exists := document.Metadata[score].(float64); exists {
+				scores = append(scores, fmt.Sprintf("%s %.4f", score, value))
+			}
+		}
+		text := ""
+		for _, part := range document.Content {
+			text += part.Text
+		}
+		fmt.Printf("%d. %s  %s\n   %s\n   %s\n\n", i+1, shortId(id), strings.Join(headings, " > "), strings.Join(scores, ", "), preview(text, 120))
+	}
+	return nil
+}
+
+// deleteRecords removes records and saves the store in the same file
+func deleteRecords(ctx context.Context, args []string) error {
+	flags := newFlagSet("delete")
+	encodingName := flags.String("encoding", "float32", "encoding of the embeddings of a binary store: float32, float16 or int8")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	encoding, err := rag.ParseVectorEncoding(*encodingName)
+	if err != nil {
+		return err
+	}
+	path := flags.Arg(0)
+	store, err := agents.LoadVectorStore(path)
+	if err != nil {
+		return err
+	}
+	records, err := findRecords(store, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		store.Delete(record.Id)
+		fmt.Printf("🗑️  %s  %s\n", record.Id, preview(record.Prompt, 60))
+	}
+	if err := agents.SaveVectorStore(store, path, encoding); err != nil {
+		return err
+	}
+	fmt.Printf("💾 %s: %d records\n", path, len(store.Records))
+	return nil
+}
+
+// diff compares the chunks of two stores by content hash
+//
+//   - removed: only in the first store
+//   - added: only in the second store
+//     ~ moved: same content, other source or headings
+func diff(ctx context.Context, args []string) error {
+	flags := newFlagSet("diff")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	before, err := agents.LoadVectorStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	after, err := agents.LoadVectorStore(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	byContent := func(store *rag.MemoryVectorStore) map[string]rag.VectorRecord {
+		records := map[string]rag.VectorRecord{}
+		for _, record := range sortedRecords(store) {
+			contentHash := record.ContentHash
+			if contentHash == "" {
+				contentHash = rag.ContentHash(record.Prompt)
+			}
+			records[contentHash] = record
+		}
+		return records
+	}
+	beforeRecords, afterRecords := byContent(before), byContent(after)
+
+	removed, added, moved, unchanged := 0, 0, 0, 0
+	for _, contentHash := range slices.Sorted(maps.Keys(beforeRecords)) {
+		record := beforeRecords[contentHash]
+		other, exists := afterRecords[contentHash]
+		switch {
+		case !exists:
+			removed++
+			fmt.Printf("- %s  %s  %s\n", shortId(record.Id), strings.Join(record.Metadata.Headings, " > "), preview(record.Prompt, 60))
+		case other.Metadata.Source != record.Metadata.Source || !slices.Equal(other.Metadata.Headings, record.Metadata.Headings):
+			moved++
+			fmt.Printf("~ %s  %s -> %s  %s\n", shortId(record.Id), strings.Join(record.Metadata.Headings, " > "), strings.Join(other.Metadata.Headings, " > "), preview(record.Prompt, 40))
+		default:
+			unchanged++
+		}
+	}
+	for _, contentHash := range slices.Sorted(maps.Keys(afterRecords)) {
+		if _, exists := beforeRecords[contentHash]; !exists {
+			record := afterRecords[contentHash]
+			added++
+			fmt.Printf("+ %s  %s  %s\n", shortId(record.Id), strings.Join(record.Metadata.Headings, " > "), preview(record.Prompt, 60))
+		}
+	}
+
+	fmt.Printf("\n📊 %d removed, %d added, %d moved, %d unchanged\n", removed, added, moved, unchanged)
+	if beforeModel, afterModel := storeModel(before), storeModel(after); beforeModel != afterModel {
+		fmt.Printf("🧠 model %s -> %s\n", beforeModel, afterModel)
+	}
+	return nil
+}
+
+// reembed embeds the records with another model, keeping their ids and metadata
+func reembed(ctx context.Context, args []string) error {
+	flags := newFlagSet("reembed")
+	embedding := addEmbeddingFlags(flags, "")
+	out := flags.String("out", "", "vector store file (default: the store)")
+	encodingName := flags.String("encoding", "float32", "encoding of the embeddings of a binary store: float32, float16 or int8")
+	batchSize := flags.Int("batch-size", 16, "number of chunks of one embedding request")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *embedding.model == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	encoding, err := rag.ParseVectorEncoding(*encodingName)
+	if err != nil {
+		return err
+	}
+	path := flags.Arg(0)
+	if *out == "" {
+		*out = path
+	}
+	store, err := agents.LoadVectorStore(path)
+	if err != nil {
+		return err
+	}
+
+	g, embedder, err := embedding.embedder(ctx, *embedding.model)
+	if err != nil {
+		return err
+	}
+	embed := agents.BatchEmbedFunc(g, embedder)
+	// The store is saved only when all the records are embedded with the same model
+	records := sortedRecords(store)
+	for start := 0; start < len(records); start += max(*batchSize, 1) {
+		batch := records[start:min(start+max(*batchSize, 1), len(records))]
+		texts := make([]string, len(batch))
+		for i, record := range batch {
+			texts[i] = record.Prompt
+		}
+		embeddings, err := embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		if len(embeddings) != len(texts) {
+			return fmt.Errorf("%d embeddings for %d chunks", len(embeddings), len(texts))
+		}
+		for i, record := range batch {
+			record.Embedding = embeddings[i]
+			record.EmbeddingModel = *embedding.model
+			if _, err := store.Save(record); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("🧠 %d/%d records embedded\n", start+len(batch), len(records))
+	}
+	if err := agents.SaveVectorStore(store, *out, encoding); err != nil {
+		return err
+	}
+	fmt.Printf("💾 %s: %d records, model %s\n", *out, len(store.Records), *embedding.model)
+	return nil
+}
@@ -0,0 +1,216 @@
+// ragctl builds, inspects and queries the vector stores of the NPCs without starting the dungeon.
+//
+//	go run ./compose-dragons/cmd/ragctl build ./dungeon-master/data/guard_background_and_personality.md
+//	go run ./compose-dragons/cmd/ragctl list -heading "Secret Keyword" ./dungeon-master/data/guard_background_and_personality.md.vectorstore.json
+//	go run ./compose-dragons/cmd/ragctl show guard.vectorstore.json 27c929bc
+//	go run ./compose-dragons/cmd/ragctl query -max 3 -hybrid guard.vectorstore.json "What is the secret keyword?"
+//	go run ./compose-dragons/cmd/ragctl delete guard.vectorstore.json 27c929bc
+//	go run ./compose-dragons/cmd/ragctl diff old.vectorstore.json new.vectorstore.json
+//	go run ./compose-dragons/cmd/ragctl reembed -model ai/embeddinggemma:latest guard.vectorstore.json
+//
+// The stores are JSON files (.json) or binary files (any other extension, see rag.LoadFromFile).
+// The "hashing-<dimensions>" model is the offline hashing embedder (see rag.HashingEmbedFunc).
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/agents"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/helpers"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+// commands is filled by init: the commands use it for their usage (see newFlagSet)
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"build":   {"build [flags] <documents>: embed the documents (file, directory or glob) in a store", build},
+		"list":    {"list [flags] <store>: list the records", list},
+		"show":    {"show <store> <id>...: display the records (ids or id prefixes)", show},
+		"query":   {"query [flags] <store> <question>: run a similarity search with the scores", query},
+		"delete":  {"delete [flags] <store> <id>...: delete records (ids or id prefixes)", deleteRecords},
+		"diff":    {"diff <store> <other store>: compare the chunks of two stores", diff},
+		"reembed": {"reembed [flags] <store>: embed all the records again with another model", reembed},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, exists := commands[os.Args[1]]
+	if !exists {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(context.Background(), os.Args[2:]); err != nil {
+		fmt.Println("😡", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Println("Usage: ragctl <command> [flags] [arguments] (ragctl <command> -h for the flags)")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Println("  " + commands[name].usage)
+	}
+}
+
+// newFlagSet creates the flag set of a command
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ragctl "+commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// embeddingFlags are the flags of the commands embedding texts
+type embeddingFlags struct {
+	engineURL *string
+	model     *string
+}
+
+func addEmbeddingFlags(flags *flag.FlagSet, defaultModel string) embeddingFlags {
+	return embeddingFlags{
+		engineURL: flags.String("engine", helpers.GetEnvOrDefault("MODEL_RUNNER_BASE_URL", "http://localhost:12434/engines/llama.cpp/v1"), "URL of the OpenAI compatible engine"),
+		model:     flags.String("model", defaultModel, "embedding model (hashing-<dimensions> for the offline hashing embedder)"),
+	}
+}
+
+// embedder returns the genkit instance and the embedder of the model
+func (embedding embeddingFlags) embedder(ctx context.Context, model string) (*genkit.Genkit, ai.Embedder, error) {
+	if dimensions, found := strings.CutPrefix(model, "hashing-"); found {
+		size, err := strconv.Atoi(dimensions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid hashing model %q", model)
+		}
+		g := genkit.Init(ctx)
+		return g, rag.DefineHashingEmbedder(g, model, size), nil
+	}
+	factory := agents.NewAgentFactory(ctx, *embedding.engineURL)
+	return factory.Genkit(), factory.Embedder(model), nil
+}
+
+// filterFlags are the metadata filter flags of list and query
+type filterFlags struct {
+	source  *string
+	heading *string
+	tag     *string
+}
+
+func addFilterFlags(flags *flag.FlagSet) filterFlags {
+	return filterFlags{
+		source:  flags.String("source", "", "only the records of the source files (comma separated)"),
+		heading: flags.String("heading", "", "only the records under the headings (comma separated)"),
+		tag:     flags.String("tag", "", "only the records with the tags (comma separated)"),
+	}
+}
+
+// filter returns the metadata filter of the flags (nil if no flag is set)
+func (filters filterFlags) filter() *rag.MetadataFilter {
+	filter := &rag.MetadataFilter{
+		Sources:  helpers.SplitNonEmpty(*filters.source, ","),
+		Headings: helpers.SplitNonEmpty(*filters.heading, ","),
+		Tags:     helpers.SplitNonEmpty(*filters.tag, ","),
+	}
+	if len(filter.Sources)+len(filter.Headings)+len(filter.Tags) == 0 {
+		return nil
+	}
+	return filter
+}
+
+// storeModel returns the embedding model of most of the records (empty if unknown)
+func storeModel(store *rag.MemoryVectorStore) string {
+	counts := map[string]int{}
+	model := ""
+	for _, record := range store.Records {
+		if record.EmbeddingModel == "" {
+			continue
+		}
+		counts[record.EmbeddingModel]++
+		if counts[record.EmbeddingModel] > counts[model] {
+			model = record.EmbeddingModel
+		}
+	}
+	return model
+}
+
+// findRecords returns the records of the ids or id prefixes, in the order of the arguments
+func findRecords(store *rag.MemoryVectorStore, ids []string) ([]rag.VectorRecord, error) {
+	records := []rag.VectorRecord{}
+	for _, id := range ids {
+		if record, exists := store.Records[id]; exists {
+			records = append(records, record)
+			continue
+		}
+		matches := []rag.VectorRecord{}
+		for recordId, record := range store.Records {
+			if strings.HasPrefix(recordId, id) {
+				matches = append(matches, record)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("no record %q", id)
+		case 1:
+			records = append(records, matches[0])
+		default:
+			return nil, fmt.Errorf("%d records start with %q", len(matches), id)
+		}
+	}
+	return records, nil
+}
+
+// sortedRecords returns the records sorted by source, headings and id
+func sortedRecords(store *rag.MemoryVectorStore) []rag.VectorRecord {
+	records, _ := store.GetAll()
+	slices.SortFunc(records, func(a, b rag.VectorRecord) int {
+		if c := strings.Compare(a.Metadata.Source, b.Metadata.Source); c != 0 {
+			return c
+		}
+		if c := slices.Compare(a.Metadata.Headings, b.Metadata.Headings); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+	return records
+}
+
+// preview returns the first characters of a text on one line
+func preview(text string, length int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) > length {
+		return string(runes[:length]) + "…"
+	}
+	return string(runes)
+}
+
+func shortId(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
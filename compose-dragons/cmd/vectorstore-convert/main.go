@@ -0,0 +1,72 @@
+// vectorstore-convert converts a vector store file between the JSON and the binary formats.
+// The format of the input file is detected, the format of the output file is given by its extension
+// (.json for JSON, binary otherwise).
+//
+// JSON to binary (int8 quantisation):
+//
+//	go run ./compose-dragons/cmd/vectorstore-convert \
+//	  -in ./dungeon-master/data/guard_background_and_personality.md.vectorstore.json \
+//	  -out ./dungeon-master/data/guard_background_and_personality.md.vectorstore.bin \
+//	  -encoding int8
+//
+// Binary to JSON:
+//
+//	go run ./compose-dragons/cmd/vectorstore-convert -in guard.vectorstore.bin -out guard.vectorstore.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/agents"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
+)
+
+func main() {
+	in := flag.String("in", "", "input vector store file (JSON or binary)")
+	out := flag.String("out", "", "output vector store file (.json for JSON, binary otherwise)")
+	encodingName := flag.String("encoding", "float32", "binary output: float32, float16 or int8")
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	encoding, err := rag.ParseVectorEncoding(*encodingName)
+	if err != nil {
+		fmt.Println("😡", err)
+		os.Exit(2)
+	}
+
+	store := &rag.MemoryVectorStore{Records: map[string]rag.VectorRecord{}}
+	start := time.Now()
+	if err := store.LoadFromFile(*in); err != nil {
+		fmt.Println("😡 Error loading the vector store:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("📖 %s: %d records loaded in %v (%s)\n", *in, len(store.Records), time.Since(start), fileSize(*in))
+
+	if err := agents.SaveVectorStore(store, *out, encoding); err != nil {
+		fmt.Println("😡 Error saving the vector store:", err)
+		os.Exit(1)
+	}
+
+	// Check the new file
+	converted := &rag.MemoryVectorStore{Records: map[string]rag.VectorRecord{}}
+	start = time.Now()
+	if err := converted.LoadFromFile(*out); err != nil {
+		fmt.Println("😡 Error loading the converted vector store:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("💾 %s: %d records loaded in %v (%s)\n", *out, len(converted.Records), time.Since(start), fileSize(*out))
+}
+
+func fileSize(filename string) string {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "unknown size"
+	}
+	return fmt.Sprintf("%.1f KB", float64(info.Size())/1024)
+}
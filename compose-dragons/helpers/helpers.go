@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func GetEnvOrDefault(key, defaultValue string) string {
@@ -31,6 +33,15 @@ func StringToFloat(str string) float64 {
 	return num
 }
 
+func StringToDuration(str string) time.Duration {
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		fmt.Println("Cannot convert to duration:", err)
+		return 0
+	}
+	return duration
+}
+
 func StringToBool(str string) bool {
 	val, err := strconv.ParseBool(str)
 	if err != nil {
@@ -39,3 +50,23 @@ func StringToBool(str string) bool {
 	}
 	return val
 }
+
+// ExpandEnvWithDefaults replaces ${VAR} and ${VAR:-default} in the string
+// with the value of the environment variable (or the default value if the variable is empty)
+func ExpandEnvWithDefaults(str string) string {
+	return os.Expand(str, func(key string) string {
+		name, defaultValue, _ := strings.Cut(key, ":-")
+		return GetEnvOrDefault(name, defaultValue)
+	})
+}
+
+// SplitNonEmpty splits a list like "a, b,,c" into trimmed and non empty values
+func SplitNonEmpty(str, sep string) []string {
+	values := []string{}
+	for _, value := range strings.Split(str, sep) {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,213 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// BatchEmbedFunc returns the embeddings of several chunks with one request, in the order of the chunks
+type BatchEmbedFunc func(ctx context.Context, chunks []string) ([][]float32, error)
+
+// Batched returns a BatchEmbedFunc embedding the chunks one by one
+func (embed EmbedFunc) Batched() BatchEmbedFunc {
+	return func(ctx context.Context, chunks []string) ([][]float32, error) {
+		embeddings := make([][]float32, 0, len(chunks))
+		for _, chunk := range chunks {
+			embedding, err := embed(ctx, chunk)
+			if err != nil {
+				return nil, err
+			}
+			embeddings = append(embeddings, embedding)
+		}
+		return embeddings, nil
+	}
+}
+
+// RebuildOptions defines the batches and the concurrency of RebuildWithOptions
+type RebuildOptions struct {
+	// BatchSize is the number of chunks of one embedding request (1 if 0)
+	BatchSize int
+	// Concurrency is the number of embedding requests running at the same time (1 if 0)
+	Concurrency int
+	// Progress is called after each batch with the number of embedded chunks (optional)
+	Progress func(embedded, total int)
+	// Checkpoint saves the partial store every CheckpointEvery batches (10 if 0) and when the rebuild fails,
+	// so the next rebuild only embeds the missing chunks (optional)
+	Checkpoint      func() error
+	CheckpointEvery int
+}
+
+// RebuildWithOptions is Rebuild with batched and concurrent embedding requests (see RebuildOptions).
+// The same content is embedded only once.
+//
+//	chunks to embed: [A B C D E F G]     BatchSize: 3, Concurrency: 2
+//	worker 1: [A B C] ─────► [G] ──┐
+//	worker 2: [D E F] ─────────────┴─► Save ─► Progress ─► Checkpoint
+//
+// If an embedding request fails, the other requests are cancelled, the embedded records are kept
+// in the store (and checkpointed) and the stale records are not removed.
+// The records get the source hash only when the rebuild completes, so a partial store is never up to date (see IsUpToDate).
+func (mvs *MemoryVectorStore) RebuildWithOptions(ctx context.Context, sourceHash string, chunks []Chunk, embeddingModel string, embed BatchEmbedFunc, options RebuildOptions) (RebuildStats, error) {
+	stats := RebuildStats{}
+	if mvs.Records == nil {
+		mvs.Records = make(map[string]VectorRecord)
+	}
+	batchSize := max(options.BatchSize, 1)
+	concurrency := max(options.Concurrency, 1)
+	checkpointEvery := options.CheckpointEvery
+	if checkpointEvery <= 0 {
+		checkpointEvery = 10
+	}
+
+	// Reusable records by content hash (the same chunk can appear several times)
+	reusable := map[string][]VectorRecord{}
+	for _, record := range mvs.Records {
+		if record.EmbeddingModel != embeddingModel {
+			continue
+		}
+		contentHash := record.ContentHash
+		if contentHash == "" {
+			contentHash = ContentHash(record.Prompt)
+		}
+		reusable[contentHash] = append(reusable[contentHash], record)
+	}
+
+	kept := map[string]bool{}
+	// The records to embed by content hash, in the order of the chunks
+	pending := map[string][]VectorRecord{}
+	pendingHashes := []string{}
+	for _, chunk := range chunks {
+		contentHash := ContentHash(chunk.Content)
+		// No SourceHash until the rebuild completes
+		record := VectorRecord{
+			Prompt:         chunk.Content,
+			ContentHash:    contentHash,
+			EmbeddingModel: embeddingModel,
+			Metadata:       chunk.Metadata,
+		}
+
+		if candidates := reusable[contentHash]; len(candidates) > 0 {
+			record.Id = candidates[0].Id
+			record.Embedding = candidates[0].Embedding
+			reusable[contentHash] = candidates[1:]
+			if _, err := mvs.Save(record); err != nil {
+				return stats, err
+			}
+			kept[record.Id] = true
+			stats.Kept++
+			continue
+		}
+		if _, exists := pending[contentHash]; !exists {
+			pendingHashes = append(pendingHashes, contentHash)
+		}
+		pending[contentHash] = append(pending[contentHash], record)
+	}
+
+	batches := [][]string{}
+	for start := 0; start < len(pendingHashes); start += batchSize {
+		batches = append(batches, pendingHashes[start:min(start+batchSize, len(pendingHashes))])
+	}
+
+	embedCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var embedErr error
+	embedded, batchesDone := 0, 0
+
+	jobs := make(chan []string)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				texts := make([]string, len(batch))
+				for i, contentHash := range batch {
+					texts[i] = pending[contentHash][0].Prompt
+				}
+				embeddings, err := embed(embedCtx, texts)
+				if err == nil && len(embeddings) != len(texts) {
+					err = fmt.Errorf("%d embeddings for %d chunks", len(embeddings), len(texts))
+				}
+
+				mu.Lock()
+				if err == nil {
+					err = mvs.saveEmbedded(batch, embeddings, pending, kept, &stats)
+				}
+				if err != nil {
+					if embedErr == nil {
+						embedErr = err
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				embedded += len(batch)
+				batchesDone++
+				if options.Progress != nil {
+					options.Progress(embedded, len(pendingHashes))
+				}
+				if options.Checkpoint != nil && batchesDone%checkpointEvery == 0 && batchesDone < len(batches) {
+					if err := options.Checkpoint(); err != nil && embedErr == nil {
+						embedErr = err
+						cancel()
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, batch := range batches {
+		select {
+		case jobs <- batch:
+		case <-embedCtx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if embedErr == nil && batchesDone < len(batches) {
+		embedErr = ctx.Err()
+	}
+	if embedErr != nil {
+		// [RESUME] the embedded records are reused by the next rebuild
+		if options.Checkpoint != nil && embedded > 0 {
+			embedErr = errors.Join(embedErr, options.Checkpoint())
+		}
+		return stats, embedErr
+	}
+
+	for id := range mvs.Records {
+		if !kept[id] {
+			mvs.Delete(id)
+			stats.Removed++
+		}
+	}
+	// The store matches the source (the indexes do not use the hash)
+	for id, record := range mvs.Records {
+		record.SourceHash = sourceHash
+		mvs.Records[id] = record
+	}
+	return stats, nil
+}
+
+// saveEmbedded saves the records of the embedded batch (the records with the same content share the embedding)
+func (mvs *MemoryVectorStore) saveEmbedded(batch []string, embeddings [][]float32, pending map[string][]VectorRecord, kept map[string]bool, stats *RebuildStats) error {
+	for i, contentHash := range batch {
+		for _, record := range pending[contentHash] {
+			record.Embedding = embeddings[i]
+			record, err := mvs.Save(record)
+			if err != nil {
+				return err
+			}
+			kept[record.Id] = true
+			stats.Embedded++
+		}
+	}
+	return nil
+}
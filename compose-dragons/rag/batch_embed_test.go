@@ -0,0 +1,175 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// batchEmbedRecorder is a BatchEmbedFunc (hashing embeddings) recording the embedded chunks,
+// failing on the call failAt (1 for the first call, 0 never)
+type batchEmbedRecorder struct {
+	mu       sync.Mutex
+	calls    int
+	failAt   int
+	embedded []string
+}
+
+var errEmbedding = errors.New("embedding model unavailable")
+
+func (recorder *batchEmbedRecorder) embed(ctx context.Context, chunks []string) ([][]float32, error) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.calls++
+	if recorder.calls == recorder.failAt {
+		return nil, errEmbedding
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	embed := HashingEmbedFunc(32)
+	embeddings := [][]float32{}
+	for _, chunk := range chunks {
+		embedding, _ := embed(ctx, chunk)
+		embeddings = append(embeddings, embedding)
+	}
+	recorder.embedded = append(recorder.embedded, chunks...)
+	return embeddings, nil
+}
+
+func numberedChunks(n int) []Chunk {
+	chunks := []Chunk{}
+	for i := range n {
+		chunks = append(chunks, Chunk{Content: fmt.Sprintf("chunk %d", i)})
+	}
+	return chunks
+}
+
+func TestRebuildResumesAfterAnInterruption(t *testing.T) {
+	tests := []struct {
+		name string
+		// interrupt makes the rebuild fail after 2 batches of 2 chunks
+		interrupt func(recorder *batchEmbedRecorder, options *RebuildOptions, cancel context.CancelFunc)
+		wantErr   error
+	}{
+		{
+			name: "embedding error",
+			interrupt: func(recorder *batchEmbedRecorder, options *RebuildOptions, cancel context.CancelFunc) {
+				recorder.failAt = 3
+			},
+			wantErr: errEmbedding,
+		},
+		{
+			name: "cancellation",
+			interrupt: func(recorder *batchEmbedRecorder, options *RebuildOptions, cancel context.CancelFunc) {
+				options.Progress = func(embedded, total int) {
+					if embedded == 4 {
+						cancel()
+					}
+				}
+			},
+			wantErr: context.Canceled,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+			chunks := numberedChunks(10)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			recorder := &batchEmbedRecorder{}
+			checkpoints := 0
+			options := RebuildOptions{BatchSize: 2, Checkpoint: func() error {
+				checkpoints++
+				return nil
+			}}
+			test.interrupt(recorder, &options, cancel)
+
+			_, err := store.RebuildWithOptions(ctx, "source", chunks, "model", recorder.embed, options)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("first rebuild: %v, want %v", err, test.wantErr)
+			}
+			if len(store.Records) != 4 || checkpoints != 1 {
+				t.Fatalf("%d records and %d checkpoints after the interruption, want 4 and 1", len(store.Records), checkpoints)
+			}
+			if store.IsUpToDate("source", "model") {
+				t.Fatal("the partial store is up to date")
+			}
+
+			// The next rebuild only embeds the missing chunks
+			recorder = &batchEmbedRecorder{}
+			stats, err := store.RebuildWithOptions(context.Background(), "source", chunks, "model", recorder.embed, RebuildOptions{BatchSize: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (RebuildStats{Kept: 4, Embedded: 6}); stats != want {
+				t.Fatalf("second rebuild: %s, want %s", stats, want)
+			}
+			want := []string{}
+			for _, chunk := range chunks[4:] {
+				want = append(want, chunk.Content)
+			}
+			if !slices.Equal(recorder.embedded, want) {
+				t.Fatalf("second rebuild embedded %v, want %v", recorder.embedded, want)
+			}
+			if !store.IsUpToDate("source", "model") {
+				t.Fatal("the store is not up to date after the rebuild")
+			}
+		})
+	}
+}
+
+func TestRebuildWithOptions(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         RebuildOptions
+		wantCalls       int
+		wantCheckpoints int
+	}{
+		{"one by one", RebuildOptions{}, 12, 1},
+		{"batches", RebuildOptions{BatchSize: 5}, 3, 0},
+		{"concurrent batches", RebuildOptions{BatchSize: 2, Concurrency: 3, CheckpointEvery: 2}, 6, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// 12 chunks, and a duplicate embedded once
+			chunks := append(numberedChunks(12), Chunk{Content: "chunk 0"})
+			store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+			recorder := &batchEmbedRecorder{}
+			checkpoints := 0
+			progress := []int{}
+			test.options.Checkpoint = func() error {
+				checkpoints++
+				return nil
+			}
+			test.options.Progress = func(embedded, total int) {
+				progress = append(progress, embedded)
+				if total != 12 {
+					t.Errorf("progress total %d, want 12", total)
+				}
+			}
+
+			stats, err := store.RebuildWithOptions(context.Background(), "source", chunks, "model", recorder.embed, test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (RebuildStats{Embedded: 13}); stats != want || len(store.Records) != 13 {
+				t.Fatalf("%s and %d records, want %s", stats, len(store.Records), want)
+			}
+			if len(recorder.embedded) != 12 || recorder.calls != test.wantCalls {
+				t.Fatalf("%d chunks embedded in %d calls, want 12 in %d calls", len(recorder.embedded), recorder.calls, test.wantCalls)
+			}
+			// The last checkpoint is the save of the caller
+			if checkpoints != test.wantCheckpoints {
+				t.Fatalf("%d checkpoints, want %d", checkpoints, test.wantCheckpoints)
+			}
+			if len(progress) != test.wantCalls || progress[len(progress)-1] != 12 || !slices.IsSorted(progress) {
+				t.Fatalf("progress %v", progress)
+			}
+		})
+	}
+}
@@ -0,0 +1,502 @@
+package rag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// VectorEncoding is the encoding of the embeddings in the binary vector store format
+type VectorEncoding uint8
+
+const (
+	// Float32Encoding keeps the exact embeddings (4 bytes by dimension)
+	Float32Encoding VectorEncoding = iota
+	// Float16Encoding halves the size (2 bytes by dimension, ~3 significant digits)
+	Float16Encoding
+	// Int8Encoding quantises the embeddings with a scale by vector (1 byte by dimension)
+	Int8Encoding
+)
+
+// String returns the name of the encoding (see ParseVectorEncoding)
+func (encoding VectorEncoding) String() string {
+	switch encoding {
+	case Float32Encoding:
+		return "float32"
+	case Float16Encoding:
+		return "float16"
+	case Int8Encoding:
+		return "int8"
+	}
+	return fmt.Sprintf("encoding(%d)", uint8(encoding))
+}
+
+// ParseVectorEncoding returns the encoding named "float32", "float16" or "int8"
+func ParseVectorEncoding(name string) (VectorEncoding, error) {
+	for _, encoding := range []VectorEncoding{Float32Encoding, Float16Encoding, Int8Encoding} {
+		if encoding.String() == name {
+			return encoding, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown vector encoding %q (float32, float16 or int8)", name)
+}
+
+// binaryStoreMagic starts the binary vector store files
+var binaryStoreMagic = []byte("CDVS")
+
+const binaryStoreVersion = 1
+
+// The limits of the sizes read from a binary file, so a corrupt file returns an error
+// instead of allocating gigabytes (the sizes are also checked against the size of the file)
+const (
+	maxBinaryDimension    = 1 << 16
+	maxBinaryStringLength = 1 << 26
+)
+
+// WriteBinary writes the records of the store in the compact binary format (little endian):
+//
+//	header:  "CDVS" | version uint16 | encoding uint8 | dimension uint32 | model id string | records uint32
+//	record:  id string | prompt string | metadata count uint16 | (key string | value string)... | vector
+//	vector:  float32: dimension × float32
+//	         float16: dimension × uint16
+//	         int8:    scale float32 | dimension × int8
+//	string:  length uint32 | UTF-8 bytes
+//
+// The model id of the header is the embedding model of the records (empty if they use several models).
+// The records are written in id order, so the same store always gives the same file.
+func (mvs *MemoryVectorStore) WriteBinary(w io.Writer, encoding VectorEncoding) error {
+	if _, err := ParseVectorEncoding(encoding.String()); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(mvs.Records))
+	for id := range mvs.Records {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	dimension := 0
+	model := ""
+	for i, id := range ids {
+		record := mvs.Records[id]
+		if i == 0 {
+			dimension = len(record.Embedding)
+			model = record.EmbeddingModel
+		}
+		if len(record.Embedding) != dimension {
+			return fmt.Errorf("record %q has %d dimensions, the store has %d", id, len(record.Embedding), dimension)
+		}
+		if record.EmbeddingModel != model {
+			model = ""
+		}
+	}
+
+	writer := &binaryWriter{w: bufio.NewWriter(w)}
+	writer.bytes(binaryStoreMagic)
+	writer.uint16(binaryStoreVersion)
+	writer.bytes([]byte{byte(encoding)})
+	writer.uint32(uint32(dimension))
+	writer.string(model)
+	writer.uint32(uint32(len(ids)))
+
+	for _, id := range ids {
+		record := mvs.Records[id]
+		writer.string(record.Id)
+		writer.string(record.Prompt)
+		metadata := recordMetadata(record)
+		writer.uint16(uint16(len(metadata) / 2))
+		for _, value := range metadata {
+			writer.string(value)
+		}
+		writer.vector(record.Embedding, encoding)
+	}
+	if writer.err != nil {
+		return writer.err
+	}
+	return writer.w.Flush()
+}
+
+// ReadBinary loads the records of the binary format (see WriteBinary) one by one,
+// the records are saved in the store (and its indexes)
+func (mvs *MemoryVectorStore) ReadBinary(r io.Reader) error {
+	return mvs.readBinary(r, -1)
+}
+
+// readBinary is ReadBinary with the size of the data (-1 if unknown)
+func (mvs *MemoryVectorStore) readBinary(r io.Reader, size int64) error {
+	reader := &binaryReader{r: bufio.NewReader(r), remaining: size}
+	magic := reader.bytes(len(binaryStoreMagic))
+	if reader.err == nil && !bytes.Equal(magic, binaryStoreMagic) {
+		return fmt.Errorf("not a binary vector store")
+	}
+	version := reader.uint16()
+	if reader.err == nil && version != binaryStoreVersion {
+		return fmt.Errorf("unsupported binary vector store version %d", version)
+	}
+	encoding := VectorEncoding(reader.bytes(1)[0])
+	dimension := int(reader.uint32())
+	reader.string() // model id, also in the metadata of the records
+	count := int(reader.uint32())
+	if reader.err != nil {
+		return reader.err
+	}
+	if _, err := ParseVectorEncoding(encoding.String()); err != nil {
+		return err
+	}
+	if dimension > maxBinaryDimension {
+		return fmt.Errorf("corrupt binary vector store: %d dimensions", dimension)
+	}
+	// A record takes at least 10 bytes (id and prompt lengths, metadata count) and its vector
+	if minSize := int64(count) * int64(10+encoding.vectorSize(dimension)); reader.remaining >= 0 && minSize > reader.remaining {
+		return fmt.Errorf("corrupt binary vector store: %d records in %d bytes", count, reader.remaining)
+	}
+
+	if mvs.Records == nil {
+		mvs.Records = make(map[string]VectorRecord, min(count, 1<<16))
+	}
+	for i := 0; i < count; i++ {
+		record := VectorRecord{
+			Id:     reader.string(),
+			Prompt: reader.string(),
+		}
+		metadataCount := int(reader.uint16())
+		for j := 0; j < metadataCount && reader.err == nil; j++ {
+			setRecordMetadata(&record, reader.string(), reader.string())
+		}
+		record.Embedding = reader.vector(dimension, encoding)
+		if reader.err != nil {
+			return fmt.Errorf("record %d/%d: %w", i+1, count, reader.err)
+		}
+		if _, err := mvs.Save(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveBinaryToFile persists the vector store to a binary file (see WriteBinary).
+// The file is replaced atomically (see writeFileAtomically).
+func (mvs *MemoryVectorStore) SaveBinaryToFile(filename string, encoding VectorEncoding) error {
+	return writeFileAtomically(filename, func(w io.Writer) error {
+		return mvs.WriteBinary(w, encoding)
+	})
+}
+
+// LoadFromBinaryFile loads the vector store from a binary file (see WriteBinary)
+func (mvs *MemoryVectorStore) LoadFromBinaryFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return mvs.readBinary(file, info.Size())
+}
+
+// writeFileAtomically writes a temporary file next to the file, then renames it:
+// an interrupted save (crash, full disk) keeps the previous file intact
+func writeFileAtomically(filename string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // no-op after the rename
+
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// LoadFromFile loads the vector store from a binary or a JSON file (the format is detected)
+func (mvs *MemoryVectorStore) LoadFromFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, len(binaryStoreMagic))
+	_, err = io.ReadFull(file, magic)
+	file.Close()
+	if err == nil && bytes.Equal(magic, binaryStoreMagic) {
+		return mvs.LoadFromBinaryFile(filename)
+	}
+	return mvs.LoadFromJSONFile(filename)
+}
+
+// recordMetadata returns the keys and values of the (non empty) metadata of a record.
+// The lists are joined with new lines, the Extra keys are prefixed with "extra.".
+func recordMetadata(record VectorRecord) []string {
+	entries := [][2]string{
+		{"content_hash", record.ContentHash},
+		{"source_hash", record.SourceHash},
+		{"embedding_model", record.EmbeddingModel},
+		{"source", record.Metadata.Source},
+		{"headings", strings.Join(record.Metadata.Headings, "\n")},
+		{"tags", strings.Join(record.Metadata.Tags, "\n")},
+		{"owner", record.Metadata.Owner},
+	}
+	if record.Metadata.Spoiler != 0 {
+		entries = append(entries, [2]string{"spoiler", strconv.Itoa(record.Metadata.Spoiler)})
+	}
+	for _, key := range slices.Sorted(maps.Keys(record.Metadata.Extra)) {
+		entries = append(entries, [2]string{"extra." + key, record.Metadata.Extra[key]})
+	}
+
+	metadata := []string{}
+	for _, entry := range entries {
+		if entry[1] != "" {
+			metadata = append(metadata, entry[0], entry[1])
+		}
+	}
+	return metadata
+}
+
+// setRecordMetadata sets a metadata of a record (the unknown keys are ignored)
+func setRecordMetadata(record *VectorRecord, key, value string) {
+	switch key {
+	case "content_hash":
+		record.ContentHash = value
+	case "source_hash":
+		record.SourceHash = value
+	case "embedding_model":
+		record.EmbeddingModel = value
+	case "source":
+		record.Metadata.Source = value
+	case "headings":
+		record.Metadata.Headings = strings.Split(value, "\n")
+	case "tags":
+		record.Metadata.Tags = strings.Split(value, "\n")
+	case "owner":
+		record.Metadata.Owner = value
+	case "spoiler":
+		record.Metadata.Spoiler, _ = strconv.Atoi(value)
+	default:
+		if extraKey, ok := strings.CutPrefix(key, "extra."); ok {
+			if record.Metadata.Extra == nil {
+				record.Metadata.Extra = map[string]string{}
+			}
+			record.Metadata.Extra[extraKey] = value
+		}
+	}
+}
+
+// binaryWriter keeps the first error, so the format is written without checking every call
+type binaryWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (writer *binaryWriter) bytes(data []byte) {
+	if writer.err == nil {
+		_, writer.err = writer.w.Write(data)
+	}
+}
+
+func (writer *binaryWriter) uint16(value uint16) {
+	writer.bytes(binary.LittleEndian.AppendUint16(nil, value))
+}
+
+func (writer *binaryWriter) uint32(value uint32) {
+	writer.bytes(binary.LittleEndian.AppendUint32(nil, value))
+}
+
+func (writer *binaryWriter) string(value string) {
+	writer.uint32(uint32(len(value)))
+	writer.bytes([]byte(value))
+}
+
+func (writer *binaryWriter) vector(vector []float32, encoding VectorEncoding) {
+	var data []byte
+	switch encoding {
+	case Float32Encoding:
+		data = make([]byte, 0, 4*len(vector))
+		for _, value := range vector {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(value))
+		}
+	case Float16Encoding:
+		data = make([]byte, 0, 2*len(vector))
+		for _, value := range vector {
+			data = binary.LittleEndian.AppendUint16(data, float32ToFloat16(value))
+		}
+	case Int8Encoding:
+		maxAbs := float32(0)
+		for _, value := range vector {
+			maxAbs = max(maxAbs, float32(math.Abs(float64(value))))
+		}
+		scale := maxAbs / 127
+		data = binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(vector)), math.Float32bits(scale))
+		for _, value := range vector {
+			quantised := int8(0)
+			if scale > 0 {
+				quantised = int8(math.Round(float64(value / scale)))
+			}
+			data = append(data, byte(quantised))
+		}
+	}
+	writer.bytes(data)
+}
+
+// binaryReader keeps the first error, like binaryWriter
+type binaryReader struct {
+	r   *bufio.Reader
+	err error
+	// remaining is the number of bytes left in the data (-1 if unknown)
+	remaining int64
+}
+
+func (reader *binaryReader) bytes(n int) []byte {
+	if reader.err == nil && reader.remaining >= 0 && int64(n) > reader.remaining {
+		// The size read from the data is larger than the data: nothing is allocated
+		reader.err = io.ErrUnexpectedEOF
+	}
+	if reader.err != nil {
+		return make([]byte, max(n, 0))
+	}
+	data := make([]byte, n)
+	_, reader.err = io.ReadFull(reader.r, data)
+	if errors.Is(reader.err, io.EOF) {
+		reader.err = io.ErrUnexpectedEOF
+	}
+	if reader.remaining >= 0 {
+		reader.remaining -= int64(n)
+	}
+	return data
+}
+
+func (reader *binaryReader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(reader.bytes(2))
+}
+
+func (reader *binaryReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(reader.bytes(4))
+}
+
+func (reader *binaryReader) string() string {
+	length := reader.uint32()
+	if reader.err == nil && length > maxBinaryStringLength {
+		reader.err = fmt.Errorf("corrupt binary vector store: string of %d bytes", length)
+	}
+	if reader.err != nil {
+		return ""
+	}
+	return string(reader.bytes(int(length)))
+}
+
+func (reader *binaryReader) vector(dimension int, encoding VectorEncoding) []float32 {
+	vector := make([]float32, dimension)
+	switch encoding {
+	case Float32Encoding:
+		data := reader.bytes(4 * dimension)
+		for i := range vector {
+			vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+		}
+	case Float16Encoding:
+		data := reader.bytes(2 * dimension)
+		for i := range vector {
+			vector[i] = float16ToFloat32(binary.LittleEndian.Uint16(data[2*i:]))
+		}
+	case Int8Encoding:
+		scale := math.Float32frombits(reader.uint32())
+		data := reader.bytes(dimension)
+		for i := range vector {
+			vector[i] = float32(int8(data[i])) * scale
+		}
+	}
+	return vector
+}
+
+// vectorSize returns the number of bytes of an encoded vector of the dimension
+func (encoding VectorEncoding) vectorSize(dimension int) int {
+	switch encoding {
+	case Float16Encoding:
+		return 2 * dimension
+	case Int8Encoding:
+		return 4 + dimension
+	}
+	return 4 * dimension
+}
+
+// float32ToFloat16 converts to the IEEE 754 half precision (rounded to the nearest)
+func float32ToFloat16(value float32) uint16 {
+	bits := math.Float32bits(value)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int((bits>>23)&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+
+	switch {
+	case (bits>>23)&0xff == 0xff:
+		// Infinity and NaN
+		if mantissa != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exponent >= 0x1f:
+		// Too large: infinity
+		return sign | 0x7c00
+	case exponent <= 0:
+		// Subnormal half (or zero)
+		if exponent < -10 {
+			return sign
+		}
+		mantissa |= 0x800000
+		shift := uint32(14 - exponent)
+		half := uint16(mantissa >> shift)
+		if (mantissa>>(shift-1))&1 != 0 {
+			half++
+		}
+		return sign | half
+	}
+	half := sign | uint16(exponent)<<10 | uint16(mantissa>>13)
+	if mantissa&0x1000 != 0 {
+		// The carry can go to the exponent, it is still the nearest value
+		half++
+	}
+	return half
+}
+
+// float16ToFloat32 converts from the IEEE 754 half precision
+func float16ToFloat32(half uint16) float32 {
+	sign := uint32(half&0x8000) << 16
+	exponent := uint32(half>>10) & 0x1f
+	mantissa := uint32(half & 0x3ff)
+
+	switch exponent {
+	case 0:
+		if mantissa == 0 {
+			return math.Float32frombits(sign)
+		}
+		// Subnormal: normalize the mantissa
+		exponent = 127 - 15 + 1
+		for mantissa&0x400 == 0 {
+			mantissa <<= 1
+			exponent--
+		}
+		mantissa &= 0x3ff
+		return math.Float32frombits(sign | exponent<<23 | mantissa<<13)
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | mantissa<<13)
+	}
+	return math.Float32frombits(sign | (exponent-15+127)<<23 | mantissa<<13)
+}
@@ -0,0 +1,219 @@
+package rag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// binaryTestStore returns a store of 20 records of 64 dimensions, with metadata
+func binaryTestStore() *MemoryVectorStore {
+	random := rand.New(rand.NewSource(1))
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	for i := range 20 {
+		store.Save(VectorRecord{
+			Id:             string(rune('a' + i)),
+			Prompt:         "room " + string(rune('A'+i)),
+			Embedding:      randomVector(random, 64, nil, 1),
+			ContentHash:    ContentHash(string(rune('A' + i))),
+			SourceHash:     "source",
+			EmbeddingModel: "model",
+			Metadata: RecordMetadata{
+				Source:   "rooms.md",
+				Headings: []string{"Dungeon", "Rooms"},
+				Owner:    "guard",
+				Spoiler:  i % 3,
+				Extra:    map[string]string{"level": "1"},
+			},
+		})
+	}
+	return store
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	tests := []struct {
+		encoding VectorEncoding
+		// maxError returns the maximal error of a value of the vector
+		maxError func(value, maxAbs float64) float64
+	}{
+		{Float32Encoding, func(value, maxAbs float64) float64 { return 0 }},
+		{Float16Encoding, func(value, maxAbs float64) float64 { return math.Abs(value) / 2048 }},
+		{Int8Encoding, func(value, maxAbs float64) float64 { return maxAbs / 254 * 1.0001 }},
+	}
+	for _, test := range tests {
+		t.Run(test.encoding.String(), func(t *testing.T) {
+			store := binaryTestStore()
+			buffer := &bytes.Buffer{}
+			if err := store.WriteBinary(buffer, test.encoding); err != nil {
+				t.Fatal(err)
+			}
+			loaded := &MemoryVectorStore{}
+			if err := loaded.ReadBinary(bytes.NewReader(buffer.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			if len(loaded.Records) != len(store.Records) {
+				t.Fatalf("%d records, want %d", len(loaded.Records), len(store.Records))
+			}
+
+			for id, want := range store.Records {
+				got := loaded.Records[id]
+				if got.Prompt != want.Prompt || got.ContentHash != want.ContentHash || got.SourceHash != want.SourceHash ||
+					got.EmbeddingModel != want.EmbeddingModel || !reflect.DeepEqual(got.Metadata, want.Metadata) {
+					t.Fatalf("record %s = %+v, want %+v", id, got, want)
+				}
+				maxAbs := 0.0
+				for _, value := range want.Embedding {
+					maxAbs = max(maxAbs, math.Abs(float64(value)))
+				}
+				for i, value := range want.Embedding {
+					if diff := math.Abs(float64(got.Embedding[i] - value)); diff > test.maxError(float64(value), maxAbs) {
+						t.Fatalf("record %s, dimension %d: %v, want %v", id, i, got.Embedding[i], value)
+					}
+				}
+			}
+
+			// The same store always gives the same file
+			again := &bytes.Buffer{}
+			if err := store.WriteBinary(again, test.encoding); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buffer.Bytes(), again.Bytes()) {
+				t.Fatal("the two files of the same store are different")
+			}
+		})
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float32
+		want  float32
+	}{
+		{"zero", 0, 0},
+		{"one", 1, 1},
+		{"negative", -0.5, -0.5},
+		{"rounded", 0.1, 0.0999755859375},
+		{"largest", 65504, 65504},
+		{"too large", 1e6, float32(math.Inf(1))},
+		{"infinity", float32(math.Inf(-1)), float32(math.Inf(-1))},
+		{"subnormal", 6e-8, 5.9604645e-08},
+		{"too small", 1e-10, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := float16ToFloat32(float32ToFloat16(test.value)); got != test.want {
+				t.Fatalf("float16(%v) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+	if nan := float16ToFloat32(float32ToFloat16(float32(math.NaN()))); !math.IsNaN(float64(nan)) {
+		t.Fatalf("float16(NaN) = %v", nan)
+	}
+}
+
+// binaryHeader returns a header of the binary format
+func binaryHeader(encoding VectorEncoding, dimension, count uint32) []byte {
+	header := append([]byte{}, binaryStoreMagic...)
+	header = binary.LittleEndian.AppendUint16(header, binaryStoreVersion)
+	header = append(header, byte(encoding))
+	header = binary.LittleEndian.AppendUint32(header, dimension)
+	header = binary.LittleEndian.AppendUint32(header, 0) // model id
+	return binary.LittleEndian.AppendUint32(header, count)
+}
+
+func TestReadCorruptBinary(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	if err := binaryTestStore().WriteBinary(buffer, Int8Encoding); err != nil {
+		t.Fatal(err)
+	}
+	file := buffer.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a store", []byte(`{"a": {}}`)},
+		{"truncated header", file[:10]},
+		{"truncated record", file[:len(file)/2]},
+		{"missing last byte", file[:len(file)-1]},
+		{"unknown encoding", binaryHeader(7, 4, 0)},
+		{"huge dimension", binaryHeader(Float32Encoding, math.MaxUint32, 1)},
+		{"huge record count", binaryHeader(Float32Encoding, 4, math.MaxUint32)},
+		{"huge string", binary.LittleEndian.AppendUint32(binaryHeader(Float32Encoding, 4, 1), math.MaxUint32)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := (&MemoryVectorStore{}).ReadBinary(bytes.NewReader(test.data)); err == nil {
+				t.Fatal("ReadBinary: expected an error")
+			}
+			// The file size is known: the sizes are checked before reading
+			filename := filepath.Join(t.TempDir(), "store.bin")
+			if err := os.WriteFile(filename, test.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := (&MemoryVectorStore{}).LoadFromBinaryFile(filename); err == nil {
+				t.Fatal("LoadFromBinaryFile: expected an error")
+			}
+		})
+	}
+}
+
+func TestSaveFilesAtomically(t *testing.T) {
+	store := binaryTestStore()
+	tests := []struct {
+		name string
+		save func(filename string) error
+		load func(store *MemoryVectorStore, filename string) error
+	}{
+		{"binary", func(filename string) error { return store.SaveBinaryToFile(filename, Float16Encoding) }, (*MemoryVectorStore).LoadFromBinaryFile},
+		{"json", store.SaveJSONToFile, (*MemoryVectorStore).LoadFromJSONFile},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			filename := filepath.Join(directory, "store")
+			for range 2 {
+				if err := test.save(filename); err != nil {
+					t.Fatal(err)
+				}
+			}
+			loaded := &MemoryVectorStore{}
+			if err := test.load(loaded, filename); err != nil || len(loaded.Records) != len(store.Records) {
+				t.Fatalf("%d records loaded (%v), want %d", len(loaded.Records), err, len(store.Records))
+			}
+			if entries, _ := os.ReadDir(directory); len(entries) != 1 {
+				t.Fatalf("%d files in the directory, want only the store", len(entries))
+			}
+		})
+	}
+
+	// A failed save keeps the previous file
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "store.bin")
+	if err := store.SaveBinaryToFile(filename, Float32Encoding); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("disk full")
+	if err := writeFileAtomically(filename, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Fatalf("writeFileAtomically = %v, want %v", err, failed)
+	}
+	loaded := &MemoryVectorStore{}
+	if err := loaded.LoadFromBinaryFile(filename); err != nil || len(loaded.Records) != len(store.Records) {
+		t.Fatalf("the previous file is lost: %d records (%v)", len(loaded.Records), err)
+	}
+	if entries, _ := os.ReadDir(directory); len(entries) != 1 {
+		t.Fatalf("%d files in the directory, the temporary file is left", len(entries))
+	}
+}
@@ -0,0 +1,175 @@
+package rag
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25Options are the parameters of the BM25 ranking function
+type BM25Options struct {
+	// K1 controls the saturation of the term frequency (default 1.2)
+	K1 float64
+	// B controls the normalization by the length of the document (default 0.75)
+	B float64
+}
+
+// BM25Index is a keyword index of the chunks: it finds the exact terms
+// (NPC names, passwords like "Eldergrove") that a small embedding model often misses.
+type BM25Index struct {
+	options BM25Options
+
+	mu sync.RWMutex
+	// termFrequencies by document id
+	documents map[string]map[string]int
+	lengths   map[string]int
+	// documentFrequencies: number of documents containing the term
+	documentFrequencies map[string]int
+	totalLength         int
+}
+
+// KeywordResult is a document found by a BM25Index
+type KeywordResult struct {
+	Id    string
+	Score float64
+}
+
+// NewBM25Index creates an empty keyword index
+func NewBM25Index(options BM25Options) *BM25Index {
+	if options.K1 <= 0 {
+		options.K1 = 1.2
+	}
+	if options.B < 0 || options.B > 1 {
+		options.B = 0.75
+	}
+	return &BM25Index{
+		options:             options,
+		documents:           map[string]map[string]int{},
+		lengths:             map[string]int{},
+		documentFrequencies: map[string]int{},
+	}
+}
+
+// Add indexes the text of a document (an existing id is replaced)
+func (index *BM25Index) Add(id string, text string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.remove(id)
+	terms := Tokenize(text)
+	frequencies := map[string]int{}
+	for _, term := range terms {
+		frequencies[term]++
+	}
+	for term := range frequencies {
+		index.documentFrequencies[term]++
+	}
+	index.documents[id] = frequencies
+	index.lengths[id] = len(terms)
+	index.totalLength += len(terms)
+}
+
+// Remove removes a document from the index
+func (index *BM25Index) Remove(id string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.remove(id)
+}
+
+func (index *BM25Index) remove(id string) {
+	frequencies, exists := index.documents[id]
+	if !exists {
+		return
+	}
+	for term := range frequencies {
+		index.documentFrequencies[term]--
+		if index.documentFrequencies[term] == 0 {
+			delete(index.documentFrequencies, term)
+		}
+	}
+	index.totalLength -= index.lengths[id]
+	delete(index.documents, id)
+	delete(index.lengths, id)
+}
+
+// Len returns the number of indexed documents
+func (index *BM25Index) Len() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return len(index.documents)
+}
+
+// Search returns the k documents with the best BM25 score (documents without any term of the query are ignored)
+func (index *BM25Index) Search(query string, k int) []KeywordResult {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	results := []KeywordResult{}
+	if len(index.documents) == 0 || k <= 0 {
+		return results
+	}
+	terms := slices.Compact(slices.Sorted(slices.Values(Tokenize(query))))
+	documentsCount := float64(len(index.documents))
+	averageLength := float64(index.totalLength) / documentsCount
+
+	for id, frequencies := range index.documents {
+		score := 0.0
+		for _, term := range terms {
+			frequency := float64(frequencies[term])
+			if frequency == 0 {
+				continue
+			}
+			documentFrequency := float64(index.documentFrequencies[term])
+			idf := math.Log(1 + (documentsCount-documentFrequency+0.5)/(documentFrequency+0.5))
+			normalization := 1 - index.options.B + index.options.B*float64(index.lengths[id])/averageLength
+			score += idf * frequency * (index.options.K1 + 1) / (frequency + index.options.K1*normalization)
+		}
+		if score > 0 {
+			results = append(results, KeywordResult{Id: id, Score: score})
+		}
+	}
+
+	slices.SortFunc(results, func(a, b KeywordResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Id, b.Id)
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// Tokenize splits a text into lowercase terms (letters and digits),
+// without the stop words and the one-letter terms
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if len([]rune(word)) < 2 || stopWords[word] {
+			continue
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// stopWords are the common english words ignored by the keyword index
+var stopWords = map[string]bool{
+	"a": true, "about": true, "after": true, "all": true, "also": true, "am": true, "an": true, "and": true,
+	"any": true, "are": true, "as": true, "at": true, "be": true, "been": true, "but": true, "by": true,
+	"can": true, "could": true, "did": true, "do": true, "does": true, "for": true, "from": true, "had": true,
+	"has": true, "have": true, "he": true, "her": true, "his": true, "how": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "its": true, "me": true, "my": true, "no": true, "not": true,
+	"of": true, "on": true, "or": true, "our": true, "she": true, "so": true, "than": true, "that": true,
+	"the": true, "their": true, "them": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "we": true, "were": true, "what": true, "when": true,
+	"where": true, "which": true, "who": true, "why": true, "will": true, "with": true, "would": true,
+	"you": true, "your": true,
+}
@@ -0,0 +1,230 @@
+package rag
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Chunker splits a text into chunks small enough for the embedding model
+// (see FixedSizeChunker, SentenceChunker, RecursiveChunker and MarkdownChunker).
+//
+// The sizes are approximate token counts (see EstimateTokens):
+// MaxTokens is the maximum size of a chunk (no limit if 0),
+// OverlapTokens is the size of the end of a chunk repeated at the start of the next one.
+type Chunker interface {
+	Chunk(text string) []Chunk
+}
+
+// chunkerVersion is part of the source hashes (see IngestFiles):
+// increase it when the chunkers split the same text differently, so the stores are rebuilt
+const chunkerVersion = 1
+
+// charactersByToken is the average number of characters of a token (english text)
+const charactersByToken = 4
+
+// EstimateTokens returns the approximate number of tokens of a text (~4 characters by token)
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charactersByToken - 1) / charactersByToken
+}
+
+// FixedSizeChunker splits the text every MaxTokens, without cutting the UTF-8 characters
+type FixedSizeChunker struct {
+	MaxTokens     int
+	OverlapTokens int
+}
+
+// Chunk implements Chunker
+func (chunker FixedSizeChunker) Chunk(text string) []Chunk {
+	return toChunks(splitFixedSize(text, chunker.MaxTokens, chunker.OverlapTokens))
+}
+
+// SentenceChunker groups the sentences (and the paragraphs) of the text up to MaxTokens,
+// the sentences longer than MaxTokens are split with a FixedSizeChunker
+type SentenceChunker struct {
+	MaxTokens     int
+	OverlapTokens int
+}
+
+// sentenceEndRegex matches the end of a sentence (punctuation, closing quotes, spaces) or of a paragraph
+var sentenceEndRegex = regexp.MustCompile(`[.!?…]+["'”’)\]]*\s+|\n\s*\n`)
+
+// Chunk implements Chunker
+func (chunker SentenceChunker) Chunk(text string) []Chunk {
+	if chunker.MaxTokens <= 0 {
+		return toChunks([]string{text})
+	}
+	pieces := []string{}
+	for _, sentence := range splitAfterRegex(text, sentenceEndRegex) {
+		if EstimateTokens(sentence) > chunker.MaxTokens {
+			pieces = append(pieces, splitFixedSize(sentence, chunker.MaxTokens, 0)...)
+		} else {
+			pieces = append(pieces, sentence)
+		}
+	}
+	return toChunks(mergePieces(pieces, chunker.MaxTokens, chunker.OverlapTokens))
+}
+
+// RecursiveChunker splits the text with the first separator (paragraphs by default),
+// then splits the parts still longer than MaxTokens with the next separators (lines, sentences, words),
+// and groups the parts up to MaxTokens.
+type RecursiveChunker struct {
+	MaxTokens     int
+	OverlapTokens int
+	// Separators from the largest to the smallest unit (default: paragraph, line, sentence, word)
+	Separators []string
+}
+
+// DefaultSeparators are the separators of a RecursiveChunker
+var DefaultSeparators = []string{"\n\n", "\n", ". ", " "}
+
+// Chunk implements Chunker
+func (chunker RecursiveChunker) Chunk(text string) []Chunk {
+	if chunker.MaxTokens <= 0 {
+		return toChunks([]string{text})
+	}
+	separators := chunker.Separators
+	if len(separators) == 0 {
+		separators = DefaultSeparators
+	}
+	pieces := splitRecursively(text, chunker.MaxTokens, separators)
+	return toChunks(mergePieces(pieces, chunker.MaxTokens, chunker.OverlapTokens))
+}
+
+// splitRecursively returns the pieces (with their separators) of the text, each one shorter than maxTokens
+func splitRecursively(text string, maxTokens int, separators []string) []string {
+	if EstimateTokens(text) <= maxTokens {
+		return []string{text}
+	}
+	if len(separators) == 0 {
+		return splitFixedSize(text, maxTokens, 0)
+	}
+	parts := strings.SplitAfter(text, separators[0])
+	if len(parts) == 1 {
+		return splitRecursively(text, maxTokens, separators[1:])
+	}
+	pieces := []string{}
+	for _, part := range parts {
+		pieces = append(pieces, splitRecursively(part, maxTokens, separators[1:])...)
+	}
+	return pieces
+}
+
+// MarkdownChunker creates a chunk by markdown section (see ChunkWithMarkdownMetadata),
+// the sections longer than MaxTokens are split with a RecursiveChunker.
+// Every part of a section starts with its TITLE and HIERARCHY.
+type MarkdownChunker struct {
+	MaxTokens     int
+	OverlapTokens int
+	// Source is the file name of the metadata of the chunks
+	Source string
+}
+
+// Chunk implements Chunker
+func (chunker MarkdownChunker) Chunk(text string) []Chunk {
+	chunks := []Chunk{}
+	for _, section := range ParseMarkdownHierarchy(text) {
+		header := "TITLE: " + section.Prefix + " " + section.Header + "\n" +
+			"HIERARCHY: " + section.Hierarchy + "\n" +
+			"CONTENT: "
+		metadata := sectionMetadata(section, chunker.Source)
+
+		contents := []string{section.Content}
+		if chunker.MaxTokens > 0 && EstimateTokens(header+section.Content) > chunker.MaxTokens {
+			// The header is repeated in every part
+			maxTokens := max(chunker.MaxTokens-EstimateTokens(header), 1)
+			recursive := RecursiveChunker{MaxTokens: maxTokens, OverlapTokens: min(chunker.OverlapTokens, maxTokens/2)}
+			contents = []string{}
+			for _, part := range recursive.Chunk(section.Content) {
+				contents = append(contents, part.Content)
+			}
+		}
+		for _, content := range contents {
+			chunks = append(chunks, Chunk{Content: header + content, Metadata: metadata})
+		}
+	}
+	return chunks
+}
+
+// splitFixedSize splits the text every maxTokens (no limit if 0), without cutting the UTF-8 characters
+func splitFixedSize(text string, maxTokens, overlapTokens int) []string {
+	runes := []rune(text)
+	size := maxTokens * charactersByToken
+	if size <= 0 || len(runes) <= size {
+		return []string{text}
+	}
+	step := size - overlapTokens*charactersByToken
+	if step <= 0 {
+		step = size
+	}
+	parts := []string{}
+	for start := 0; start < len(runes); start += step {
+		end := min(start+size, len(runes))
+		parts = append(parts, string(runes[start:end]))
+		if end == len(runes) {
+			break
+		}
+	}
+	return parts
+}
+
+// splitAfterRegex splits the text after each match of the regular expression
+func splitAfterRegex(text string, separator *regexp.Regexp) []string {
+	parts := []string{}
+	start := 0
+	for _, match := range separator.FindAllStringIndex(text, -1) {
+		parts = append(parts, text[start:match[1]])
+		start = match[1]
+	}
+	if start < len(text) {
+		parts = append(parts, text[start:])
+	}
+	return parts
+}
+
+// mergePieces groups the consecutive pieces up to maxTokens. The next group starts with
+// the last pieces of the previous one, up to overlapTokens.
+//
+//	pieces:  [A][B][C][D][E]
+//	groups:  [A B C] [C D E]     (C is the overlap)
+func mergePieces(pieces []string, maxTokens, overlapTokens int) []string {
+	groups := []string{}
+	current := []string{}
+	currentTokens := 0
+	for _, piece := range pieces {
+		tokens := EstimateTokens(piece)
+		if len(current) > 0 && currentTokens+tokens > maxTokens {
+			groups = append(groups, strings.Join(current, ""))
+
+			// Overlap: the last pieces of the group (the group must change)
+			overlap := []string{}
+			overlapSize := 0
+			for i := len(current) - 1; i > 0; i-- {
+				size := EstimateTokens(current[i])
+				if overlapSize+size > overlapTokens || overlapSize+size+tokens > maxTokens {
+					break
+				}
+				overlap = append([]string{current[i]}, overlap...)
+				overlapSize += size
+			}
+			current, currentTokens = overlap, overlapSize
+		}
+		current = append(current, piece)
+		currentTokens += tokens
+	}
+	if len(current) > 0 {
+		groups = append(groups, strings.Join(current, ""))
+	}
+	return groups
+}
+
+// toChunks returns the non-empty texts as chunks (trimmed)
+func toChunks(texts []string) []Chunk {
+	chunks := []Chunk{}
+	for _, text := range texts {
+		if text = strings.TrimSpace(text); text != "" {
+			chunks = append(chunks, Chunk{Content: text})
+		}
+	}
+	return chunks
+}
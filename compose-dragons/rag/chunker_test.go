@@ -0,0 +1,102 @@
+package rag
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func chunkContents(chunks []Chunk) []string {
+	contents := []string{}
+	for _, chunk := range chunks {
+		contents = append(contents, chunk.Content)
+	}
+	return contents
+}
+
+func TestChunkers(t *testing.T) {
+	sentences := "The guard sleeps. The gate is open! Who goes there? "
+	tests := []struct {
+		name    string
+		chunker Chunker
+		text    string
+		want    []string
+	}{
+		{"fixed size", FixedSizeChunker{MaxTokens: 1}, "abcdefghij", []string{"abcd", "efgh", "ij"}},
+		{"fixed size with overlap", FixedSizeChunker{MaxTokens: 2, OverlapTokens: 1}, "abcdefghij", []string{"abcdefgh", "efghij"}},
+		{"fixed size UTF-8", FixedSizeChunker{MaxTokens: 1}, "ééééé", []string{"éééé", "é"}},
+		{"fixed size without limit", FixedSizeChunker{}, "abcdefghij", []string{"abcdefghij"}},
+		{"empty text", FixedSizeChunker{MaxTokens: 1}, "  \n ", []string{}},
+
+		{"sentences", SentenceChunker{MaxTokens: 5}, sentences, []string{"The guard sleeps.", "The gate is open!", "Who goes there?"}},
+		{"grouped sentences", SentenceChunker{MaxTokens: 10}, sentences, []string{"The guard sleeps. The gate is open!", "Who goes there?"}},
+		{"sentences with overlap", SentenceChunker{MaxTokens: 10, OverlapTokens: 5}, sentences, []string{"The guard sleeps. The gate is open!", "The gate is open! Who goes there?"}},
+		{"long sentence", SentenceChunker{MaxTokens: 2}, "abcdefghijkl. Yes.", []string{"abcdefgh", "ijkl.", "Yes."}},
+
+		{"paragraphs", RecursiveChunker{MaxTokens: 5}, "Para one is here.\n\nPara two is here.", []string{"Para one is here.", "Para two is here."}},
+		{"words", RecursiveChunker{MaxTokens: 3}, "aaaa bbbb cccc dddd", []string{"aaaa", "bbbb", "cccc dddd"}},
+		{"custom separators", RecursiveChunker{MaxTokens: 2, Separators: []string{";"}}, "aaaa;bbbb;cc", []string{"aaaa;", "bbbb;", "cc"}},
+		{"recursive without limit", RecursiveChunker{}, "aaaa bbbb cccc dddd", []string{"aaaa bbbb cccc dddd"}},
+
+		{
+			"markdown sections",
+			MarkdownChunker{},
+			"# Guard\nThe guard.\n## Secrets\nThe password is moonlight.",
+			[]string{
+				"TITLE: # Guard\nHIERARCHY: Guard\nCONTENT: The guard.",
+				"TITLE: ## Secrets\nHIERARCHY: Guard > Secrets\nCONTENT: The password is moonlight.",
+			},
+		},
+		{
+			"long markdown section",
+			MarkdownChunker{MaxTokens: 15},
+			"# Gate\nThe gate is old.\n\nThe gate is locked.",
+			[]string{
+				"TITLE: # Gate\nHIERARCHY: Gate\nCONTENT: The gate is old.",
+				"TITLE: # Gate\nHIERARCHY: Gate\nCONTENT: The gate is locked.",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := chunkContents(test.chunker.Chunk(test.text)); !slices.Equal(got, test.want) {
+				t.Fatalf("Chunk(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestChunkersMaxTokens(t *testing.T) {
+	text := strings.Repeat("The merchant sells potions, swords and maps of the dungeon. ", 20) +
+		"\n\n" + strings.Repeat("Supercalifragilisticexpialidocious", 5)
+	for _, chunker := range []Chunker{
+		FixedSizeChunker{MaxTokens: 16, OverlapTokens: 4},
+		SentenceChunker{MaxTokens: 16, OverlapTokens: 4},
+		RecursiveChunker{MaxTokens: 16, OverlapTokens: 4},
+	} {
+		t.Run(reflect.TypeOf(chunker).Name(), func(t *testing.T) {
+			for _, chunk := range chunker.Chunk(text) {
+				if tokens := EstimateTokens(chunk.Content); tokens > 16 {
+					t.Fatalf("chunk of %d tokens: %q", tokens, chunk.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownChunkerMetadata(t *testing.T) {
+	chunks := MarkdownChunker{Source: "guard.md"}.Chunk("# Guard\n<!-- owner: guard -->\nThe guard.\n## Secrets\n<!-- tags: password, gate -->\n<!-- spoiler: 2 -->\nThe password is moonlight.")
+	want := []RecordMetadata{
+		{Source: "guard.md", Headings: []string{"Guard"}, Owner: "guard"},
+		{Source: "guard.md", Headings: []string{"Guard", "Secrets"}, Tags: []string{"password", "gate"}, Owner: "guard", Spoiler: 2},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("%d chunks, want %d", len(chunks), len(want))
+	}
+	for i, chunk := range chunks {
+		if !reflect.DeepEqual(chunk.Metadata, want[i]) {
+			t.Fatalf("chunk %d metadata = %+v, want %+v", i, chunk.Metadata, want[i])
+		}
+	}
+}
@@ -10,19 +10,29 @@ import (
 //
 // Parameters:
 //   - text: The input text to be chunked.
-//   - chunkSize: The size of each chunk.
+//   - chunkSize: The size of each chunk (in characters, the UTF-8 characters are never cut).
 //   - overlap: The amount of overlap between consecutive chunks.
 //
 // Returns:
 //   - []string: A slice of strings representing the chunks of the original text.
+//
+// See FixedSizeChunker for sizes in tokens.
 func ChunkText(text string, chunkSize, overlap int) []string {
 	chunks := []string{}
-	for start := 0; start < len(text); start += chunkSize - overlap {
+	if chunkSize <= 0 {
+		return chunks
+	}
+	runes := []rune(text)
+	step := chunkSize - overlap
+	if step <= 0 {
+		step = chunkSize
+	}
+	for start := 0; start < len(runes); start += step {
 		end := start + chunkSize
-		if end > len(text) {
-			end = len(text)
+		if end > len(runes) {
+			end = len(runes)
 		}
-		chunks = append(chunks, text[start:end])
+		chunks = append(chunks, string(runes[start:end]))
 	}
 	return chunks
 }
@@ -49,25 +49,35 @@ func ParseMarkdownHierarchy(content string) []MarkdownChunk {
 			header := matches[2]
 			prefix := matches[1]
 
+			// Determine parent header
+			var parent MarkdownChunk
+			for len(stack) > 0 && stack[len(stack)-1].Level >= level {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+
+			// [METADATA] the annotations (<!-- key: value -->) of the parent sections are inherited
+			metadata := map[string]interface{}{}
+			for key, value := range parent.Metadata {
+				metadata[key] = value
+			}
+
 			// Find content for this header
 			contentLines := []string{}
 			for j := i + 1; j < len(lines); j++ {
 				if headerRegex.MatchString(lines[j]) {
 					break
 				}
+				if key, value, ok := parseMetadataAnnotation(lines[j]); ok {
+					metadata[key] = value
+					continue
+				}
 				contentLines = append(contentLines, lines[j])
 			}
 			content := strings.Join(contentLines, "\n")
 
-			// Determine parent header
-			var parent MarkdownChunk
-			for len(stack) > 0 && stack[len(stack)-1].Level >= level {
-				stack = stack[:len(stack)-1]
-			}
-			if len(stack) > 0 {
-				parent = stack[len(stack)-1]
-			}
-
 			// Build hierarchy
 			hierarchy := buildHierarchy(stack, header)
 
@@ -80,6 +90,7 @@ func ParseMarkdownHierarchy(content string) []MarkdownChunk {
 				ParentLevel:  parent.Level,
 				ParentHeader: parent.Header,
 				Hierarchy:      hierarchy,
+				Metadata:     metadata,
 			}
 			//if chunk.Content != "" {
 			chunks = append(chunks, chunk)
@@ -112,4 +123,29 @@ func ChunkWithMarkdownHierarchy(content string) []string {
 		chunks = append(chunks, chunkContent)
 	}
 	return chunks
-}
\ No newline at end of file
+}
+
+// ChunkWithMarkdownMetadata returns the chunks of ChunkWithMarkdownHierarchy with their metadata:
+// the source file, the heading path and the annotations of the sections.
+//
+//	## Secrets
+//	<!-- tags: password, gate -->
+//	<!-- spoiler: 2 -->
+//	<!-- owner: guard -->
+func ChunkWithMarkdownMetadata(content string, source string) []Chunk {
+	return MarkdownChunker{Source: source}.Chunk(content)
+}
+
+// sectionMetadata returns the metadata of a markdown section: the source file, the heading path and the annotations
+func sectionMetadata(section MarkdownChunk, source string) RecordMetadata {
+	metadata := RecordMetadata{
+		Source:   source,
+		Headings: strings.Split(section.Hierarchy, " > "),
+	}
+	for key, value := range section.Metadata {
+		if text, ok := value.(string); ok {
+			metadata.setMetadata(key, text)
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,430 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"gopkg.in/yaml.v3"
+)
+
+// EvalSuite is a YAML file of questions to evaluate the retrieval of a store (see LoadEvalSuite):
+//
+//	name: guard
+//	context: ../guard_background_and_personality.md
+//	similarity_limit: 0.5
+//	max_results: 2
+//	cases:
+//	  - question: What is the password of the gate?
+//	    expected_headings: ["Secrets"]
+//	    expected_facts: ["moonlight"]
+type EvalSuite struct {
+	Name string `yaml:"name"`
+	// Context is the documents of the store: file, directory or glob (relative to the suite file)
+	Context string `yaml:"context"`
+	// Limit and MaxResults are the similarity search settings to evaluate (0.5 and 2 if 0)
+	Limit      float64         `yaml:"similarity_limit"`
+	MaxResults int             `yaml:"max_results"`
+	Filter     *MetadataFilter `yaml:"filter"`
+	Cases      []EvalCase      `yaml:"cases"`
+}
+
+// EvalCase is a question and what the retrieval should find. Each expected id, heading and fact
+// is an expected item, a retrieved chunk is relevant if it matches one of them.
+type EvalCase struct {
+	Question string `yaml:"question"`
+	// ExpectedIds are the ids or the content hashes (or a prefix of 8 characters at least) of the relevant chunks
+	ExpectedIds []string `yaml:"expected_ids"`
+	// ExpectedHeadings are headings of the relevant chunks (case insensitive)
+	ExpectedHeadings []string `yaml:"expected_headings"`
+	// ExpectedFacts are texts of the relevant chunks (case insensitive), they are also used to judge the answers
+	ExpectedFacts []string `yaml:"expected_facts"`
+}
+
+// LoadEvalSuite reads a suite file, the context path is made relative to the directory of the file
+func LoadEvalSuite(path string) (EvalSuite, error) {
+	var suite EvalSuite
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return suite, err
+	}
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return suite, fmt.Errorf("%s: %w", path, err)
+	}
+	if suite.Context != "" && !filepath.IsAbs(suite.Context) {
+		suite.Context = filepath.Join(filepath.Dir(path), suite.Context)
+	}
+	if suite.Name == "" {
+		suite.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if suite.Limit == 0 {
+		suite.Limit = 0.5
+	}
+	if suite.MaxResults == 0 {
+		suite.MaxResults = 2
+	}
+	for i, evalCase := range suite.Cases {
+		if strings.TrimSpace(evalCase.Question) == "" {
+			return suite, fmt.Errorf("%s: case %d has no question", path, i+1)
+		}
+		if len(evalCase.ExpectedIds)+len(evalCase.ExpectedHeadings)+len(evalCase.ExpectedFacts) == 0 {
+			return suite, fmt.Errorf("%s: case %q has no expected ids, headings or facts", path, evalCase.Question)
+		}
+	}
+	return suite, nil
+}
+
+// RetrieveFunc returns the ranked documents of a question (with the metadata of the memory vector retriever).
+// For the evaluation, the ranking must not be cut by the similarity threshold (see EvaluateRetrieval).
+type RetrieveFunc func(ctx context.Context, question string) ([]*ai.Document, error)
+
+// RetrieverFunc runs the retriever with the options (the Limit and the MaxResults are set by EvaluateRetrieval)
+func RetrieverFunc(retriever ai.Retriever, options MemoryVectorRetrieverOptions) func(limit float64, maxResults int) RetrieveFunc {
+	return func(limit float64, maxResults int) RetrieveFunc {
+		return func(ctx context.Context, question string) ([]*ai.Document, error) {
+			options := options
+			options.Limit = limit
+			options.MaxResults = maxResults
+			resp, err := retriever.Retrieve(ctx, &ai.RetrieverRequest{
+				Query:   ai.DocumentFromText(question, nil),
+				Options: options,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Documents, nil
+		}
+	}
+}
+
+// EvalOptions defines the metrics of EvaluateRetrieval
+type EvalOptions struct {
+	// K are the cut-offs of the recall (1, 2, 3 and 5 if empty)
+	K []int
+	// Limits are the similarity thresholds of the sweep (0.3 to 0.8 if empty)
+	Limits []float64
+	// Judge scores the answers of Answer (optional)
+	Judge *LLMJudge
+	// Answer generates the answer of a question from the retrieved documents (optional, needed by Judge)
+	Answer func(ctx context.Context, question string, documents []*ai.Document) (string, error)
+}
+
+// EvalDocument is a retrieved document of an EvalCaseResult
+type EvalDocument struct {
+	Id               string
+	Headings         []string
+	CosineSimilarity float64
+	Relevant         bool
+}
+
+// EvalCaseResult is the evaluation of one question
+type EvalCaseResult struct {
+	Question  string
+	Retrieved []EvalDocument
+	// RecallAtK is the ratio of the expected items found in the top K documents
+	RecallAtK map[int]float64
+	// ReciprocalRank is 1 / rank of the first relevant document (0 if none)
+	ReciprocalRank float64
+	// Answer and Judgement are set when the answers are judged
+	Answer    string
+	Judgement *Judgement
+}
+
+// EvalSweepResult is the retrieval with a similarity threshold and the MaxResults of the suite
+type EvalSweepResult struct {
+	Limit float64
+	// Recall is the mean ratio of the expected items found
+	Recall float64
+	// Precision is the mean ratio of relevant documents among the returned ones (questions without results excluded)
+	Precision float64
+	// Results is the mean number of returned documents, Empty the number of questions without result
+	Results float64
+	Empty   int
+}
+
+// EvalReport is the result of EvaluateRetrieval
+type EvalReport struct {
+	Suite      string
+	Limit      float64
+	MaxResults int
+	Cases      []EvalCaseResult
+	K          []int
+	RecallAtK  map[int]float64
+	MRR        float64
+	Sweep      []EvalSweepResult
+	// JudgeScore is the mean score of the judged answers (0 to 10, -1 if not judged)
+	JudgeScore float64
+}
+
+// EvaluateRetrieval runs the questions of the suite and computes the recall@k, the MRR (mean reciprocal rank)
+// and a sweep of the similarity threshold. retrieve returns a RetrieveFunc for a threshold and a number of results
+// (see RetrieverFunc): the metrics use the ranking without threshold, the sweep filters it by cosine similarity.
+//
+//	question ─ retrieve(limit: -1, max: max(K, MaxResults)) ─ ranking ─┬─ recall@k, MRR
+//	                                                                  └─ cosine ≥ limit, top MaxResults ─ sweep
+//	with a Judge: retrieve(suite limit, MaxResults) ─ Answer ─ Judge ─ score
+func EvaluateRetrieval(ctx context.Context, suite EvalSuite, retrieve func(limit float64, maxResults int) RetrieveFunc, options EvalOptions) (EvalReport, error) {
+	ks := options.K
+	if len(ks) == 0 {
+		ks = []int{1, 2, 3, 5}
+	}
+	limits := options.Limits
+	if len(limits) == 0 {
+		limits = []float64{0.3, 0.4, 0.5, 0.6, 0.7, 0.8}
+	}
+	depth := max(slices.Max(ks), suite.MaxResults)
+
+	report := EvalReport{
+		Suite:      suite.Name,
+		Limit:      suite.Limit,
+		MaxResults: suite.MaxResults,
+		K:          ks,
+		RecallAtK:  map[int]float64{},
+		JudgeScore: -1,
+	}
+	sweep := make([]EvalSweepResult, len(limits))
+	precisionCounts := make([]int, len(limits))
+	for i, limit := range limits {
+		sweep[i].Limit = limit
+	}
+	judged, judgeTotal := 0, 0.0
+
+	ranking := retrieve(-1, depth)
+	for _, evalCase := range suite.Cases {
+		documents, err := ranking(ctx, evalCase.Question)
+		if err != nil {
+			return report, fmt.Errorf("%q: %w", evalCase.Question, err)
+		}
+		result := EvalCaseResult{Question: evalCase.Question, RecallAtK: map[int]float64{}}
+		for rank, document := range documents {
+			evalDocument := evalDocumentOf(document)
+			evalDocument.Relevant = len(matchedItems(evalCase, document)) > 0
+			if evalDocument.Relevant && result.ReciprocalRank == 0 {
+				result.ReciprocalRank = 1 / float64(rank+1)
+			}
+			result.Retrieved = append(result.Retrieved, evalDocument)
+		}
+		for _, k := range ks {
+			result.RecallAtK[k] = recall(evalCase, documents[:min(k, len(documents))])
+			report.RecallAtK[k] += result.RecallAtK[k]
+		}
+		report.MRR += result.ReciprocalRank
+
+		for i, limit := range limits {
+			returned := []*ai.Document{}
+			relevant := 0
+			for j, document := range documents {
+				if len(returned) == suite.MaxResults {
+					break
+				}
+				if result.Retrieved[j].CosineSimilarity >= limit {
+					returned = append(returned, document)
+					if result.Retrieved[j].Relevant {
+						relevant++
+					}
+				}
+			}
+			sweep[i].Recall += recall(evalCase, returned)
+			sweep[i].Results += float64(len(returned))
+			if len(returned) == 0 {
+				sweep[i].Empty++
+			} else {
+				sweep[i].Precision += float64(relevant) / float64(len(returned))
+				precisionCounts[i]++
+			}
+		}
+
+		if options.Judge != nil && options.Answer != nil {
+			// The answer uses the settings of the suite
+			documents, err := retrieve(suite.Limit, suite.MaxResults)(ctx, evalCase.Question)
+			if err != nil {
+				return report, fmt.Errorf("%q: %w", evalCase.Question, err)
+			}
+			result.Answer, err = options.Answer(ctx, evalCase.Question, documents)
+			if err != nil {
+				return report, fmt.Errorf("%q: %w", evalCase.Question, err)
+			}
+			judgement, err := options.Judge.Judge(ctx, evalCase.Question, result.Answer, evalCase.ExpectedFacts)
+			if err != nil {
+				return report, fmt.Errorf("%q: %w", evalCase.Question, err)
+			}
+			result.Judgement = &judgement
+			judged++
+			judgeTotal += judgement.Score
+		}
+		report.Cases = append(report.Cases, result)
+	}
+
+	if count := float64(len(suite.Cases)); count > 0 {
+		for _, k := range ks {
+			report.RecallAtK[k] /= count
+		}
+		report.MRR /= count
+		for i := range sweep {
+			sweep[i].Recall /= count
+			sweep[i].Results /= count
+			if precisionCounts[i] > 0 {
+				sweep[i].Precision /= float64(precisionCounts[i])
+			}
+		}
+	}
+	report.Sweep = sweep
+	if judged > 0 {
+		report.JudgeScore = judgeTotal / float64(judged)
+	}
+	return report, nil
+}
+
+// String formats the report for the terminal
+func (report EvalReport) String() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "📊 %s: %d questions (limit: %.2f, max results: %d)\n", report.Suite, len(report.Cases), report.Limit, report.MaxResults)
+	for _, result := range report.Cases {
+		status := "✅"
+		if result.ReciprocalRank == 0 {
+			status = "❌"
+		}
+		fmt.Fprintf(&text, "%s %s (RR: %.2f)\n", status, result.Question, result.ReciprocalRank)
+		for rank, document := range result.Retrieved {
+			mark := " "
+			if document.Relevant {
+				mark = "*"
+			}
+			fmt.Fprintf(&text, "   %s %d. %.4f %s %s\n", mark, rank+1, document.CosineSimilarity, shortId(document.Id), strings.Join(document.Headings, " > "))
+		}
+		if result.Judgement != nil {
+			fmt.Fprintf(&text, "   ⚖️ %.1f/10 %s\n", result.Judgement.Score, result.Judgement.Reason)
+		}
+	}
+	text.WriteString("\n")
+	for _, k := range report.K {
+		fmt.Fprintf(&text, "recall@%d: %.3f  ", k, report.RecallAtK[k])
+	}
+	fmt.Fprintf(&text, "MRR: %.3f\n", report.MRR)
+	if report.JudgeScore >= 0 {
+		fmt.Fprintf(&text, "judge score: %.1f/10\n", report.JudgeScore)
+	}
+
+	fmt.Fprintf(&text, "\nthreshold sweep (max results: %d)\n", report.MaxResults)
+	fmt.Fprintf(&text, "%-8s %-8s %-10s %-8s %s\n", "limit", "recall", "precision", "results", "empty")
+	for _, result := range report.Sweep {
+		fmt.Fprintf(&text, "%-8.2f %-8.3f %-10.3f %-8.2f %d\n", result.Limit, result.Recall, result.Precision, result.Results, result.Empty)
+	}
+	return text.String()
+}
+
+// evalDocumentOf reads the metadata of a retrieved document (see documentMetadata)
+func evalDocumentOf(document *ai.Document) EvalDocument {
+	evalDocument := EvalDocument{}
+	evalDocument.Id, _ = document.Metadata["id"].(string)
+	evalDocument.CosineSimilarity, _ = document.Metadata["cosine_similarity"].(float64)
+	switch headings := document.Metadata["headings"].(type) {
+	case []string:
+		evalDocument.Headings = headings
+	case []any:
+		// The metadata can come back from JSON
+		for _, heading := range headings {
+			evalDocument.Headings = append(evalDocument.Headings, fmt.Sprint(heading))
+		}
+	}
+	return evalDocument
+}
+
+// matchedItems returns the expected items (ids, headings, facts) of the case matched by the document
+func matchedItems(evalCase EvalCase, document *ai.Document) []string {
+	matched := []string{}
+	evalDocument := evalDocumentOf(document)
+	text := documentText(document)
+	contentHash := ContentHash(text)
+	for _, id := range evalCase.ExpectedIds {
+		if id == evalDocument.Id || (len(id) >= 8 && strings.HasPrefix(contentHash, id)) {
+			matched = append(matched, "id:"+id)
+		}
+	}
+	for _, heading := range evalCase.ExpectedHeadings {
+		if slices.ContainsFunc(evalDocument.Headings, func(documentHeading string) bool {
+			return strings.EqualFold(documentHeading, heading)
+		}) {
+			matched = append(matched, "heading:"+heading)
+		}
+	}
+	lowerText := strings.ToLower(text)
+	for _, fact := range evalCase.ExpectedFacts {
+		if strings.Contains(lowerText, strings.ToLower(fact)) {
+			matched = append(matched, "fact:"+fact)
+		}
+	}
+	return matched
+}
+
+// recall returns the ratio of the expected items of the case found in the documents
+func recall(evalCase EvalCase, documents []*ai.Document) float64 {
+	expected := len(evalCase.ExpectedIds) + len(evalCase.ExpectedHeadings) + len(evalCase.ExpectedFacts)
+	if expected == 0 {
+		return 0
+	}
+	found := map[string]bool{}
+	for _, document := range documents {
+		for _, item := range matchedItems(evalCase, document) {
+			found[item] = true
+		}
+	}
+	return float64(len(found)) / float64(expected)
+}
+
+func shortId(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// LLMJudge asks a chat model to score an answer against the expected facts (see EvalOptions)
+type LLMJudge struct {
+	genKitInstance *genkit.Genkit
+	modelId        string
+}
+
+// NewLLMJudge creates a judge using the model (e.g. "openai/ai/qwen2.5:latest")
+func NewLLMJudge(g *genkit.Genkit, modelId string) *LLMJudge {
+	return &LLMJudge{genKitInstance: g, modelId: modelId}
+}
+
+// Judgement is the score of an answer (0 to 10) and its reason
+type Judgement struct {
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+// Judge scores the answer of the question: 10 if it states all the expected facts without contradiction
+func (judge *LLMJudge) Judge(ctx context.Context, question, answer string, expectedFacts []string) (Judgement, error) {
+	var prompt strings.Builder
+	prompt.WriteString("Score the answer to the question from 0 (wrong or missing) to 10 (correct and complete).\n")
+	if len(expectedFacts) > 0 {
+		prompt.WriteString("A correct answer states all these facts:\n")
+		for _, fact := range expectedFacts {
+			fmt.Fprintf(&prompt, "- %s\n", fact)
+		}
+	}
+	fmt.Fprintf(&prompt, "\nQUESTION: %s\n\nANSWER: %s\n\nExplain the score in one sentence.", question, answer)
+
+	resp, err := genkit.Generate(ctx, judge.genKitInstance,
+		ai.WithModelName(judge.modelId),
+		ai.WithPrompt(prompt.String()),
+		ai.WithConfig(map[string]any{"temperature": 0.0}),
+		ai.WithOutputType(Judgement{}),
+	)
+	if err != nil {
+		return Judgement{}, err
+	}
+	var judgement Judgement
+	if err := resp.Output(&judgement); err != nil {
+		return Judgement{}, err
+	}
+	return judgement, nil
+}
@@ -0,0 +1,112 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// evalTestDocument returns a retrieved document with the metadata of the memory vector retriever
+func evalTestDocument(heading, text string, similarity float64) *ai.Document {
+	return ai.DocumentFromText(text, map[string]any{
+		"id":                heading,
+		"headings":          []string{heading},
+		"cosine_similarity": similarity,
+	})
+}
+
+func TestEvaluationMetrics(t *testing.T) {
+	ranking := []*ai.Document{
+		evalTestDocument("Gate", "The gate is guarded by Grimbold.", 0.9),
+		evalTestDocument("Merchant", "The merchant sells potions.", 0.6),
+		evalTestDocument("Password", "The password is moonlight.", 0.4),
+	}
+	tests := []struct {
+		name      string
+		evalCase  EvalCase
+		recallAtK map[int]float64
+		rr        float64
+	}{
+		{"first", EvalCase{ExpectedHeadings: []string{"Gate"}}, map[int]float64{1: 1, 2: 1, 3: 1}, 1},
+		{"second", EvalCase{ExpectedFacts: []string{"POTIONS"}}, map[int]float64{1: 0, 2: 1, 3: 1}, 0.5},
+		{"two items", EvalCase{ExpectedIds: []string{"Gate"}, ExpectedHeadings: []string{"Password"}}, map[int]float64{1: 0.5, 2: 0.5, 3: 1}, 1},
+		{"content hash", EvalCase{ExpectedIds: []string{ContentHash("The password is moonlight.")[:8]}}, map[int]float64{1: 0, 2: 0, 3: 1}, 1.0 / 3},
+		{"not found", EvalCase{ExpectedFacts: []string{"dragon"}}, map[int]float64{1: 0, 2: 0, 3: 0}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.evalCase.Question = test.name
+			suite := EvalSuite{Name: test.name, Limit: 0.5, MaxResults: 2, Cases: []EvalCase{test.evalCase}}
+			retrieve := func(limit float64, maxResults int) RetrieveFunc {
+				return func(ctx context.Context, question string) ([]*ai.Document, error) {
+					return ranking[:min(maxResults, len(ranking))], nil
+				}
+			}
+			report, err := EvaluateRetrieval(context.Background(), suite, retrieve, EvalOptions{K: []int{1, 2, 3}, Limits: []float64{0.5}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range test.recallAtK {
+				if math.Abs(report.RecallAtK[k]-want) > 1e-9 {
+					t.Fatalf("recall@%d = %.3f, want %.3f", k, report.RecallAtK[k], want)
+				}
+			}
+			if math.Abs(report.MRR-test.rr) > 1e-9 {
+				t.Fatalf("MRR = %.3f, want %.3f", report.MRR, test.rr)
+			}
+		})
+	}
+}
+
+func TestEvaluateRetrieval(t *testing.T) {
+	suite, err := LoadEvalSuite("testdata/dungeon.eval.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingested, err := IngestFiles(suite.Context, IngestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	if _, err := store.Rebuild(context.Background(), ingested.SourceHash, ingested.Chunks, "hashing-256", HashingEmbedFunc(256)); err != nil {
+		t.Fatal(err)
+	}
+
+	store.UseKeywordIndex(NewBM25Index(BM25Options{}))
+
+	g := genkit.Init(context.Background())
+	retriever, err := DefineNamedMemoryVectorRetriever(g, "eval", store, DefineHashingEmbedder(g, "hashing-256", 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		options   MemoryVectorRetrieverOptions
+		minRecall map[int]float64
+		minMRR    float64
+	}{
+		{"vector", MemoryVectorRetrieverOptions{}, map[int]float64{1: 0.8, 3: 1}, 0.9},
+		{"hybrid", MemoryVectorRetrieverOptions{Hybrid: true}, map[int]float64{1: 0.8, 3: 1}, 0.9},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := EvaluateRetrieval(context.Background(), suite, RetrieverFunc(retriever, test.options), EvalOptions{K: []int{1, 3}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, minRecall := range test.minRecall {
+				if report.RecallAtK[k] < minRecall {
+					t.Fatalf("recall@%d = %.3f, want at least %.2f\n%s", k, report.RecallAtK[k], minRecall, report)
+				}
+			}
+			if report.MRR < test.minMRR {
+				t.Fatalf("MRR = %.3f, want at least %.2f\n%s", report.MRR, test.minMRR, report)
+			}
+			t.Logf("recall@1 %.3f, recall@3 %.3f, MRR %.3f", report.RecallAtK[1], report.RecallAtK[3], report.MRR)
+		})
+	}
+}
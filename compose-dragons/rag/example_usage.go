@@ -17,7 +17,11 @@ func ExampleUsage(g *genkit.Genkit, embedder ai.Embedder) {
 	}
 
 	// Create the memory vector retriever
-	memoryRetriever := DefineMemoryVectorRetriever(g, vectorStore, embedder)
+	memoryRetriever, err := DefineNamedMemoryVectorRetriever(g, "exampleRetriever", vectorStore, embedder)
+	if err != nil {
+		fmt.Printf("Error defining the retriever: %v\n", err)
+		return
+	}
 
 	// Example: Add some documents to the vector store first
 	// AddDocumentToVectorStore(ctx, g, vectorStore, embedder, "Machine learning is a subset of AI")
@@ -0,0 +1,73 @@
+package rag
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// HashingEmbedFunc returns a deterministic embedding function without model (feature hashing):
+// the words and the character trigrams of the text are hashed into a normalized vector of the dimensions.
+// The texts sharing words are similar, so the retrieval can be evaluated offline (CI, see EvaluateRetrieval).
+func HashingEmbedFunc(dimensions int) EmbedFunc {
+	if dimensions <= 0 {
+		dimensions = 1024
+	}
+	return func(ctx context.Context, text string) ([]float32, error) {
+		return hashingEmbedding(text, dimensions), nil
+	}
+}
+
+// DefineHashingEmbedder registers the HashingEmbedFunc as a genkit embedder (e.g. for a memory vector retriever)
+func DefineHashingEmbedder(g *genkit.Genkit, name string, dimensions int) ai.Embedder {
+	embed := HashingEmbedFunc(dimensions)
+	return genkit.DefineEmbedder(g, name, nil, func(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		resp := &ai.EmbedResponse{}
+		for _, document := range req.Input {
+			embedding, err := embed(ctx, documentText(document))
+			if err != nil {
+				return nil, err
+			}
+			resp.Embeddings = append(resp.Embeddings, &ai.Embedding{Embedding: embedding})
+		}
+		return resp, nil
+	})
+}
+
+func hashingEmbedding(text string, dimensions int) []float32 {
+	vector := make([]float32, dimensions)
+	add := func(feature string, weight float32) {
+		hash := fnv.New32a()
+		hash.Write([]byte(feature))
+		sum := hash.Sum32()
+		// The sign bit reduces the collisions bias
+		if sum&1 == 0 {
+			vector[(sum>>1)%uint32(dimensions)] += weight
+		} else {
+			vector[(sum>>1)%uint32(dimensions)] -= weight
+		}
+	}
+	for _, term := range Tokenize(text) {
+		add(term, 1)
+		runes := []rune(" " + term + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			add(strings.ToLower(string(runes[i:i+3])), 0.3)
+		}
+	}
+
+	norm := 0.0
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vector {
+			vector[i] = float32(float64(vector[i]) / norm)
+		}
+	}
+	return vector
+}
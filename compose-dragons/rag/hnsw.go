@@ -0,0 +1,387 @@
+package rag
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+)
+
+// HNSWOptions are the recall/speed parameters of an HNSWIndex.
+// Higher values give a better recall and a slower index.
+type HNSWOptions struct {
+	// M is the number of neighbours of a node (2*M on the bottom layer), default 16
+	M int
+	// EfConstruction is the size of the candidates list when a record is added, default 100
+	EfConstruction int
+	// EfSearch is the size of the candidates list of a search (at least k), default 64
+	EfSearch int
+	// Seed of the random levels (default 42, so the same records give the same graph)
+	Seed int64
+	// MaxRemovedRatio is the fraction of removed nodes above which the graph is rebuilt
+	// without them (default 0.25), so the updates and the deletions do not grow the graph forever
+	MaxRemovedRatio float64
+}
+
+// HNSWIndex is a Hierarchical Navigable Small World graph (pure Go, in-process)
+// for the cosine similarity.
+//
+//	layer 2:  A ─────────────── F           few nodes, long links
+//	layer 1:  A ──── C ──── E ─ F
+//	layer 0:  A ─ B ─ C ─ D ─ E ─ F ─ G     all the nodes, short links
+//
+// A search starts at the entry point of the top layer, goes greedily to the closest node of each layer
+// and explores the EfSearch best candidates of the bottom layer.
+//
+// Usage:
+//
+//	index := rag.NewHNSWIndex(rag.HNSWOptions{EfSearch: 100})
+//	err := store.UseIndex(index)
+//	records, err := store.SearchTopNSimilarities(question, 0.5, 5)
+type HNSWIndex struct {
+	options HNSWOptions
+
+	mu         sync.RWMutex
+	nodes      []*hnswNode
+	ids        map[string]int
+	entryPoint int
+	maxLevel   int
+	dimension  int
+	removed    int
+	levelMult  float64
+	random     *rand.Rand
+}
+
+type hnswNode struct {
+	id     string
+	vector []float32
+	// neighbours by layer (from 0 to the level of the node)
+	neighbours [][]int
+	// removed nodes are still used to navigate the graph, but never returned
+	removed bool
+}
+
+// NewHNSWIndex creates an empty index
+func NewHNSWIndex(options HNSWOptions) *HNSWIndex {
+	if options.M <= 1 {
+		options.M = 16
+	}
+	if options.EfConstruction <= 0 {
+		options.EfConstruction = 100
+	}
+	if options.EfSearch <= 0 {
+		options.EfSearch = 64
+	}
+	if options.Seed == 0 {
+		options.Seed = 42
+	}
+	if options.MaxRemovedRatio <= 0 {
+		options.MaxRemovedRatio = 0.25
+	}
+	return &HNSWIndex{
+		options:    options,
+		ids:        map[string]int{},
+		entryPoint: -1,
+		levelMult:  1 / math.Log(float64(options.M)),
+		random:     rand.New(rand.NewSource(options.Seed)),
+	}
+}
+
+// Len returns the number of indexed records
+func (index *HNSWIndex) Len() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return len(index.ids)
+}
+
+// Remove removes a record from the results (the node is kept to navigate the graph
+// until the graph is compacted, see HNSWOptions.MaxRemovedRatio)
+func (index *HNSWIndex) Remove(id string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if position, exists := index.ids[id]; exists {
+		index.nodes[position].removed = true
+		delete(index.ids, id)
+		index.removed++
+		index.compactIfNeeded()
+	}
+}
+
+// Add indexes the embedding of a record, an existing id is replaced
+func (index *HNSWIndex) Add(id string, embedding []float32) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if err := index.add(id, embedding); err != nil {
+		return err
+	}
+	index.compactIfNeeded()
+	return nil
+}
+
+// compactIfNeeded rebuilds the graph with the records only (no removed nodes)
+// when there are too many removed nodes
+func (index *HNSWIndex) compactIfNeeded() {
+	if index.removed == 0 || float64(index.removed) <= index.options.MaxRemovedRatio*float64(len(index.nodes)) {
+		return
+	}
+	nodes := index.nodes
+	index.nodes = make([]*hnswNode, 0, len(index.ids))
+	index.ids = make(map[string]int, len(index.ids))
+	index.entryPoint = -1
+	index.maxLevel = 0
+	index.removed = 0
+	index.random = rand.New(rand.NewSource(index.options.Seed))
+	for _, node := range nodes {
+		if !node.removed {
+			// The vectors are already normalized and have the dimension of the index
+			index.add(node.id, node.vector)
+		}
+	}
+}
+
+// Removed returns the number of removed nodes still in the graph
+func (index *HNSWIndex) Removed() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return index.removed
+}
+
+// add indexes the embedding of a record (the caller holds the lock)
+func (index *HNSWIndex) add(id string, embedding []float32) error {
+	if len(embedding) == 0 {
+		return fmt.Errorf("record %q has no embedding", id)
+	}
+	if index.dimension == 0 {
+		index.dimension = len(embedding)
+	}
+	if len(embedding) != index.dimension {
+		return fmt.Errorf("record %q has %d dimensions, the index has %d", id, len(embedding), index.dimension)
+	}
+	if position, exists := index.ids[id]; exists {
+		index.nodes[position].removed = true
+		index.removed++
+	}
+
+	level := int(math.Floor(-math.Log(1-index.random.Float64()) * index.levelMult))
+	node := &hnswNode{
+		id:         id,
+		vector:     normalize(embedding),
+		neighbours: make([][]int, level+1),
+	}
+	position := len(index.nodes)
+	index.nodes = append(index.nodes, node)
+	index.ids[id] = position
+
+	if index.entryPoint == -1 {
+		index.entryPoint = position
+		index.maxLevel = level
+		return nil
+	}
+
+	// Greedy search on the layers above the level of the node
+	entryPoint := index.entryPoint
+	for layer := index.maxLevel; layer > level; layer-- {
+		entryPoint = index.searchLayer(node.vector, entryPoint, 1, layer)[0].node
+	}
+
+	// Link the node on its layers
+	for layer := min(level, index.maxLevel); layer >= 0; layer-- {
+		candidates := index.searchLayer(node.vector, entryPoint, index.options.EfConstruction, layer)
+		node.neighbours[layer] = index.selectNeighbours(candidates, index.maxNeighbours(layer))
+		for _, neighbour := range node.neighbours[layer] {
+			index.link(neighbour, position, layer)
+		}
+		entryPoint = candidates[0].node
+	}
+
+	if level > index.maxLevel {
+		index.entryPoint = position
+		index.maxLevel = level
+	}
+	return nil
+}
+
+// Search returns the k most similar records, sorted by descending cosine similarity
+func (index *HNSWIndex) Search(embedding []float32, k int) []SearchResult {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	if index.entryPoint == -1 || k <= 0 || len(embedding) != index.dimension {
+		return []SearchResult{}
+	}
+	query := normalize(embedding)
+
+	entryPoint := index.entryPoint
+	for layer := index.maxLevel; layer > 0; layer-- {
+		entryPoint = index.searchLayer(query, entryPoint, 1, layer)[0].node
+	}
+	// The removed nodes take some places of the candidates list
+	ef := max(index.options.EfSearch, k) + min(index.removed, k)
+	candidates := index.searchLayer(query, entryPoint, ef, 0)
+
+	results := make([]SearchResult, 0, k)
+	for _, candidate := range candidates {
+		node := index.nodes[candidate.node]
+		if node.removed {
+			continue
+		}
+		results = append(results, SearchResult{Id: node.id, CosineSimilarity: candidate.similarity})
+		if len(results) == k {
+			break
+		}
+	}
+	return results
+}
+
+func (index *HNSWIndex) maxNeighbours(layer int) int {
+	if layer == 0 {
+		return 2 * index.options.M
+	}
+	return index.options.M
+}
+
+// link adds a link from the neighbour to the new node, and prunes the links of the neighbour if needed.
+// The links are pruned when there are 50% too many, so the (slow) selection does not run for every new link.
+func (index *HNSWIndex) link(neighbour, position, layer int) {
+	node := index.nodes[neighbour]
+	node.neighbours[layer] = append(node.neighbours[layer], position)
+	if len(node.neighbours[layer]) <= index.maxNeighbours(layer)*3/2 {
+		return
+	}
+	candidates := make([]hnswCandidate, 0, len(node.neighbours[layer]))
+	for _, other := range node.neighbours[layer] {
+		candidates = append(candidates, hnswCandidate{
+			node:       other,
+			similarity: similarity(node.vector, index.nodes[other].vector),
+		})
+	}
+	sortCandidates(candidates)
+	node.neighbours[layer] = index.selectNeighbours(candidates, index.maxNeighbours(layer))
+}
+
+// selectNeighbours keeps the candidates (sorted by descending similarity) closer to the node
+// than to the already selected neighbours, so the links go in different directions.
+// The other candidates fill the remaining places.
+func (index *HNSWIndex) selectNeighbours(candidates []hnswCandidate, m int) []int {
+	selected := make([]int, 0, m)
+	skipped := []int{}
+	for _, candidate := range candidates {
+		if len(selected) == m {
+			break
+		}
+		diverse := true
+		for _, other := range selected {
+			if similarity(index.nodes[candidate.node].vector, index.nodes[other].vector) > candidate.similarity {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, candidate.node)
+		} else {
+			skipped = append(skipped, candidate.node)
+		}
+	}
+	for _, node := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, node)
+	}
+	return selected
+}
+
+// searchLayer returns the ef nodes of the layer most similar to the query, sorted by descending similarity
+func (index *HNSWIndex) searchLayer(query []float32, entryPoint, ef, layer int) []hnswCandidate {
+	start := hnswCandidate{node: entryPoint, similarity: similarity(query, index.nodes[entryPoint].vector)}
+	// bitset of the visited nodes (cheaper than a map)
+	visited := make([]uint64, len(index.nodes)/64+1)
+	visited[entryPoint/64] |= 1 << (entryPoint % 64)
+	// candidates: the most similar first, results: the least similar first
+	candidates := &candidatesHeap{best: true, items: []hnswCandidate{start}}
+	results := &candidatesHeap{items: []hnswCandidate{start}}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(hnswCandidate)
+		if current.similarity < results.items[0].similarity && results.Len() >= ef {
+			break
+		}
+		node := index.nodes[current.node]
+		if layer >= len(node.neighbours) {
+			continue
+		}
+		for _, neighbour := range node.neighbours[layer] {
+			if visited[neighbour/64]&(1<<(neighbour%64)) != 0 {
+				continue
+			}
+			visited[neighbour/64] |= 1 << (neighbour % 64)
+			candidate := hnswCandidate{node: neighbour, similarity: similarity(query, index.nodes[neighbour].vector)}
+			if results.Len() < ef || candidate.similarity > results.items[0].similarity {
+				heap.Push(candidates, candidate)
+				heap.Push(results, candidate)
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := results.items
+	sortCandidates(sorted)
+	return sorted
+}
+
+// similarity of two normalized vectors (cosine similarity).
+// The loop is unrolled: it is the hot path of the index.
+func similarity(v1, v2 []float32) float64 {
+	v2 = v2[:len(v1)]
+	var sum0, sum1, sum2, sum3 float32
+	i := 0
+	for ; i+4 <= len(v1); i += 4 {
+		sum0 += v1[i] * v2[i]
+		sum1 += v1[i+1] * v2[i+1]
+		sum2 += v1[i+2] * v2[i+2]
+		sum3 += v1[i+3] * v2[i+3]
+	}
+	for ; i < len(v1); i++ {
+		sum0 += v1[i] * v2[i]
+	}
+	return float64(sum0 + sum1 + sum2 + sum3)
+}
+
+type hnswCandidate struct {
+	node       int
+	similarity float64
+}
+
+// sortCandidates sorts the candidates by descending similarity
+func sortCandidates(candidates []hnswCandidate) {
+	slices.SortFunc(candidates, func(a, b hnswCandidate) int {
+		return cmp.Compare(b.similarity, a.similarity)
+	})
+}
+
+// candidatesHeap is a heap of candidates: the most similar on top if best is true, the least similar otherwise
+type candidatesHeap struct {
+	best  bool
+	items []hnswCandidate
+}
+
+func (h *candidatesHeap) Len() int { return len(h.items) }
+func (h *candidatesHeap) Less(i, j int) bool {
+	if h.best {
+		return h.items[i].similarity > h.items[j].similarity
+	}
+	return h.items[i].similarity < h.items[j].similarity
+}
+func (h *candidatesHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *candidatesHeap) Push(x any)    { h.items = append(h.items, x.(hnswCandidate)) }
+func (h *candidatesHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,164 @@
+package rag
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// randomVector returns center + noise (a random vector if center is nil)
+func randomVector(random *rand.Rand, dimensions int, center []float32, noise float64) []float32 {
+	vector := make([]float32, dimensions)
+	for i := range vector {
+		value := random.NormFloat64() * noise
+		if center != nil {
+			value += float64(center[i])
+		}
+		vector[i] = float32(value)
+	}
+	return vector
+}
+
+// clusteredStore returns a store of random embeddings grouped in clusters, and queries close to its records
+func clusteredStore(records, dimensions, clusters, queries int) (*MemoryVectorStore, [][]float32) {
+	random := rand.New(rand.NewSource(1))
+	centers := make([][]float32, clusters)
+	for i := range centers {
+		centers[i] = randomVector(random, dimensions, nil, 1)
+	}
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	embeddings := make([][]float32, 0, records)
+	for i := range records {
+		embedding := randomVector(random, dimensions, centers[random.Intn(clusters)], 0.3)
+		embeddings = append(embeddings, embedding)
+		store.Save(VectorRecord{Id: fmt.Sprintf("record-%d", i), Embedding: embedding})
+	}
+	queryEmbeddings := make([][]float32, queries)
+	for i := range queryEmbeddings {
+		queryEmbeddings[i] = randomVector(random, dimensions, embeddings[random.Intn(records)], 0.1)
+	}
+	return store, queryEmbeddings
+}
+
+func TestHNSWRecall(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   HNSWOptions
+		minRecall float64
+	}{
+		{"default", HNSWOptions{}, 0.95},
+		{"small graph", HNSWOptions{M: 4, EfConstruction: 32, EfSearch: 16}, 0.7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, queries := clusteredStore(2000, 64, 20, 100)
+			if err := store.UseIndex(NewHNSWIndex(test.options)); err != nil {
+				t.Fatal(err)
+			}
+			benchmark, err := BenchmarkIndex(store, queries, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if benchmark.Recall < test.minRecall {
+				t.Fatalf("recall@5 = %.3f, want at least %.2f", benchmark.Recall, test.minRecall)
+			}
+		})
+	}
+}
+
+func TestHNSWRemoveAndCompact(t *testing.T) {
+	store, queries := clusteredStore(1000, 32, 10, 50)
+	index := NewHNSWIndex(HNSWOptions{MaxRemovedRatio: 0.2})
+	if err := store.UseIndex(index); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove 60% of the records, the graph is compacted on the way
+	for i := range 600 {
+		store.Delete(fmt.Sprintf("record-%d", i))
+	}
+	if index.Len() != 400 {
+		t.Fatalf("%d indexed records, want 400", index.Len())
+	}
+	if removed := index.Removed(); float64(removed) > 0.2*float64(index.Len()+removed) {
+		t.Fatalf("%d removed nodes are still in the graph", removed)
+	}
+	for _, result := range index.Search(queries[0], 10) {
+		if _, exists := store.Records[result.Id]; !exists {
+			t.Fatalf("removed record %s returned", result.Id)
+		}
+	}
+
+	benchmark, err := BenchmarkIndex(store, queries, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if benchmark.Recall < 0.95 {
+		t.Fatalf("recall@5 after the deletions = %.3f, want at least 0.95", benchmark.Recall)
+	}
+}
+
+func TestHNSWReplace(t *testing.T) {
+	index := NewHNSWIndex(HNSWOptions{})
+	if err := index.Add("a", []float32{1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Add("b", []float32{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Add("a", []float32{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if index.Len() != 2 {
+		t.Fatalf("%d indexed records, want 2", index.Len())
+	}
+	for _, result := range index.Search([]float32{1, 0}, 2) {
+		if result.CosineSimilarity > 0.5 {
+			t.Fatalf("the old embedding of %s is still indexed", result.Id)
+		}
+	}
+	if err := index.Add("c", []float32{1, 0, 0}); err == nil {
+		t.Fatal("expected an error for a wrong dimension")
+	}
+}
+
+// go test ./compose-dragons/rag -run '^$' -bench 'Search' -benchmem
+func BenchmarkHNSWSearch(b *testing.B) {
+	store, queries := clusteredStore(10000, 256, 50, 200)
+	if err := store.UseIndex(NewHNSWIndex(HNSWOptions{})); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.SearchTopNSimilarities(VectorRecord{Embedding: queries[i%len(queries)]}, -1, 5)
+	}
+	b.StopTimer()
+	benchmark, err := BenchmarkIndex(store, queries[:20], 5)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(benchmark.Recall, "recall@5")
+}
+
+func BenchmarkBruteForceSearch(b *testing.B) {
+	store, queries := clusteredStore(10000, 256, 50, 200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.SearchTopNSimilarities(VectorRecord{Embedding: queries[i%len(queries)]}, -1, 5)
+	}
+}
+
+func BenchmarkHNSWAdd(b *testing.B) {
+	random := rand.New(rand.NewSource(1))
+	embeddings := make([][]float32, 1000)
+	for i := range embeddings {
+		embeddings[i] = randomVector(random, 256, nil, 1)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := NewHNSWIndex(HNSWOptions{})
+		for j, embedding := range embeddings {
+			index.Add(fmt.Sprint(j), embedding)
+		}
+	}
+}
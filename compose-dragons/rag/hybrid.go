@@ -0,0 +1,173 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// UseKeywordIndex indexes the prompts of all the records of the store for SearchKeywords.
+// The records saved afterwards are indexed too.
+func (mvs *MemoryVectorStore) UseKeywordIndex(index *BM25Index) {
+	for id, record := range mvs.Records {
+		index.Add(id, record.Prompt)
+	}
+	mvs.Keywords = index
+}
+
+// SearchKeywords returns the max records with the best BM25 score for the query (see UseKeywordIndex),
+// selected by the filter (nil for all the records). The CosineSimilarity of the records is not set.
+func (mvs *MemoryVectorStore) SearchKeywords(query string, max int, filter *MetadataFilter) ([]VectorRecord, []KeywordResult, error) {
+	if mvs.Keywords == nil {
+		return nil, nil, fmt.Errorf("the store has no keyword index (see UseKeywordIndex)")
+	}
+	records := []VectorRecord{}
+	results := []KeywordResult{}
+	// The filtered records take some places of the results: all the matching documents are ranked
+	k := max
+	if filter != nil {
+		k = mvs.Keywords.Len()
+	}
+	for _, result := range mvs.Keywords.Search(query, k) {
+		record, exists := mvs.Records[result.Id]
+		if !exists || !filter.Match(record) {
+			continue
+		}
+		records = append(records, record)
+		results = append(results, result)
+		if len(records) == max {
+			break
+		}
+	}
+	return records, results, nil
+}
+
+// FusedResult is a document of ReciprocalRankFusion
+type FusedResult struct {
+	Id    string
+	Score float64
+}
+
+// ReciprocalRankFusion merges several rankings (lists of ids, the best first):
+// the score of a document is the sum of 1/(k + rank) of each ranking containing it.
+// k (60 if 0 or less) reduces the weight of the first ranks.
+//
+//	vector:  [A, B, C]      A: 1/61 + 1/62 = 0.0325
+//	keyword: [D, A]         D: 1/61        = 0.0164
+//	                        B: 1/62        = 0.0161
+func ReciprocalRankFusion(k int, rankings ...[]string) []FusedResult {
+	if k <= 0 {
+		k = 60
+	}
+	scores := map[string]float64{}
+	for _, ranking := range rankings {
+		for rank, id := range ranking {
+			scores[id] += 1 / float64(k+rank+1)
+		}
+	}
+	results := make([]FusedResult, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, FusedResult{Id: id, Score: score})
+	}
+	slices.SortFunc(results, func(a, b FusedResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return results
+}
+
+// Reranker scores the relevance of the documents for the query (LLM or cross-encoder style):
+// it returns one score by document, the higher the better.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, documents []*ai.Document) ([]float64, error)
+}
+
+// RerankerFunc is a function implementing Reranker
+type RerankerFunc func(ctx context.Context, query string, documents []*ai.Document) ([]float64, error)
+
+// Rerank calls the function
+func (f RerankerFunc) Rerank(ctx context.Context, query string, documents []*ai.Document) ([]float64, error) {
+	return f(ctx, query, documents)
+}
+
+// LLMReranker asks a chat model to rate the relevance of each document from 0 to 10
+type LLMReranker struct {
+	genKitInstance *genkit.Genkit
+	modelId        string
+}
+
+// NewLLMReranker creates a reranker using the model (e.g. "openai/ai/qwen2.5:latest")
+func NewLLMReranker(g *genkit.Genkit, modelId string) *LLMReranker {
+	return &LLMReranker{genKitInstance: g, modelId: modelId}
+}
+
+type rerankScores struct {
+	Scores []float64 `json:"scores"`
+}
+
+// Rerank implements Reranker
+func (reranker *LLMReranker) Rerank(ctx context.Context, query string, documents []*ai.Document) ([]float64, error) {
+	var prompt strings.Builder
+	prompt.WriteString("Rate the relevance of each passage for the question, from 0 (not relevant) to 10 (answers the question).\n")
+	fmt.Fprintf(&prompt, "Answer with the %d scores in the order of the passages.\n\n", len(documents))
+	fmt.Fprintf(&prompt, "QUESTION: %s\n", query)
+	for i, document := range documents {
+		fmt.Fprintf(&prompt, "\nPASSAGE %d:\n%s\n", i+1, documentText(document))
+	}
+
+	resp, err := genkit.Generate(ctx, reranker.genKitInstance,
+		ai.WithModelName(reranker.modelId),
+		ai.WithPrompt(prompt.String()),
+		ai.WithConfig(map[string]any{"temperature": 0.0}),
+		ai.WithOutputType(rerankScores{}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var output rerankScores
+	if err := resp.Output(&output); err != nil {
+		return nil, err
+	}
+	if len(output.Scores) != len(documents) {
+		return nil, fmt.Errorf("the reranker returned %d scores for %d documents", len(output.Scores), len(documents))
+	}
+	return output.Scores, nil
+}
+
+// rerankDocuments sorts the documents by descending reranker score
+// (the fused order is kept for the same score) and sets the "rerank_score" metadata
+func rerankDocuments(ctx context.Context, reranker Reranker, query string, documents []*ai.Document) ([]*ai.Document, error) {
+	if len(documents) == 0 {
+		return documents, nil
+	}
+	scores, err := reranker.Rerank(ctx, query, documents)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) != len(documents) {
+		return nil, fmt.Errorf("the reranker returned %d scores for %d documents", len(scores), len(documents))
+	}
+	for i, document := range documents {
+		document.Metadata["rerank_score"] = scores[i]
+	}
+	reranked := slices.Clone(documents)
+	slices.SortStableFunc(reranked, func(a, b *ai.Document) int {
+		return cmp.Compare(b.Metadata["rerank_score"].(float64), a.Metadata["rerank_score"].(float64))
+	})
+	return reranked, nil
+}
+
+func documentText(document *ai.Document) string {
+	var text strings.Builder
+	for _, part := range document.Content {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
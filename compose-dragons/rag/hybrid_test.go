@@ -0,0 +1,135 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func TestBM25Search(t *testing.T) {
+	index := NewBM25Index(BM25Options{})
+	index.Add("guard", "The guard Grimbold keeps the gate of the dungeon.")
+	index.Add("password", "The secret password of the gate is moonlight. Moonlight opens the gate.")
+	index.Add("merchant", "The merchant sells potions and swords.")
+	index.Add("dragon", "A red dragon sleeps in the deepest room of the dungeon.")
+
+	tests := []struct {
+		name  string
+		query string
+		k     int
+		want  []string
+	}{
+		{"exact term", "Grimbold", 5, []string{"guard"}},
+		{"term frequency", "moonlight", 5, []string{"password"}},
+		{"rare term first", "gate moonlight", 5, []string{"password", "guard"}},
+		{"several documents", "dungeon", 5, []string{"dragon", "guard"}},
+		{"stop words only", "the of is", 5, []string{}},
+		{"unknown term", "unicorn", 5, []string{}},
+		{"k", "dungeon gate", 1, []string{"guard"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids := []string{}
+			for _, result := range index.Search(test.query, test.k) {
+				ids = append(ids, result.Id)
+			}
+			if !slices.Equal(ids, test.want) {
+				t.Fatalf("Search(%q) = %v, want %v", test.query, ids, test.want)
+			}
+		})
+	}
+
+	index.Remove("guard")
+	if results := index.Search("Grimbold", 5); len(results) != 0 || index.Len() != 3 {
+		t.Fatalf("the removed document is still indexed: %v", results)
+	}
+}
+
+func TestReciprocalRankFusion(t *testing.T) {
+	tests := []struct {
+		name     string
+		k        int
+		rankings [][]string
+		want     []FusedResult
+	}{
+		{
+			name:     "one ranking",
+			k:        60,
+			rankings: [][]string{{"A", "B"}},
+			want:     []FusedResult{{"A", 1.0 / 61}, {"B", 1.0 / 62}},
+		},
+		{
+			name:     "documents of both rankings first",
+			rankings: [][]string{{"A", "B", "C"}, {"D", "A"}},
+			want:     []FusedResult{{"A", 1.0/61 + 1.0/62}, {"D", 1.0 / 61}, {"B", 1.0 / 62}, {"C", 1.0 / 63}},
+		},
+		{
+			name:     "ties sorted by id",
+			k:        1,
+			rankings: [][]string{{"B"}, {"A"}},
+			want:     []FusedResult{{"A", 0.5}, {"B", 0.5}},
+		},
+		{
+			name:     "empty rankings",
+			rankings: [][]string{{}, nil},
+			want:     []FusedResult{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ReciprocalRankFusion(test.k, test.rankings...)
+			if !slices.EqualFunc(got, test.want, func(a, b FusedResult) bool {
+				return a.Id == b.Id && math.Abs(a.Score-b.Score) < 1e-12
+			}) {
+				t.Fatalf("ReciprocalRankFusion = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentHybridRetrievals(t *testing.T) {
+	g := genkit.Init(context.Background())
+	embedder := DefineHashingEmbedder(g, "hashing-64", 64)
+	embed := HashingEmbedFunc(64)
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	for i := range 50 {
+		prompt := fmt.Sprintf("room %d of the dungeon, guarded by goblin %d", i, i)
+		embedding, err := embed(context.Background(), prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Save(VectorRecord{Id: fmt.Sprint(i), Prompt: prompt, Embedding: embedding})
+	}
+
+	store.UseKeywordIndex(NewBM25Index(BM25Options{}))
+	retriever, err := DefineNamedMemoryVectorRetriever(g, "hybrid", store, embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := retriever.Retrieve(context.Background(), &ai.RetrieverRequest{
+				Query:   ai.DocumentFromText(fmt.Sprintf("goblin %d", i), nil),
+				Options: MemoryVectorRetrieverOptions{MaxResults: 3, Hybrid: true},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(resp.Documents) == 0 || resp.Documents[0].Metadata["bm25_score"] == nil {
+				t.Errorf("goblin %d: no keyword result", i)
+			}
+		}()
+	}
+	wg.Wait()
+}
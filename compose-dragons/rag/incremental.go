@@ -0,0 +1,61 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// ContentHash returns the SHA-256 (hex) of a text: chunk or source file
+func ContentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// VectorStoreFormatVersion is part of the source hashes (see IngestFiles): increasing it rebuilds the stores once.
+// The records of the version 1 have no metadata (see RecordMetadata).
+const VectorStoreFormatVersion = 2
+
+// EmbedFunc returns the embedding of a chunk
+type EmbedFunc func(ctx context.Context, chunk string) ([]float32, error)
+
+// RebuildStats counts the records of an incremental rebuild (see Rebuild)
+type RebuildStats struct {
+	Kept     int
+	Embedded int
+	Removed  int
+}
+
+// String formats the stats for the terminal
+func (stats RebuildStats) String() string {
+	return fmt.Sprintf("%d kept, %d embedded, %d removed", stats.Kept, stats.Embedded, stats.Removed)
+}
+
+// IsUpToDate returns true if all the records were created from the source (same hash)
+// with the embedding model, so the store does not need a Rebuild
+func (mvs *MemoryVectorStore) IsUpToDate(sourceHash string, embeddingModel string) bool {
+	if len(mvs.Records) == 0 {
+		return false
+	}
+	for _, record := range mvs.Records {
+		if record.SourceHash != sourceHash || record.EmbeddingModel != embeddingModel {
+			return false
+		}
+	}
+	return true
+}
+
+// Rebuild updates the store with the chunks of the source: it only embeds the chunks
+// that are new, changed, or embedded with another model, and removes the stale records.
+// The metadata of the kept records is updated from the chunks.
+//
+//	chunks:   [A, B', D]          (B changed, C removed, D added)
+//	records:  [A, B, C]
+//	result:   [A (kept), B' (embedded), D (embedded)], C removed
+//
+// The records of the files created before the content hashes (no model) are embedded again.
+// See RebuildWithOptions for the batched and concurrent embedding requests.
+func (mvs *MemoryVectorStore) Rebuild(ctx context.Context, sourceHash string, chunks []Chunk, embeddingModel string, embed EmbedFunc) (RebuildStats, error) {
+	return mvs.RebuildWithOptions(ctx, sourceHash, chunks, embeddingModel, embed.Batched(), RebuildOptions{})
+}
@@ -0,0 +1,115 @@
+package rag
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// countingEmbedFunc returns a HashingEmbedFunc and the embedded chunks
+func countingEmbedFunc() (EmbedFunc, *[]string) {
+	embed := HashingEmbedFunc(32)
+	embedded := []string{}
+	return func(ctx context.Context, chunk string) ([]float32, error) {
+		embedded = append(embedded, chunk)
+		return embed(ctx, chunk)
+	}, &embedded
+}
+
+func chunksOf(contents ...string) []Chunk {
+	chunks := []Chunk{}
+	for _, content := range contents {
+		chunks = append(chunks, Chunk{Content: content})
+	}
+	return chunks
+}
+
+func TestRebuild(t *testing.T) {
+	// The steps run in order on the same store
+	tests := []struct {
+		name         string
+		chunks       []Chunk
+		model        string
+		want         RebuildStats
+		wantEmbedded []string
+	}{
+		{"first build", chunksOf("A", "B", "C"), "model-1", RebuildStats{Embedded: 3}, []string{"A", "B", "C"}},
+		{"same chunks", chunksOf("A", "B", "C"), "model-1", RebuildStats{Kept: 3}, []string{}},
+		{"changed, removed and added chunks", chunksOf("A", "B'", "D"), "model-1", RebuildStats{Kept: 1, Embedded: 2, Removed: 2}, []string{"B'", "D"}},
+		{"moved chunks", chunksOf("D", "A", "B'"), "model-1", RebuildStats{Kept: 3}, []string{}},
+		{"duplicated chunk embedded once", chunksOf("D", "A", "B'", "E", "E"), "model-1", RebuildStats{Kept: 3, Embedded: 2}, []string{"E"}},
+		{"duplicated chunk removed", chunksOf("D", "A", "B'", "E"), "model-1", RebuildStats{Kept: 4, Removed: 1}, []string{}},
+		{"other model", chunksOf("D", "A"), "model-2", RebuildStats{Embedded: 2, Removed: 4}, []string{"D", "A"}},
+		{"no chunks", nil, "model-2", RebuildStats{Removed: 2}, []string{}},
+	}
+
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	for _, test := range tests {
+		embed, embedded := countingEmbedFunc()
+		stats, err := store.Rebuild(context.Background(), "source", test.chunks, test.model, embed)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if stats != test.want {
+			t.Fatalf("%s: %s, want %s", test.name, stats, test.want)
+		}
+		if !slices.Equal(*embedded, test.wantEmbedded) {
+			t.Fatalf("%s: embedded %v, want %v", test.name, *embedded, test.wantEmbedded)
+		}
+		if len(store.Records) != len(test.chunks) {
+			t.Fatalf("%s: %d records, want %d", test.name, len(store.Records), len(test.chunks))
+		}
+	}
+}
+
+func TestRebuildUpdatesTheMetadata(t *testing.T) {
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	embed, embedded := countingEmbedFunc()
+	if _, err := store.Rebuild(context.Background(), "v1", []Chunk{{Content: "A", Metadata: RecordMetadata{Owner: "guard"}}}, "model", embed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Rebuild(context.Background(), "v2", []Chunk{{Content: "A", Metadata: RecordMetadata{Owner: "merchant"}}}, "model", embed); err != nil {
+		t.Fatal(err)
+	}
+	if len(*embedded) != 1 {
+		t.Fatalf("embedded %v, want only the first build", *embedded)
+	}
+	for _, record := range store.Records {
+		if record.Metadata.Owner != "merchant" || record.SourceHash != "v2" {
+			t.Fatalf("record not updated: %+v", record)
+		}
+	}
+}
+
+func TestIsUpToDate(t *testing.T) {
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	if store.IsUpToDate("source", "model") {
+		t.Fatal("an empty store is up to date")
+	}
+	embed, _ := countingEmbedFunc()
+	if _, err := store.Rebuild(context.Background(), "source", chunksOf("A", "B"), "model", embed); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		model  string
+		want   bool
+	}{
+		{"same source and model", "source", "model", true},
+		{"other source", "changed", "model", false},
+		{"other model", "source", "other", false},
+	}
+	for _, test := range tests {
+		if got := store.IsUpToDate(test.source, test.model); got != test.want {
+			t.Fatalf("%s: IsUpToDate = %t, want %t", test.name, got, test.want)
+		}
+	}
+
+	// A record of an older file (no hash, no model)
+	store.Save(VectorRecord{Prompt: "C", Embedding: []float32{1}})
+	if store.IsUpToDate("source", "model") {
+		t.Fatal("a store with a record without hash is up to date")
+	}
+}
@@ -0,0 +1,85 @@
+package rag
+
+import (
+	"fmt"
+	"time"
+)
+
+// IndexBenchmark compares the index of a store with the brute-force search (see BenchmarkIndex)
+type IndexBenchmark struct {
+	Records int
+	Queries int
+	K       int
+	// Recall is the ratio of the exact top K records found by the index
+	Recall     float64
+	BruteForce time.Duration
+	Index      time.Duration
+}
+
+// String formats the benchmark for the terminal
+func (benchmark IndexBenchmark) String() string {
+	speedup := 0.0
+	if benchmark.Index > 0 {
+		speedup = float64(benchmark.BruteForce) / float64(benchmark.Index)
+	}
+	return fmt.Sprintf("records: %d queries: %d top %d | recall: %.3f | brute force: %v/query | index: %v/query | speedup: x%.1f",
+		benchmark.Records, benchmark.Queries, benchmark.K, benchmark.Recall,
+		benchmark.BruteForce/time.Duration(max(benchmark.Queries, 1)),
+		benchmark.Index/time.Duration(max(benchmark.Queries, 1)),
+		speedup,
+	)
+}
+
+// BenchmarkIndex runs the queries with the brute-force search and with the index of the store
+// (the store must use an index, see UseIndex) and measures the recall of the top K records
+func BenchmarkIndex(store *MemoryVectorStore, queries [][]float32, k int) (IndexBenchmark, error) {
+	if store.Index == nil {
+		return IndexBenchmark{}, fmt.Errorf("the store has no index")
+	}
+	benchmark := IndexBenchmark{
+		Records: len(store.Records),
+		Queries: len(queries),
+		K:       k,
+	}
+	bruteForceStore := &MemoryVectorStore{Records: store.Records}
+
+	// The exact results
+	expected := make([]map[string]bool, len(queries))
+	start := time.Now()
+	for i, query := range queries {
+		records, err := bruteForceStore.SearchTopNSimilarities(VectorRecord{Embedding: query}, -1, k)
+		if err != nil {
+			return IndexBenchmark{}, err
+		}
+		expected[i] = map[string]bool{}
+		for _, record := range records {
+			expected[i][record.Id] = true
+		}
+	}
+	benchmark.BruteForce = time.Since(start)
+
+	found, total := 0, 0
+	start = time.Now()
+	results := make([][]VectorRecord, len(queries))
+	for i, query := range queries {
+		records, err := store.SearchTopNSimilarities(VectorRecord{Embedding: query}, -1, k)
+		if err != nil {
+			return IndexBenchmark{}, err
+		}
+		results[i] = records
+	}
+	benchmark.Index = time.Since(start)
+
+	for i, records := range results {
+		total += len(expected[i])
+		for _, record := range records {
+			if expected[i][record.Id] {
+				found++
+			}
+		}
+	}
+	if total > 0 {
+		benchmark.Recall = float64(found) / float64(total)
+	}
+	return benchmark, nil
+}
@@ -0,0 +1,444 @@
+package rag
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IngestOptions are the chunking options of IngestFiles
+type IngestOptions struct {
+	// MaxTokens is the approximate maximum size of the chunks (no limit if 0), see Chunker
+	MaxTokens     int
+	OverlapTokens int
+}
+
+// IngestResult holds the chunks of all the ingested files
+type IngestResult struct {
+	Chunks []Chunk
+	// Files are the ingested files (sorted)
+	Files []string
+	// SourceHash is the hash of the store format version, the ingest options (pattern, loaders, chunking),
+	// the paths and the contents of the files (see Rebuild)
+	SourceHash string
+}
+
+// DocumentLoader returns the chunks of a document. The metadata of the document
+// (source, sidecar file) is added to the chunks by IngestFiles.
+type DocumentLoader func(content string, options IngestOptions) ([]Chunk, error)
+
+// documentLoaders are the loaders by file extension (see RegisterDocumentLoader)
+var documentLoaders = map[string]DocumentLoader{
+	".md":       loadMarkdown,
+	".markdown": loadMarkdown,
+	".txt":      loadText,
+	".json":     loadJSON,
+	".jsonl":    loadJSONLines,
+	".csv":      loadCSV,
+	".html":     loadHTML,
+	".htm":      loadHTML,
+}
+
+// RegisterDocumentLoader adds (or replaces) the loader of a file extension (e.g. ".yaml")
+func RegisterDocumentLoader(extension string, loader DocumentLoader) {
+	documentLoaders[strings.ToLower(extension)] = loader
+}
+
+// documentLoadersVersion is part of the source hashes (see IngestFiles):
+// increase it when a loader splits the documents differently (e.g. one chunk by CSV row)
+const documentLoadersVersion = 1
+
+// metadataSidecarSuffix is the suffix of the metadata file of a document: lore.csv -> lore.csv.meta.yaml
+//
+//	tags: [items, shop]
+//	owner: merchant
+//	spoiler: 0
+const metadataSidecarSuffix = ".meta.yaml"
+
+// IngestFiles loads a file, the files of a directory (recursively) or the files matching a glob pattern,
+// and returns the chunks of all the documents with a known format:
+// markdown, plain text, JSON (an object or an array of objects), JSONL, CSV and HTML.
+//
+// The hidden files, the vector store files and the metadata sidecar files are ignored.
+// The source of the chunks is the path of the file relative to the directory (or the file name).
+func IngestFiles(pattern string, options IngestOptions) (IngestResult, error) {
+	files, baseDir, err := ingestedFiles(pattern)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	if len(files) == 0 {
+		return IngestResult{}, fmt.Errorf("no document to ingest in %q", pattern)
+	}
+
+	result := IngestResult{Files: files}
+	hashes := []string{
+		"format " + strconv.Itoa(VectorStoreFormatVersion),
+		fmt.Sprintf("chunker %d: %d max tokens, %d overlap tokens", chunkerVersion, options.MaxTokens, options.OverlapTokens),
+		fmt.Sprintf("loaders %d: %s", documentLoadersVersion, filepath.Clean(pattern)),
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return IngestResult{}, err
+		}
+		source, err := filepath.Rel(baseDir, file)
+		if err != nil {
+			source = filepath.Base(file)
+		}
+		source = filepath.ToSlash(source)
+
+		documentMetadata := RecordMetadata{}
+		sidecar, err := os.ReadFile(file + metadataSidecarSuffix)
+		if err == nil {
+			if err := yaml.Unmarshal(sidecar, &documentMetadata); err != nil {
+				return IngestResult{}, fmt.Errorf("%s%s: %w", file, metadataSidecarSuffix, err)
+			}
+		}
+		documentMetadata.Source = source
+		hashes = append(hashes, source, ContentHash(string(data)), ContentHash(string(sidecar)))
+
+		loader := documentLoaders[strings.ToLower(filepath.Ext(file))]
+		chunks, err := loader(string(data), options)
+		if err != nil {
+			return IngestResult{}, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, chunk := range chunks {
+			chunk.Metadata = mergeMetadata(documentMetadata, chunk.Metadata)
+			result.Chunks = append(result.Chunks, chunk)
+		}
+	}
+	result.SourceHash = ContentHash(strings.Join(hashes, "\n"))
+	return result, nil
+}
+
+// ingestedFiles returns the files to ingest (sorted) and the directory of the sources
+func ingestedFiles(pattern string) ([]string, string, error) {
+	ingested := func(path string) bool {
+		name := filepath.Base(path)
+		_, known := documentLoaders[strings.ToLower(filepath.Ext(name))]
+		return known && !strings.HasPrefix(name, ".") &&
+			!strings.Contains(name, ".vectorstore.") && !strings.HasSuffix(name, metadataSidecarSuffix)
+	}
+
+	info, err := os.Stat(pattern)
+	switch {
+	case err == nil && !info.IsDir():
+		// A single file is always ingested (if its format is known)
+		if _, known := documentLoaders[strings.ToLower(filepath.Ext(pattern))]; !known {
+			return nil, "", fmt.Errorf("unknown document format %q", filepath.Ext(pattern))
+		}
+		return []string{pattern}, filepath.Dir(pattern), nil
+
+	case err == nil:
+		files := []string{}
+		err := filepath.WalkDir(pattern, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() && path != pattern && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !entry.IsDir() && ingested(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		return files, pattern, err
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	files := []string{}
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() && ingested(match) {
+			files = append(files, match)
+		}
+	}
+	slices.Sort(files)
+	return files, globBaseDir(pattern), nil
+}
+
+// globBaseDir returns the directory of a glob pattern before the first wildcard
+func globBaseDir(pattern string) string {
+	if index := strings.IndexAny(pattern, "*?["); index >= 0 {
+		pattern = pattern[:index]
+		if !strings.HasSuffix(pattern, string(filepath.Separator)) && !strings.HasSuffix(pattern, "/") {
+			return filepath.Dir(pattern)
+		}
+	}
+	return filepath.Clean(pattern)
+}
+
+// mergeMetadata returns the metadata of a chunk completed with the metadata of its document
+func mergeMetadata(document, chunk RecordMetadata) RecordMetadata {
+	merged := chunk
+	merged.Source = document.Source
+	if len(merged.Tags) == 0 {
+		merged.Tags = document.Tags
+	}
+	if merged.Owner == "" {
+		merged.Owner = document.Owner
+	}
+	if merged.Spoiler == 0 {
+		merged.Spoiler = document.Spoiler
+	}
+	if len(document.Extra) > 0 {
+		merged.Extra = maps.Clone(document.Extra)
+		maps.Copy(merged.Extra, chunk.Extra)
+	}
+	return merged
+}
+
+func loadMarkdown(content string, options IngestOptions) ([]Chunk, error) {
+	chunks := MarkdownChunker{MaxTokens: options.MaxTokens, OverlapTokens: options.OverlapTokens}.Chunk(content)
+	if len(chunks) == 0 {
+		// No heading
+		return loadText(content, options)
+	}
+	return chunks, nil
+}
+
+func loadText(content string, options IngestOptions) ([]Chunk, error) {
+	return RecursiveChunker{MaxTokens: options.MaxTokens, OverlapTokens: options.OverlapTokens}.Chunk(content), nil
+}
+
+// loadJSON loads an object, or an array of objects (e.g. an item catalog), one chunk by object
+func loadJSON(content string, options IngestOptions) ([]Chunk, error) {
+	var value any
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	items, isArray := value.([]any)
+	if !isArray {
+		items = []any{value}
+	}
+	chunks := []Chunk{}
+	for _, item := range items {
+		chunks = append(chunks, itemChunks(item, options)...)
+	}
+	return chunks, nil
+}
+
+// loadJSONLines loads one JSON object by line
+func loadJSONLines(content string, options IngestOptions) ([]Chunk, error) {
+	chunks := []Chunk{}
+	for number, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var item any
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+		chunks = append(chunks, itemChunks(item, options)...)
+	}
+	return chunks, nil
+}
+
+// loadCSV loads one chunk by row, the first row is the header
+func loadCSV(content string, options IngestOptions) ([]Chunk, error) {
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return []Chunk{}, nil
+	}
+	header := rows[0]
+	chunks := []Chunk{}
+	for _, row := range rows[1:] {
+		item := map[string]any{}
+		keys := []string{}
+		for i, value := range row {
+			if i < len(header) && strings.TrimSpace(value) != "" {
+				item[header[i]] = value
+				keys = append(keys, header[i])
+			}
+		}
+		chunks = append(chunks, orderedItemChunks(item, keys, options)...)
+	}
+	return chunks, nil
+}
+
+// itemChunks returns the chunks of a JSON value (the keys of an object are sorted)
+func itemChunks(item any, options IngestOptions) []Chunk {
+	object, isObject := item.(map[string]any)
+	if !isObject {
+		return toChunks([]string{formatItemValue(item)})
+	}
+	keys := slices.Sorted(maps.Keys(object))
+	return orderedItemChunks(object, keys, options)
+}
+
+// itemTitleKeys are the fields used as the title of an item
+var itemTitleKeys = []string{"name", "title", "id"}
+
+// orderedItemChunks formats an item as "key: value" lines, with its title (name, title or id field).
+// The tags, owner and spoiler fields are the metadata of the chunk.
+//
+//	TITLE: Healing potion
+//	CONTENT: name: Healing potion
+//	price: 50
+//	effect: restores 2d4 HP
+func orderedItemChunks(item map[string]any, keys []string, options IngestOptions) []Chunk {
+	title := ""
+	for _, key := range itemTitleKeys {
+		if value, exists := item[key]; exists {
+			title = formatItemValue(value)
+			break
+		}
+	}
+
+	metadata := RecordMetadata{}
+	lines := []string{}
+	for _, key := range keys {
+		value := formatItemValue(item[key])
+		switch strings.ToLower(key) {
+		case "tags":
+			metadata.Tags = []string{}
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					metadata.Tags = append(metadata.Tags, tag)
+				}
+			}
+		case "owner":
+			metadata.Owner = value
+		case "spoiler":
+			metadata.Spoiler, _ = strconv.Atoi(value)
+		}
+		lines = append(lines, key+": "+value)
+	}
+	if title != "" {
+		metadata.Headings = []string{title}
+	}
+
+	header := "TITLE: " + title + "\nCONTENT: "
+	content := strings.Join(lines, "\n")
+	contents := []string{content}
+	if options.MaxTokens > 0 && EstimateTokens(header+content) > options.MaxTokens {
+		maxTokens := max(options.MaxTokens-EstimateTokens(header), 1)
+		contents = []string{}
+		for _, part := range (RecursiveChunker{MaxTokens: maxTokens, OverlapTokens: min(options.OverlapTokens, maxTokens/2)}).Chunk(content) {
+			contents = append(contents, part.Content)
+		}
+	}
+	chunks := []Chunk{}
+	for _, part := range contents {
+		chunks = append(chunks, Chunk{Content: header + part, Metadata: metadata})
+	}
+	return chunks
+}
+
+// formatItemValue formats a JSON value: the arrays are comma separated, the objects are compact JSON
+func formatItemValue(value any) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case []any:
+		values := []string{}
+		for _, element := range typed {
+			values = append(values, formatItemValue(element))
+		}
+		return strings.Join(values, ", ")
+	case map[string]any:
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(typed); err != nil {
+			return fmt.Sprint(typed)
+		}
+		return strings.TrimSpace(buffer.String())
+	}
+	return fmt.Sprint(value)
+}
+
+var (
+	htmlHiddenRegex     = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlCommentRegex    = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlHeadingRegex    = regexp.MustCompile(`(?i)<h([1-6])[^>]*>`)
+	htmlHeadingEndRegex = regexp.MustCompile(`(?i)</h[1-6]\s*>`)
+	htmlListItemRegex   = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlBlockEndRegex   = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|table|ul|ol|section|article|blockquote|pre)\s*>`)
+	htmlTagRegex        = regexp.MustCompile(`<[^>]+>`)
+	blankLinesRegex     = regexp.MustCompile(`\n{3,}`)
+)
+
+// loadHTML converts the HTML page to markdown (headings, paragraphs, lists) and loads it as markdown.
+// The metadata annotations (<!-- key: value -->) are kept.
+func loadHTML(content string, options IngestOptions) ([]Chunk, error) {
+	return loadMarkdown(htmlToMarkdown(content), options)
+}
+
+// htmlToMarkdown is a simple conversion of an HTML page for the chunkers (it is not a full HTML parser)
+func htmlToMarkdown(page string) string {
+	text := htmlHiddenRegex.ReplaceAllString(page, "")
+	text = htmlCommentRegex.ReplaceAllStringFunc(text, func(comment string) string {
+		if _, _, ok := parseMetadataAnnotation(comment); ok {
+			return "\n" + comment + "\n"
+		}
+		return ""
+	})
+	text = htmlHeadingRegex.ReplaceAllStringFunc(text, func(tag string) string {
+		level, _ := strconv.Atoi(htmlHeadingRegex.FindStringSubmatch(tag)[1])
+		return "\n\n" + strings.Repeat("#", level) + " "
+	})
+	text = htmlHeadingEndRegex.ReplaceAllString(text, "\n\n")
+	text = htmlListItemRegex.ReplaceAllString(text, "\n- ")
+	text = htmlBlockEndRegex.ReplaceAllString(text, "\n")
+	text = htmlTagRegex.ReplaceAllStringFunc(text, func(tag string) string {
+		if _, _, ok := parseMetadataAnnotation(tag); ok {
+			return tag
+		}
+		return ""
+	})
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if _, _, ok := parseMetadataAnnotation(line); !ok {
+			line = html.UnescapeString(line)
+		}
+		lines[i] = line
+	}
+	return strings.TrimSpace(blankLinesRegex.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// DefaultVectorStorePath returns the vector store file of the documents of IngestFiles:
+//
+//	./data/guard.md      ->  ./data/guard.md.vectorstore.json
+//	./data/lore          ->  ./data/lore.vectorstore.json
+//	./data/lore/*.csv    ->  ./data/lore/context-<hash of the pattern>.vectorstore.json
+//
+// The extension is .bin for the binary format (see WriteBinary).
+func DefaultVectorStorePath(pattern string, binary bool) string {
+	extension := ".vectorstore.json"
+	if binary {
+		extension = ".vectorstore.bin"
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		return filepath.Join(globBaseDir(pattern), "context-"+ContentHash(pattern)[:12]+extension)
+	}
+	return filepath.Clean(pattern) + extension
+}
@@ -0,0 +1,143 @@
+package rag
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files (path: content) in a temporary directory and returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	directory := t.TempDir()
+	for path, content := range files {
+		path = filepath.Join(directory, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+func TestSourceHash(t *testing.T) {
+	content := "# Guard\n\nThe guard keeps the gate."
+	directory := writeFiles(t, map[string]string{"guard.md": content})
+	ingested, err := IngestFiles(directory, IngestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	embed, _ := countingEmbedFunc()
+	if _, err := store.Rebuild(t.Context(), ingested.SourceHash, ingested.Chunks, "model", embed); err != nil {
+		t.Fatal(err)
+	}
+	if !store.IsUpToDate(ingested.SourceHash, "model") {
+		t.Fatal("the store is not up to date after the rebuild")
+	}
+
+	// The chunks change with the chunking options, the files can change with the pattern
+	tests := []struct {
+		pattern  string
+		options  IngestOptions
+		upToDate bool
+	}{
+		{directory, IngestOptions{}, true},
+		{directory + string(filepath.Separator), IngestOptions{}, true},
+		{directory, IngestOptions{MaxTokens: 8}, false},
+		{directory, IngestOptions{MaxTokens: 8, OverlapTokens: 2}, false},
+		{filepath.Join(directory, "*.md"), IngestOptions{}, false},
+	}
+	for _, test := range tests {
+		again, err := IngestFiles(test.pattern, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upToDate := store.IsUpToDate(again.SourceHash, "model"); upToDate != test.upToDate {
+			t.Fatalf("%s %+v: IsUpToDate = %t, want %t", test.pattern, test.options, upToDate, test.upToDate)
+		}
+	}
+
+	// The stores of the version 1 (hash of the paths and the contents only) are rebuilt
+	version1Hash := ContentHash(strings.Join([]string{"guard.md", ContentHash(content), ContentHash("")}, "\n"))
+	for id, record := range store.Records {
+		record.SourceHash = version1Hash
+		store.Records[id] = record
+	}
+	if store.IsUpToDate(ingested.SourceHash, "model") {
+		t.Fatal("a store of an older format is up to date")
+	}
+}
+
+func TestIngestFiles(t *testing.T) {
+	directory := writeFiles(t, map[string]string{
+		"guard.md":                  "# Guard\n<!-- owner: guard -->\nThe guard keeps the gate.",
+		"notes.txt":                 "The dragon sleeps.",
+		"items.json":                `[{"name": "Sword", "price": 10}, {"name": "Shield", "tags": "armor, wood"}]`,
+		"monsters.jsonl":            "{\"name\": \"Goblin\", \"spoiler\": 1}\n\n{\"name\": \"Orc\"}",
+		"shop.csv":                  "name,price\nPotion,5\nMap,\n",
+		"shop.csv.meta.yaml":        "owner: merchant\ntags: [shop]",
+		"lore/history.html":         "<html><head><title>x</title></head><body><h1>History</h1><p>The &quot;old&quot; war.</p></body></html>",
+		".hidden.md":                "# Hidden",
+		"guard.md.vectorstore.json": "{}",
+		"image.png":                 "png",
+	})
+
+	ingested, err := IngestFiles(directory, IngestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string][]string{}
+	for _, chunk := range ingested.Chunks {
+		sources[chunk.Metadata.Source] = append(sources[chunk.Metadata.Source], chunk.Content)
+	}
+	want := map[string][]string{
+		"guard.md":          {"TITLE: # Guard\nHIERARCHY: Guard\nCONTENT: The guard keeps the gate."},
+		"notes.txt":         {"The dragon sleeps."},
+		"items.json":        {"TITLE: Sword\nCONTENT: name: Sword\nprice: 10", "TITLE: Shield\nCONTENT: name: Shield\ntags: armor, wood"},
+		"monsters.jsonl":    {"TITLE: Goblin\nCONTENT: name: Goblin\nspoiler: 1", "TITLE: Orc\nCONTENT: name: Orc"},
+		"shop.csv":          {"TITLE: Potion\nCONTENT: name: Potion\nprice: 5", "TITLE: Map\nCONTENT: name: Map"},
+		"lore/history.html": {"TITLE: # History\nHIERARCHY: History\nCONTENT: The \"old\" war."},
+	}
+	if !reflect.DeepEqual(sources, want) {
+		t.Fatalf("chunks by source = %q, want %q", sources, want)
+	}
+	if len(ingested.Files) != len(want) {
+		t.Fatalf("files = %v, want %d files", ingested.Files, len(want))
+	}
+
+	metadata := map[string]RecordMetadata{}
+	for _, chunk := range ingested.Chunks {
+		metadata[chunk.Content] = chunk.Metadata
+	}
+	tests := []struct {
+		content string
+		want    RecordMetadata
+	}{
+		{want["guard.md"][0], RecordMetadata{Source: "guard.md", Headings: []string{"Guard"}, Owner: "guard"}},
+		{want["items.json"][1], RecordMetadata{Source: "items.json", Headings: []string{"Shield"}, Tags: []string{"armor", "wood"}}},
+		{want["monsters.jsonl"][0], RecordMetadata{Source: "monsters.jsonl", Headings: []string{"Goblin"}, Spoiler: 1}},
+		{want["shop.csv"][0], RecordMetadata{Source: "shop.csv", Headings: []string{"Potion"}, Tags: []string{"shop"}, Owner: "merchant"}},
+	}
+	for _, test := range tests {
+		if got := metadata[test.content]; !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("metadata of %q = %+v, want %+v", test.content, got, test.want)
+		}
+	}
+
+	// A glob pattern selects the files
+	ingested, err = IngestFiles(filepath.Join(directory, "*.json*"), IngestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ingested.Files) != 2 {
+		t.Fatalf("files = %v, want items.json and monsters.jsonl", ingested.Files)
+	}
+	if _, err := IngestFiles(filepath.Join(directory, "*.pdf"), IngestOptions{}); err == nil {
+		t.Fatal("expected an error without document")
+	}
+}
@@ -0,0 +1,298 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryOptions defines the deduplication and the decay of a MemoryStore
+type MemoryOptions struct {
+	// DuplicateThreshold is the cosine similarity above which a new memory reinforces
+	// an existing one instead of being added (0.92 if 0)
+	DuplicateThreshold float64
+	// HalfLife is the time after which the strength of a memory not seen again is divided by 2 (72h if 0)
+	HalfLife time.Duration
+	// MinStrength is the strength below which a memory is forgotten (0.05 if 0, ~4 half-lives)
+	MinStrength float64
+	// MaxMemories is the maximum number of memories, the weakest ones are forgotten (500 if 0)
+	MaxMemories int
+}
+
+func (options MemoryOptions) withDefaults() MemoryOptions {
+	if options.DuplicateThreshold <= 0 {
+		options.DuplicateThreshold = 0.92
+	}
+	if options.HalfLife <= 0 {
+		options.HalfLife = 72 * time.Hour
+	}
+	if options.MinStrength <= 0 {
+		options.MinStrength = 0.05
+	}
+	if options.MaxMemories <= 0 {
+		options.MaxMemories = 500
+	}
+	return options
+}
+
+// The timestamps and the number of mentions of a memory are kept in the extra metadata
+const (
+	memoryCreatedAt = "created_at"
+	memoryLastSeen  = "last_seen"
+	memoryMentions  = "mentions"
+)
+
+// MemoryStore is a writable vector store of the facts and events to remember
+// (conversations of an NPC, game events shared by all the NPCs).
+//
+// A fact similar to an existing memory reinforces it (mentions + 1, last seen now),
+// the strength of a memory decays with the time since it was last seen:
+//
+//	strength = 0.5^(age / HalfLife) × (1 + ln(mentions))
+//
+// MemoryStore is safe for concurrent use.
+type MemoryStore struct {
+	mu             sync.Mutex
+	store          MemoryVectorStore
+	embed          EmbedFunc
+	embeddingModel string
+	options        MemoryOptions
+	// now is the clock of the memories (time.Now)
+	now func() time.Time
+}
+
+// NewMemoryStore creates an empty memory store embedding the memories with the embedding model
+func NewMemoryStore(embed EmbedFunc, embeddingModel string, options MemoryOptions) *MemoryStore {
+	return &MemoryStore{
+		store:          MemoryVectorStore{Records: make(map[string]VectorRecord)},
+		embed:          embed,
+		embeddingModel: embeddingModel,
+		options:        options.withDefaults(),
+		now:            time.Now,
+	}
+}
+
+// RecalledMemory is a memory returned by Recall
+type RecalledMemory struct {
+	Record VectorRecord
+	// Strength is the decayed strength of the memory (see MemoryStore)
+	Strength float64
+	// Score is the cosine similarity with the query × the strength
+	Score float64
+}
+
+// CreatedAt returns the time of the first mention of the memory
+func (memory RecalledMemory) CreatedAt() time.Time {
+	return memoryTime(memory.Record, memoryCreatedAt)
+}
+
+// LastSeen returns the time of the last mention of the memory
+func (memory RecalledMemory) LastSeen() time.Time {
+	return memoryTime(memory.Record, memoryLastSeen)
+}
+
+// Mentions returns the number of times the memory was remembered
+func (memory RecalledMemory) Mentions() int {
+	return memoryMentionCount(memory.Record)
+}
+
+// Len returns the number of memories
+func (memories *MemoryStore) Len() int {
+	memories.mu.Lock()
+	defer memories.mu.Unlock()
+	return len(memories.store.Records)
+}
+
+// Remember embeds and saves a fact. If a memory is similar enough (see MemoryOptions.DuplicateThreshold),
+// it is reinforced instead and Remember returns false.
+func (memories *MemoryStore) Remember(ctx context.Context, text string, metadata RecordMetadata) (VectorRecord, bool, error) {
+	embedding, err := memories.embed(ctx, text)
+	if err != nil {
+		return VectorRecord{}, false, err
+	}
+
+	memories.mu.Lock()
+	defer memories.mu.Unlock()
+	now := memories.now().UTC()
+
+	duplicates, err := memories.store.SearchTopNSimilarities(VectorRecord{Embedding: embedding}, memories.options.DuplicateThreshold, 1)
+	if err != nil {
+		return VectorRecord{}, false, err
+	}
+	if len(duplicates) > 0 {
+		// [MEMORY] reinforce the existing memory
+		record := duplicates[0]
+		record.CosineSimilarity = 0
+		record.Metadata.Extra = maps.Clone(record.Metadata.Extra)
+		record.Metadata.setMetadata(memoryMentions, strconv.Itoa(memoryMentionCount(record)+1))
+		record.Metadata.setMetadata(memoryLastSeen, now.Format(time.RFC3339))
+		record.Metadata.Tags = mergeValues(record.Metadata.Tags, metadata.Tags)
+		memories.store.Records[record.Id] = record
+		return record, false, nil
+	}
+
+	record := VectorRecord{
+		Prompt:         text,
+		Embedding:      embedding,
+		ContentHash:    ContentHash(text),
+		EmbeddingModel: memories.embeddingModel,
+		Metadata:       metadata,
+	}
+	record.Metadata.Extra = maps.Clone(metadata.Extra)
+	record.Metadata.setMetadata(memoryCreatedAt, now.Format(time.RFC3339))
+	record.Metadata.setMetadata(memoryLastSeen, now.Format(time.RFC3339))
+	record.Metadata.setMetadata(memoryMentions, "1")
+
+	record, err = memories.store.Save(record)
+	if err != nil {
+		return record, false, err
+	}
+	memories.forget(now)
+	return record, true, nil
+}
+
+// Recall returns the max memories (selected by the filter, nil for all) with the best score for the query:
+// the memories less similar than limit or weaker than MinStrength are ignored.
+func (memories *MemoryStore) Recall(ctx context.Context, query string, limit float64, max int, filter *MetadataFilter) ([]RecalledMemory, error) {
+	embedding, err := memories.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	memories.mu.Lock()
+	defer memories.mu.Unlock()
+	now := memories.now().UTC()
+
+	records, err := memories.store.SearchTopNSimilaritiesMatching(VectorRecord{Embedding: embedding}, limit, -1, filter)
+	if err != nil {
+		return nil, err
+	}
+	recalled := []RecalledMemory{}
+	for _, record := range records {
+		strength := memories.strength(record, now)
+		if strength < memories.options.MinStrength {
+			continue
+		}
+		recalled = append(recalled, RecalledMemory{Record: record, Strength: strength, Score: record.CosineSimilarity * strength})
+	}
+	slices.SortFunc(recalled, func(a, b RecalledMemory) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if max >= 0 && len(recalled) > max {
+		recalled = recalled[:max]
+	}
+	return recalled, nil
+}
+
+// strength returns the decayed strength of a memory (see MemoryStore)
+func (memories *MemoryStore) strength(record VectorRecord, now time.Time) float64 {
+	age := now.Sub(memoryTime(record, memoryLastSeen))
+	if age < 0 {
+		age = 0
+	}
+	decay := math.Pow(0.5, float64(age)/float64(memories.options.HalfLife))
+	return decay * (1 + math.Log(float64(memoryMentionCount(record))))
+}
+
+// forget removes the memories weaker than MinStrength, then the weakest ones beyond MaxMemories
+func (memories *MemoryStore) forget(now time.Time) int {
+	type weightedId struct {
+		id       string
+		strength float64
+	}
+	remaining := []weightedId{}
+	forgotten := 0
+	for id, record := range memories.store.Records {
+		strength := memories.strength(record, now)
+		if strength < memories.options.MinStrength {
+			memories.store.Delete(id)
+			forgotten++
+			continue
+		}
+		remaining = append(remaining, weightedId{id: id, strength: strength})
+	}
+	if len(remaining) > memories.options.MaxMemories {
+		slices.SortFunc(remaining, func(a, b weightedId) int {
+			return cmp.Compare(a.strength, b.strength)
+		})
+		for _, weakest := range remaining[:len(remaining)-memories.options.MaxMemories] {
+			memories.store.Delete(weakest.id)
+			forgotten++
+		}
+	}
+	return forgotten
+}
+
+// Load reads the memories of a file (JSON or binary, see LoadFromFile). A missing file is not an error.
+// The memories embedded with another model are embedded again, the faded ones are forgotten.
+func (memories *MemoryStore) Load(ctx context.Context, filename string) error {
+	loaded := MemoryVectorStore{Records: make(map[string]VectorRecord)}
+	if err := loaded.LoadFromFile(filename); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for id, record := range loaded.Records {
+		if record.EmbeddingModel == memories.embeddingModel {
+			continue
+		}
+		embedding, err := memories.embed(ctx, record.Prompt)
+		if err != nil {
+			return err
+		}
+		record.Embedding = embedding
+		record.EmbeddingModel = memories.embeddingModel
+		loaded.Records[id] = record
+	}
+
+	memories.mu.Lock()
+	defer memories.mu.Unlock()
+	memories.store = MemoryVectorStore{Records: make(map[string]VectorRecord)}
+	for _, record := range loaded.Records {
+		if _, err := memories.store.Save(record); err != nil {
+			return err
+		}
+	}
+	memories.forget(memories.now().UTC())
+	return nil
+}
+
+// Save writes the memories to a JSON file
+func (memories *MemoryStore) Save(filename string) error {
+	memories.mu.Lock()
+	defer memories.mu.Unlock()
+	return memories.store.SaveJSONToFile(filename)
+}
+
+// memoryTime returns a timestamp of the extra metadata (zero if missing)
+func memoryTime(record VectorRecord, key string) time.Time {
+	value, _ := time.Parse(time.RFC3339, record.Metadata.Extra[key])
+	return value
+}
+
+// memoryMentionCount returns the number of mentions of the memory (at least 1)
+func memoryMentionCount(record VectorRecord) int {
+	mentions, err := strconv.Atoi(record.Metadata.Extra[memoryMentions])
+	if err != nil || mentions < 1 {
+		return 1
+	}
+	return mentions
+}
+
+// mergeValues appends the values missing from the list
+func mergeValues(values []string, others []string) []string {
+	for _, other := range others {
+		if !slices.Contains(values, other) {
+			values = append(values, other)
+		}
+	}
+	return values
+}
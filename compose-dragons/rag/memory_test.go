@@ -0,0 +1,243 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	guardMemory        = "The dwarf Grimbold guards the north gate of the dungeon at night"
+	guardNearDuplicate = "The dwarf Grimbold guards the north gate of the dungeon every night"
+	merchantMemory     = "The merchant sells healing potions for ten gold coins"
+	dragonMemory       = "A red dragon sleeps on a pile of treasure in the deepest cave"
+)
+
+// fakeClock is the clock of the memory stores of the tests, moved forward between the calls
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+// newTestMemoryStore returns a memory store with the hashing embedder and a fake clock
+func newTestMemoryStore(options MemoryOptions) (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	memories := NewMemoryStore(HashingEmbedFunc(256), "hashing-256", options)
+	memories.now = clock.Now
+	return memories, clock
+}
+
+// recallAll returns the memories similar to the query by content
+func recallAll(t *testing.T, memories *MemoryStore, query string) map[string]RecalledMemory {
+	t.Helper()
+	recalled, err := memories.Recall(context.Background(), query, 0, -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPrompt := map[string]RecalledMemory{}
+	for _, memory := range recalled {
+		byPrompt[memory.Record.Prompt] = memory
+	}
+	return byPrompt
+}
+
+func TestRememberReinforcesTheDuplicates(t *testing.T) {
+	memories, clock := newTestMemoryStore(MemoryOptions{})
+	ctx := context.Background()
+
+	first, added, err := memories.Remember(ctx, guardMemory, RecordMetadata{Tags: []string{"guard"}})
+	if err != nil || !added {
+		t.Fatalf("first memory: added %t (%v)", added, err)
+	}
+	createdAt := clock.Now()
+
+	// The similarity of the near duplicate is above the 0.92 default threshold
+	a, _ := HashingEmbedFunc(256)(ctx, guardMemory)
+	b, _ := HashingEmbedFunc(256)(ctx, guardNearDuplicate)
+	if similarity := CosineSimilarity(a, b); similarity < 0.92 || similarity >= 1 {
+		t.Fatalf("similarity of the near duplicate %.3f", similarity)
+	}
+
+	clock.Advance(time.Hour)
+	reinforced, added, err := memories.Remember(ctx, guardNearDuplicate, RecordMetadata{Tags: []string{"gate"}})
+	if err != nil || added {
+		t.Fatalf("near duplicate: added %t (%v)", added, err)
+	}
+	if reinforced.Id != first.Id || reinforced.Prompt != guardMemory || memories.Len() != 1 {
+		t.Fatalf("near duplicate not merged: %+v, %d memories", reinforced, memories.Len())
+	}
+
+	if _, added, err := memories.Remember(ctx, merchantMemory, RecordMetadata{}); err != nil || !added {
+		t.Fatalf("other memory: added %t (%v)", added, err)
+	}
+	if memories.Len() != 2 {
+		t.Fatalf("%d memories, want 2", memories.Len())
+	}
+
+	guard := recallAll(t, memories, guardMemory)[guardMemory]
+	if guard.Mentions() != 2 || !guard.CreatedAt().Equal(createdAt) || !guard.LastSeen().Equal(clock.Now()) {
+		t.Fatalf("mentions %d, created at %s, last seen %s", guard.Mentions(), guard.CreatedAt(), guard.LastSeen())
+	}
+	if tags := guard.Record.Metadata.Tags; len(tags) != 2 || tags[0] != "guard" || tags[1] != "gate" {
+		t.Fatalf("tags %v, want the tags of both mentions", tags)
+	}
+}
+
+func TestMemoryStrength(t *testing.T) {
+	tests := []struct {
+		name     string
+		mentions int
+		age      time.Duration
+		want     float64
+	}{
+		{"new", 1, 0, 1},
+		{"one half-life", 1, 10 * time.Hour, 0.5},
+		{"two half-lives", 1, 20 * time.Hour, 0.25},
+		{"reinforced", 3, 0, 1 + math.Log(3)},
+		{"reinforced one half-life ago", 3, 10 * time.Hour, (1 + math.Log(3)) / 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memories, clock := newTestMemoryStore(MemoryOptions{HalfLife: 10 * time.Hour})
+			for range test.mentions {
+				if _, _, err := memories.Remember(context.Background(), guardMemory, RecordMetadata{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			clock.Advance(test.age)
+			guard := recallAll(t, memories, guardMemory)[guardMemory]
+			if math.Abs(guard.Strength-test.want) > 1e-9 {
+				t.Fatalf("strength %.4f, want %.4f", guard.Strength, test.want)
+			}
+			if math.Abs(guard.Score-guard.Record.CosineSimilarity*test.want) > 1e-9 {
+				t.Fatalf("score %.4f, want the similarity × the strength", guard.Score)
+			}
+		})
+	}
+}
+
+func TestForget(t *testing.T) {
+	t.Run("faded memories", func(t *testing.T) {
+		memories, clock := newTestMemoryStore(MemoryOptions{HalfLife: time.Hour, MinStrength: 0.1})
+		ctx := context.Background()
+		if _, _, err := memories.Remember(ctx, guardMemory, RecordMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+		// 0.5^4 = 0.0625: the faded memory is not recalled, then forgotten with the next memory
+		clock.Advance(4 * time.Hour)
+		if recalled := recallAll(t, memories, guardMemory); len(recalled) != 0 {
+			t.Fatalf("faded memory recalled: %v", recalled)
+		}
+		if memories.Len() != 1 {
+			t.Fatalf("%d memories before the next memory, want 1", memories.Len())
+		}
+		if _, _, err := memories.Remember(ctx, merchantMemory, RecordMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+		if memories.Len() != 1 || len(recallAll(t, memories, merchantMemory)) != 1 {
+			t.Fatalf("%d memories, want only the merchant", memories.Len())
+		}
+	})
+
+	t.Run("max memories", func(t *testing.T) {
+		memories, clock := newTestMemoryStore(MemoryOptions{HalfLife: time.Hour, MaxMemories: 2})
+		ctx := context.Background()
+		for _, text := range []string{guardMemory, merchantMemory} {
+			if _, _, err := memories.Remember(ctx, text, RecordMetadata{}); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(30 * time.Minute)
+		}
+		// The guard is reinforced: the merchant is the weakest memory
+		if _, _, err := memories.Remember(ctx, guardNearDuplicate, RecordMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(30 * time.Minute)
+		if _, _, err := memories.Remember(ctx, dragonMemory, RecordMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+		if memories.Len() != 2 {
+			t.Fatalf("%d memories, want 2", memories.Len())
+		}
+		for text, want := range map[string]bool{guardMemory: true, merchantMemory: false, dragonMemory: true} {
+			if _, ok := recallAll(t, memories, text)[text]; ok != want {
+				t.Fatalf("%q remembered %t, want %t", text, ok, want)
+			}
+		}
+	})
+}
+
+func TestMemoryStoreLoadAndSave(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "memories.json")
+	memories, clock := newTestMemoryStore(MemoryOptions{HalfLife: time.Hour})
+	for _, text := range []string{guardMemory, guardMemory, merchantMemory} {
+		if _, _, err := memories.Remember(ctx, text, RecordMetadata{Owner: "guard"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := memories.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		loaded, _ := newTestMemoryStore(MemoryOptions{})
+		if err := loaded.Load(ctx, filepath.Join(t.TempDir(), "missing.json")); err != nil || loaded.Len() != 0 {
+			t.Fatalf("%d memories (%v)", loaded.Len(), err)
+		}
+	})
+
+	t.Run("same model", func(t *testing.T) {
+		embedded := 0
+		loaded := NewMemoryStore(func(ctx context.Context, text string) ([]float32, error) {
+			embedded++
+			return HashingEmbedFunc(256)(ctx, text)
+		}, "hashing-256", MemoryOptions{HalfLife: time.Hour})
+		loaded.now = clock.Now
+		if err := loaded.Load(ctx, filename); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Len() != 2 || embedded != 0 {
+			t.Fatalf("%d memories, %d embedded, want 2 and 0", loaded.Len(), embedded)
+		}
+		guard := recallAll(t, loaded, guardMemory)[guardMemory]
+		if guard.Mentions() != 2 || guard.Record.Metadata.Owner != "guard" || !guard.LastSeen().Equal(clock.Now()) {
+			t.Fatalf("loaded memory %+v", guard.Record)
+		}
+	})
+
+	t.Run("other model", func(t *testing.T) {
+		loaded := NewMemoryStore(HashingEmbedFunc(64), "hashing-64", MemoryOptions{HalfLife: time.Hour})
+		loaded.now = clock.Now
+		if err := loaded.Load(ctx, filename); err != nil {
+			t.Fatal(err)
+		}
+		recalled := recallAll(t, loaded, merchantMemory)
+		if len(recalled) != 2 {
+			t.Fatalf("%d memories recalled, want 2", len(recalled))
+		}
+		for _, memory := range recalled {
+			if memory.Record.EmbeddingModel != "hashing-64" || len(memory.Record.Embedding) != 64 {
+				t.Fatalf("memory not embedded again: %s, %d dimensions", memory.Record.EmbeddingModel, len(memory.Record.Embedding))
+			}
+		}
+	})
+
+	t.Run("faded memories", func(t *testing.T) {
+		later := &fakeClock{now: clock.Now().Add(10 * time.Hour)}
+		loaded := NewMemoryStore(HashingEmbedFunc(256), "hashing-256", MemoryOptions{HalfLife: time.Hour})
+		loaded.now = later.Now
+		if err := loaded.Load(ctx, filename); err != nil || loaded.Len() != 0 {
+			t.Fatalf("%d memories (%v), want the faded memories forgotten", loaded.Len(), err)
+		}
+	})
+}
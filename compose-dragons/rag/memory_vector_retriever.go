@@ -2,6 +2,7 @@ package rag
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
@@ -11,84 +12,202 @@ import (
 type MemoryVectorRetrieverOptions struct {
 	Limit      float64 // Minimum similarity threshold
 	MaxResults int     // Maximum number of results to return
+
+	// Hybrid merges the vector results with the BM25 keyword results (see ReciprocalRankFusion),
+	// so the exact terms (NPC names, passwords...) are found even if the embeddings miss them
+	Hybrid bool
+	// Candidates is the number of results of each search before the fusion and the rerank (default 4 * MaxResults)
+	Candidates int
+	// RRFK is the k constant of ReciprocalRankFusion (default 60)
+	RRFK int
+	// Reranker sorts the candidates before keeping the MaxResults best ones (optional, see LLMReranker).
+	// It is not serialized: genkit validates the options as JSON and a RerankerFunc is not a JSON value.
+	Reranker Reranker `json:"-"`
+
+	// Filter selects the records from their metadata (optional, e.g. only the "Secrets" sections, no spoilers)
+	Filter *MetadataFilter
 }
 
-// DefineMemoryVectorRetriever creates a memory vector retriever using the MemoryVectorStore
+// DefineMemoryVectorRetriever creates a memory vector retriever using the MemoryVectorStore.
+// The retriever is registered as "memoryVectorStoreRetriever", or "memoryVectorStoreRetriever-<n>"
+// if the name is already used in the genkit instance (see DefineNamedMemoryVectorRetriever).
+//
+// Deprecated: use DefineNamedMemoryVectorRetriever (or AgentFactory.DefineRetriever) to choose the name.
 func DefineMemoryVectorRetriever(g *genkit.Genkit, vectorStore *MemoryVectorStore, embedder ai.Embedder) ai.Retriever {
-	return genkit.DefineRetriever(
-		g, "memoryVectorStoreRetriever", nil,
-		func(ctx context.Context, req *ai.RetrieverRequest) (*ai.RetrieverResponse, error) {
-			// Handle memory vector retriever options
-			opts, ok := req.Options.(MemoryVectorRetrieverOptions)
-			if !ok {
-				// Set default values if no options provided
-				opts = MemoryVectorRetrieverOptions{
-					Limit:      0.5, // Default similarity threshold
-					MaxResults: 5,   // Default max results
-				}
-			}
+	name := "memoryVectorStoreRetriever"
+	for n := 2; genkit.LookupRetriever(g, name) != nil; n++ {
+		name = fmt.Sprintf("memoryVectorStoreRetriever-%d", n)
+	}
+	retriever, _ := DefineNamedMemoryVectorRetriever(g, name, vectorStore, embedder)
+	return retriever
+}
 
-			// Set default values for zero values
-			if opts.Limit == 0 {
-				opts.Limit = 0.5
-			}
-			if opts.MaxResults == 0 {
-				opts.MaxResults = 5
-			}
+// DefineNamedMemoryVectorRetriever creates a memory vector retriever registered under the given name.
+// It returns an error if a retriever with the same name is already registered in the genkit instance.
+// The hybrid searches need the BM25 index of the store: build it before with UseKeywordIndex
+// (only the stores searched with Hybrid pay for it), so the concurrent retrievals never modify the store.
+func DefineNamedMemoryVectorRetriever(g *genkit.Genkit, name string, vectorStore *MemoryVectorStore, embedder ai.Embedder) (ai.Retriever, error) {
+	if genkit.LookupRetriever(g, name) != nil {
+		return nil, fmt.Errorf("retriever %q is already registered", name)
+	}
+	return genkit.DefineRetriever(g, name, nil, memoryVectorRetrieverFunc(g, vectorStore, embedder)), nil
+}
 
-			// Extract query text from the document
-			var queryText string
-			if req.Query != nil && len(req.Query.Content) > 0 {
-				queryText = req.Query.Content[0].Text
-			} else {
-				return &ai.RetrieverResponse{Documents: []*ai.Document{}}, nil
+// memoryVectorRetrieverFunc is the retrieval logic shared by the memory vector retrievers
+func memoryVectorRetrieverFunc(g *genkit.Genkit, vectorStore *MemoryVectorStore, embedder ai.Embedder) ai.RetrieverFunc {
+	return func(ctx context.Context, req *ai.RetrieverRequest) (*ai.RetrieverResponse, error) {
+		// Handle memory vector retriever options
+		opts, ok := req.Options.(MemoryVectorRetrieverOptions)
+		if !ok {
+			// Set default values if no options provided
+			opts = MemoryVectorRetrieverOptions{
+				Limit:      0.5, // Default similarity threshold
+				MaxResults: 5,   // Default max results
 			}
+		}
+
+		// Set default values for zero values
+		if opts.Limit == 0 {
+			opts.Limit = 0.5
+		}
+		if opts.MaxResults == 0 {
+			opts.MaxResults = 5
+		}
+
+		// Extract query text from the document
+		var queryText string
+		if req.Query != nil && len(req.Query.Content) > 0 {
+			queryText = req.Query.Content[0].Text
+		} else {
+			return &ai.RetrieverResponse{Documents: []*ai.Document{}}, nil
+		}
+
+		// Generate embedding for the query
+		embeddingResp, err := genkit.Embed(ctx, g,
+			ai.WithEmbedder(embedder),
+			ai.WithTextDocs(queryText),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Create a VectorRecord from the query embedding
+		queryVector := VectorRecord{
+			Prompt:    queryText,
+			Embedding: embeddingResp.Embeddings[0].Embedding,
+		}
 
-			// Generate embedding for the query
-			embeddingResp, err := genkit.Embed(ctx, g,
-				ai.WithEmbedder(embedder),
-				ai.WithTextDocs(queryText),
-			)
+		if opts.Hybrid || opts.Reranker != nil {
+			documents, err := hybridRetrieve(ctx, vectorStore, queryVector, opts)
 			if err != nil {
 				return nil, err
 			}
+			return &ai.RetrieverResponse{Documents: documents}, nil
+		}
 
-			// Create a VectorRecord from the query embedding
-			queryVector := VectorRecord{
-				Prompt:    queryText,
-				Embedding: embeddingResp.Embeddings[0].Embedding,
-			}
+		// Search for similar vectors using the MemoryVectorStore
+		var similarRecords []VectorRecord
+		var searchErr error
 
-			// Search for similar vectors using the MemoryVectorStore
-			var similarRecords []VectorRecord
-			var searchErr error
+		if opts.MaxResults > 0 {
+			// Top N results selected by the filter
+			similarRecords, searchErr = vectorStore.SearchTopNSimilaritiesMatching(queryVector, opts.Limit, opts.MaxResults, opts.Filter)
+		} else {
+			// All results above threshold selected by the filter
+			similarRecords, searchErr = vectorStore.SearchTopNSimilaritiesMatching(queryVector, opts.Limit, -1, opts.Filter)
+		}
 
-			if opts.MaxResults > 0 {
-				// Use SearchTopNSimilarities for limited results
-				similarRecords, searchErr = vectorStore.SearchTopNSimilarities(queryVector, opts.Limit, opts.MaxResults)
-			} else {
-				// Use SearchSimilarities for all results above threshold
-				similarRecords, searchErr = vectorStore.SearchSimilarities(queryVector, opts.Limit)
-			}
+		if searchErr != nil {
+			return nil, searchErr
+		}
 
-			if searchErr != nil {
-				return nil, searchErr
-			}
+		// Convert VectorRecord results to ai.Document
+		documents := make([]*ai.Document, len(similarRecords))
+		for i, record := range similarRecords {
+			// Create document with the prompt content and similarity score
+			doc := ai.DocumentFromText(record.Prompt, documentMetadata(record))
+			documents[i] = doc
+		}
+
+		return &ai.RetrieverResponse{
+			Documents: documents,
+		}, nil
+	}
+}
 
-			// Convert VectorRecord results to ai.Document
-			documents := make([]*ai.Document, len(similarRecords))
-			for i, record := range similarRecords {
-				// Create document with the prompt content and similarity score
-				doc := ai.DocumentFromText(record.Prompt, map[string]any{
-					"id":               record.Id,
-					"cosine_similarity": record.CosineSimilarity,
-				})
-				documents[i] = doc
+// hybridRetrieve runs the vector search (and the keyword search if opts.Hybrid),
+// fuses the rankings and reranks the candidates if opts.Reranker is set.
+//
+//	vector search ──┐
+//	                ├─ reciprocal rank fusion ─ [rerank] ─ MaxResults documents
+//	BM25 search ────┘
+//
+// The documents have the metadata of the records (see documentMetadata), the "rrf_score",
+// and the "bm25_score" / "rerank_score" when available.
+func hybridRetrieve(ctx context.Context, vectorStore *MemoryVectorStore, queryVector VectorRecord, opts MemoryVectorRetrieverOptions) ([]*ai.Document, error) {
+	candidates := opts.Candidates
+	if candidates <= 0 {
+		candidates = 4 * opts.MaxResults
+	}
+	if opts.MaxResults < 0 {
+		// All the results above the threshold
+		candidates = len(vectorStore.Records)
+	}
+
+	vectorRecords, err := vectorStore.SearchTopNSimilaritiesMatching(queryVector, opts.Limit, candidates, opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	records := map[string]VectorRecord{}
+	vectorRanking := make([]string, 0, len(vectorRecords))
+	for _, record := range vectorRecords {
+		records[record.Id] = record
+		vectorRanking = append(vectorRanking, record.Id)
+	}
+
+	keywordRanking := []string{}
+	bm25Scores := map[string]float64{}
+	if opts.Hybrid {
+		// The keyword index is built by the caller (see DefineNamedMemoryVectorRetriever)
+		keywordRecords, results, err := vectorStore.SearchKeywords(queryVector.Prompt, candidates, opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range keywordRecords {
+			if _, exists := records[record.Id]; !exists {
+				// The keyword results can be below the similarity threshold
+				record.CosineSimilarity = CosineSimilarity(queryVector.Embedding, record.Embedding)
+				records[record.Id] = record
 			}
+			keywordRanking = append(keywordRanking, record.Id)
+			bm25Scores[record.Id] = results[i].Score
+		}
+	}
+
+	fused := ReciprocalRankFusion(opts.RRFK, vectorRanking, keywordRanking)
+	if opts.Reranker == nil && opts.MaxResults > 0 && len(fused) > opts.MaxResults {
+		fused = fused[:opts.MaxResults]
+	}
 
-			return &ai.RetrieverResponse{
-				Documents: documents,
-			}, nil
-		},
-	)
-}
\ No newline at end of file
+	documents := make([]*ai.Document, 0, len(fused))
+	for _, result := range fused {
+		record := records[result.Id]
+		metadata := documentMetadata(record)
+		metadata["rrf_score"] = result.Score
+		if score, exists := bm25Scores[record.Id]; exists {
+			metadata["bm25_score"] = score
+		}
+		documents = append(documents, ai.DocumentFromText(record.Prompt, metadata))
+	}
+
+	if opts.Reranker != nil {
+		documents, err = rerankDocuments(ctx, opts.Reranker, queryVector.Prompt, documents)
+		if err != nil {
+			return nil, err
+		}
+		if opts.MaxResults > 0 && len(documents) > opts.MaxResults {
+			documents = documents[:opts.MaxResults]
+		}
+	}
+	return documents, nil
+}
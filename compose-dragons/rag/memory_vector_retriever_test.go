@@ -0,0 +1,63 @@
+package rag
+
+import (
+	"context"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func TestDefineMemoryVectorRetrieverTwice(t *testing.T) {
+	g := genkit.Init(context.Background())
+	embedder := DefineHashingEmbedder(g, "hashing-64", 64)
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+
+	first := DefineMemoryVectorRetriever(g, store, embedder)
+	second := DefineMemoryVectorRetriever(g, store, embedder)
+	if first.Name() == second.Name() {
+		t.Fatalf("the retrievers have the same name %q", first.Name())
+	}
+
+	if _, err := DefineNamedMemoryVectorRetriever(g, "guard", store, embedder); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DefineNamedMemoryVectorRetriever(g, "guard", store, embedder); err == nil {
+		t.Fatal("expected an error for the second retriever named guard")
+	}
+}
+
+func TestHybridRetrievalNeedsTheKeywordIndex(t *testing.T) {
+	g := genkit.Init(context.Background())
+	embedder := DefineHashingEmbedder(g, "hashing-64", 64)
+	embed := HashingEmbedFunc(64)
+	store := &MemoryVectorStore{Records: map[string]VectorRecord{}}
+	embedding, _ := embed(context.Background(), "the password is moonlight")
+	store.Save(VectorRecord{Prompt: "the password is moonlight", Embedding: embedding})
+
+	retriever, err := DefineNamedMemoryVectorRetriever(g, "guard", store, embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Keywords != nil {
+		t.Fatal("the keyword index is built without hybrid search")
+	}
+	retrieve := func(options MemoryVectorRetrieverOptions) error {
+		_, err := retriever.Retrieve(context.Background(), &ai.RetrieverRequest{
+			Query:   ai.DocumentFromText("password", nil),
+			Options: options,
+		})
+		return err
+	}
+	if err := retrieve(MemoryVectorRetrieverOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := retrieve(MemoryVectorRetrieverOptions{Hybrid: true}); err == nil {
+		t.Fatal("hybrid retrieval without keyword index")
+	}
+
+	store.UseKeywordIndex(NewBM25Index(BM25Options{}))
+	if err := retrieve(MemoryVectorRetrieverOptions{Hybrid: true}); err != nil {
+		t.Fatal(err)
+	}
+}
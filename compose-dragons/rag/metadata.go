@@ -0,0 +1,155 @@
+package rag
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RecordMetadata is the structured metadata of a chunk, kept in its VectorRecord
+// so the retriever can filter the records (see MetadataFilter)
+type RecordMetadata struct {
+	// Source is the file of the chunk (e.g. "guard_background_and_personality.md")
+	Source string `json:"source,omitempty" yaml:"source"`
+	// Headings is the heading path of the chunk (e.g. ["Guard", "Secrets"])
+	Headings []string `json:"headings,omitempty" yaml:"headings"`
+	Tags     []string `json:"tags,omitempty" yaml:"tags"`
+	// Owner is the NPC owning the knowledge (empty for the shared knowledge)
+	Owner string `json:"owner,omitempty" yaml:"owner"`
+	// Spoiler is the spoiler level of the chunk (0 for no spoiler)
+	Spoiler int `json:"spoiler,omitempty" yaml:"spoiler"`
+	// Extra holds the other metadata of the markdown annotations
+	Extra map[string]string `json:"extra,omitempty" yaml:"extra"`
+}
+
+// Chunk is a text to embed with its metadata
+type Chunk struct {
+	Content  string
+	Metadata RecordMetadata
+}
+
+// MetadataFilter selects the records of a search from their metadata.
+// The empty fields do not filter.
+//
+//	# only the sections under "## Secrets", without the spoilers
+//	similarity_filter:
+//	  headings: [Secrets]
+//	  exclude_spoilers: true
+type MetadataFilter struct {
+	// Sources keeps the records of these files
+	Sources []string `yaml:"sources"`
+	// Headings keeps the records under one of these headings (at any level of the heading path)
+	Headings []string `yaml:"headings"`
+	// ExcludeHeadings removes the records under one of these headings
+	ExcludeHeadings []string `yaml:"exclude_headings"`
+	// Tags keeps the records with at least one of these tags
+	Tags []string `yaml:"tags"`
+	// ExcludeTags removes the records with one of these tags
+	ExcludeTags []string `yaml:"exclude_tags"`
+	// Owners keeps the records of these NPCs and the shared records (no owner)
+	Owners []string `yaml:"owners"`
+	// ExcludeSpoilers removes the records with a spoiler level above MaxSpoiler
+	ExcludeSpoilers bool `yaml:"exclude_spoilers"`
+	MaxSpoiler      int  `yaml:"max_spoiler"`
+}
+
+// Match returns true if the record is selected by the filter (a nil filter selects all the records)
+func (filter *MetadataFilter) Match(record VectorRecord) bool {
+	if filter == nil {
+		return true
+	}
+	metadata := record.Metadata
+	if len(filter.Sources) > 0 && !slices.Contains(filter.Sources, metadata.Source) {
+		return false
+	}
+	if len(filter.Headings) > 0 && !containsAny(metadata.Headings, filter.Headings) {
+		return false
+	}
+	if containsAny(metadata.Headings, filter.ExcludeHeadings) {
+		return false
+	}
+	if len(filter.Tags) > 0 && !containsAny(metadata.Tags, filter.Tags) {
+		return false
+	}
+	if containsAny(metadata.Tags, filter.ExcludeTags) {
+		return false
+	}
+	if len(filter.Owners) > 0 && metadata.Owner != "" && !slices.Contains(filter.Owners, metadata.Owner) {
+		return false
+	}
+	if filter.ExcludeSpoilers && metadata.Spoiler > filter.MaxSpoiler {
+		return false
+	}
+	return true
+}
+
+// containsAny compares the values case-insensitively
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range values {
+		for _, other := range wanted {
+			if strings.EqualFold(strings.TrimSpace(value), strings.TrimSpace(other)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// documentMetadata returns the metadata of the retrieved documents of a record
+func documentMetadata(record VectorRecord) map[string]any {
+	metadata := map[string]any{
+		"id":                record.Id,
+		"cosine_similarity": record.CosineSimilarity,
+	}
+	if record.Metadata.Source != "" {
+		metadata["source"] = record.Metadata.Source
+	}
+	if len(record.Metadata.Headings) > 0 {
+		metadata["headings"] = record.Metadata.Headings
+	}
+	if len(record.Metadata.Tags) > 0 {
+		metadata["tags"] = record.Metadata.Tags
+	}
+	if record.Metadata.Owner != "" {
+		metadata["owner"] = record.Metadata.Owner
+	}
+	if record.Metadata.Spoiler != 0 {
+		metadata["spoiler"] = record.Metadata.Spoiler
+	}
+	return metadata
+}
+
+var metadataAnnotationRegex = regexp.MustCompile(`^<!--\s*([A-Za-z][\w-]*)\s*:\s*(.*?)\s*-->$`)
+
+// parseMetadataAnnotation parses a markdown annotation line: <!-- key: value -->
+// (tags, owner, spoiler, or any other key stored in Extra)
+func parseMetadataAnnotation(line string) (string, string, bool) {
+	matches := metadataAnnotationRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return "", "", false
+	}
+	return strings.ToLower(matches[1]), strings.TrimSpace(matches[2]), true
+}
+
+// setMetadata sets the value of an annotation (the tags are comma separated)
+func (metadata *RecordMetadata) setMetadata(key, value string) {
+	switch key {
+	case "tags":
+		metadata.Tags = []string{}
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				metadata.Tags = append(metadata.Tags, tag)
+			}
+		}
+	case "owner":
+		metadata.Owner = value
+	case "spoiler":
+		metadata.Spoiler, _ = strconv.Atoi(value)
+	default:
+		if metadata.Extra == nil {
+			metadata.Extra = map[string]string{}
+		}
+		metadata.Extra[key] = value
+	}
+}
@@ -2,7 +2,9 @@ package rag
 
 import (
 	"encoding/json"
+	"io"
 	"os"
+	"slices"
 	"sort"
 	"github.com/google/uuid"
 )
@@ -12,10 +14,20 @@ type VectorRecord struct {
 	Prompt           string    `json:"prompt"`
 	Embedding        []float32 `json:"embedding"`
 	CosineSimilarity float64
+	// ContentHash, SourceHash and EmbeddingModel are used by the incremental rebuilds (see Rebuild)
+	ContentHash    string `json:"content_hash,omitempty"`
+	SourceHash     string `json:"source_hash,omitempty"`
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	// Metadata of the chunk used by the filtered searches (see MetadataFilter)
+	Metadata RecordMetadata `json:"metadata,omitzero"`
 }
 
 type MemoryVectorStore struct {
 	Records map[string]VectorRecord
+	// Index is used by SearchTopNSimilarities if set (see UseIndex and HNSWIndex)
+	Index VectorIndex
+	// Keywords is the BM25 index of the prompts used by SearchKeywords (see UseKeywordIndex)
+	Keywords *BM25Index
 }
 
 func (mvs *MemoryVectorStore) GetAll() ([]VectorRecord, error) {
@@ -30,10 +42,29 @@ func (mvs *MemoryVectorStore) Save(vectorRecord VectorRecord) (VectorRecord, err
 	if vectorRecord.Id == "" {
 		vectorRecord.Id = uuid.New().String()
 	}
+	if mvs.Index != nil {
+		if err := mvs.Index.Add(vectorRecord.Id, vectorRecord.Embedding); err != nil {
+			return vectorRecord, err
+		}
+	}
+	if mvs.Keywords != nil {
+		mvs.Keywords.Add(vectorRecord.Id, vectorRecord.Prompt)
+	}
 	mvs.Records[vectorRecord.Id] = vectorRecord
 	return vectorRecord, nil
 }
 
+// Delete removes a record from the store and its indexes
+func (mvs *MemoryVectorStore) Delete(id string) {
+	delete(mvs.Records, id)
+	if mvs.Index != nil {
+		mvs.Index.Remove(id)
+	}
+	if mvs.Keywords != nil {
+		mvs.Keywords.Remove(id)
+	}
+}
+
 // SearchSimilarities searches for vector records in the MemoryVectorStore that have a cosine distance similarity greater than or equal to the given limit.
 //
 // Parameters:
@@ -61,7 +92,21 @@ func (mvs *MemoryVectorStore) SearchSimilarities(embeddingFromQuestion VectorRec
 // It returns a slice of vector records and an error if any.
 // The limit parameter specifies the minimum similarity score for a record to be considered similar.
 // The max parameter specifies the maximum number of vector records to return.
+// With an Index, only the approximate top N records are compared with the limit.
 func (mvs *MemoryVectorStore) SearchTopNSimilarities(embeddingFromQuestion VectorRecord, limit float64, max int) ([]VectorRecord, error) {
+	if mvs.Index != nil {
+		records := []VectorRecord{}
+		for _, result := range mvs.Index.Search(embeddingFromQuestion.Embedding, max) {
+			record, exists := mvs.Records[result.Id]
+			if !exists || result.CosineSimilarity < limit {
+				continue
+			}
+			record.CosineSimilarity = result.CosineSimilarity
+			records = append(records, record)
+		}
+		return records, nil
+	}
+
 	records, err := mvs.SearchSimilarities(embeddingFromQuestion, limit)
 	if err != nil {
 		return nil, err
@@ -69,6 +114,48 @@ func (mvs *MemoryVectorStore) SearchTopNSimilarities(embeddingFromQuestion Vecto
 	return getTopNVectorRecords(records, max), nil
 }
 
+// SearchTopNSimilaritiesMatching is SearchTopNSimilarities for the records selected by the filter.
+// With an Index, the search is extended until max records match the filter (or all the records are compared).
+// A negative max returns all the matching records.
+func (mvs *MemoryVectorStore) SearchTopNSimilaritiesMatching(embeddingFromQuestion VectorRecord, limit float64, max int, filter *MetadataFilter) ([]VectorRecord, error) {
+	if filter == nil && max >= 0 {
+		return mvs.SearchTopNSimilarities(embeddingFromQuestion, limit, max)
+	}
+	if mvs.Index != nil && max > 0 {
+		for k := max; ; k *= 2 {
+			results := mvs.Index.Search(embeddingFromQuestion.Embedding, k)
+			records := []VectorRecord{}
+			for _, result := range results {
+				record, exists := mvs.Records[result.Id]
+				if !exists || result.CosineSimilarity < limit || !filter.Match(record) {
+					continue
+				}
+				record.CosineSimilarity = result.CosineSimilarity
+				records = append(records, record)
+				if len(records) == max {
+					return records, nil
+				}
+			}
+			// All the records are compared, or the next ones are below the limit
+			if len(results) < k || k >= mvs.Index.Len() || (len(results) > 0 && results[len(results)-1].CosineSimilarity < limit) {
+				return records, nil
+			}
+		}
+	}
+
+	records, err := mvs.SearchSimilarities(embeddingFromQuestion, limit)
+	if err != nil {
+		return nil, err
+	}
+	records = slices.DeleteFunc(records, func(record VectorRecord) bool {
+		return !filter.Match(record)
+	})
+	if max < 0 {
+		return records, nil
+	}
+	return getTopNVectorRecords(records, max), nil
+}
+
 // getTopNVectorRecords returns the top N vector records based on their cosine similarity.
 func getTopNVectorRecords(records []VectorRecord, max int) []VectorRecord {
 	// Sort the records slice in descending order based on CosineDistance
@@ -83,13 +170,16 @@ func getTopNVectorRecords(records []VectorRecord, max int) []VectorRecord {
 	return records[:max]
 }
 
-// SaveToFile persists the vector store to a JSON file
+// SaveToFile persists the vector store to a JSON file (replaced atomically, see writeFileAtomically)
 func (mvs *MemoryVectorStore) SaveJSONToFile(filename string) error {
 	data, err := json.MarshalIndent(mvs.Records, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, data, 0644)
+	return writeFileAtomically(filename, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
 }
 
 // LoadFromFile loads the vector store from a JSON file
@@ -0,0 +1,57 @@
+package rag
+
+import (
+	"math"
+	"slices"
+)
+
+// VectorIndex is an approximate nearest neighbour index of the records of a MemoryVectorStore
+// (see HNSWIndex). Without an index, the store compares the question with every record.
+type VectorIndex interface {
+	// Add indexes the embedding of a record (an existing id is replaced)
+	Add(id string, embedding []float32) error
+	// Remove removes a record from the index
+	Remove(id string)
+	// Search returns the k most similar records, sorted by descending cosine similarity
+	Search(embedding []float32, k int) []SearchResult
+	// Len returns the number of indexed records
+	Len() int
+}
+
+// SearchResult is a record found by a VectorIndex
+type SearchResult struct {
+	Id               string
+	CosineSimilarity float64
+}
+
+// UseIndex indexes all the records of the store and uses the index for SearchTopNSimilarities.
+// The records saved afterwards are indexed too.
+func (mvs *MemoryVectorStore) UseIndex(index VectorIndex) error {
+	// Sorted ids: the same records always give the same graph
+	ids := make([]string, 0, len(mvs.Records))
+	for id := range mvs.Records {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		if err := index.Add(id, mvs.Records[id].Embedding); err != nil {
+			return err
+		}
+	}
+	mvs.Index = index
+	return nil
+}
+
+// normalize returns a copy of the vector with a norm of 1 (the zero vector is kept),
+// so the cosine similarity of two normalized vectors is their dot product
+func normalize(vector []float32) []float32 {
+	norm := math.Sqrt(dotProduct(vector, vector))
+	normalized := make([]float32, len(vector))
+	if norm == 0 {
+		return normalized
+	}
+	for i, value := range vector {
+		normalized[i] = float32(float64(value) / norm)
+	}
+	return normalized
+}
@@ -2,10 +2,6 @@ package tools
 
 import (
 	"context"
-	"fmt"
-	"os"
-
-	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
@@ -13,7 +9,6 @@ import (
 	"github.com/firebase/genkit/go/plugins/mcp"
 )
 
-
 type ListToolsInput struct{}
 
 type ToolInfo struct {
@@ -28,41 +23,29 @@ type ListToolsResult struct {
 }
 
 // [NOTE]: this is a work-in-progress
-func MCPCatalog(ctx context.Context, mcpClient *mcp.GenkitMCPClient) []ai.ToolRef {
+// MCPCatalog creates its own genkit instance, prefer MCPCatalogWithGenkit
+// to reuse the genkit instance of the agents (see agents.AgentFactory)
+func MCPCatalog(ctx context.Context, mcpClient *mcp.GenkitMCPClient) ([]ai.ToolRef, error) {
 
 	g := genkit.Init(ctx, genkit.WithPlugins(&openai.OpenAI{
 		APIKey: "I💙DockerModelRunner",
 	}))
 
-	toolsList, err := mcpClient.GetActiveTools(ctx, g)
-
-	if err != nil {
-		msg.DisplayError("😡 Error getting the tools list:", err)
-		os.Exit(1)
-	}
-	msg.DisplayMCPMessages(fmt.Sprintf("🟢 MCP 🛠️ Retrieved %v active tools from MCP Gateway", len(toolsList)))
-
-	// Keep MCP tools as ai.Tool (don't convert to ToolRef)
-	// This preserves the RunRaw() method needed for execution
-	toolRefs := make([]ai.ToolRef, 0, len(toolsList)+1)
+	return MCPCatalogWithGenkit(ctx, g, mcpClient)
+}
 
-	// Add MCP tools directly (ai.Tool implements ai.ToolRef)
-	for _, tool := range toolsList {
-		toolRefs = append(toolRefs, tool)
-	}
+// MCPCatalogWithGenkit returns the active tools of the MCP client plus the local list_tools tool
+// (see NewMCPCatalog to filter, rename and scope the tools)
+func MCPCatalogWithGenkit(ctx context.Context, g *genkit.Genkit, mcpClient *mcp.GenkitMCPClient) ([]ai.ToolRef, error) {
 
 	// IMPORTANT:
-	// Define list_tools tool (needs access to toolRefs, so we define it after MCP tools are added)
-	listToolsTool := genkit.DefineTool(g, "list_tools", "List all available tools with their descriptions and parameters",
-		func(ctx *ai.ToolContext, input ListToolsInput) (ListToolsResult, error) {
-			return listTools(toolRefs), nil
-		},
-	)
-
-	// Append locally defined tools
-	toolRefs = append(toolRefs, listToolsTool)
-
-	return toolRefs
+	// The list_tools tool is not registered in the genkit instance, so the catalog can be built several times
+	// with the same (shared) genkit instance
+	catalog, err := NewMCPCatalog(ctx, g, mcpClient, CatalogOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Tools(), nil
 }
 
 func listTools(toolRefs []ai.ToolRef) ListToolsResult {
@@ -0,0 +1,241 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/mcp"
+)
+
+// ToolMetadata describes how a tool can be used by the agents
+type ToolMetadata struct {
+	// ReadOnly tools do not change the game state (no confirmation needed, can run concurrently)
+	ReadOnly bool
+	// NeedsConfirmation forces a confirmation, even for a read-only tool
+	NeedsConfirmation bool
+	Tags              []string
+}
+
+// CatalogOptions filters and renames the tools of a catalog.
+// The tool names are transformed first (StripPrefix, Rename, AddPrefix),
+// then the Include, Exclude and Metadata patterns are matched against the new names.
+// The patterns are exact names or path.Match patterns (e.g. "get_*").
+//
+// Example: remove the "c&d_" prefix of the MCP gateway and keep only the tools about the dungeon
+//
+//	options := tools.CatalogOptions{
+//		StripPrefix: "c&d_",
+//		Include:     []string{"get_*", "move_*", "create_player"},
+//		Metadata: map[string]tools.ToolMetadata{
+//			"get_*": {ReadOnly: true},
+//		},
+//	}
+type CatalogOptions struct {
+	// Include keeps only the matching tools (all the tools if empty)
+	Include []string
+	// Exclude removes the matching tools
+	Exclude []string
+
+	// StripPrefix is removed from the tool names (e.g. "c&d_")
+	StripPrefix string
+	// Rename maps a tool name (after StripPrefix) to a new name
+	Rename map[string]string
+	// AddPrefix is added to the tool names (after Rename)
+	AddPrefix string
+
+	// Metadata of the tools, by name or pattern (the first matching pattern in sorted order wins)
+	Metadata map[string]ToolMetadata
+
+	// WithoutListTools does not add the local list_tools tool
+	WithoutListTools bool
+}
+
+// Catalog is a set of tools with their metadata
+type Catalog struct {
+	tools    []ai.ToolRef
+	metadata map[string]ToolMetadata
+}
+
+// NewCatalog applies the options to the tools (e.g. the tools of a local Registry)
+func NewCatalog(toolRefs []ai.ToolRef, options CatalogOptions) (*Catalog, error) {
+	catalog := &Catalog{
+		tools:    []ai.ToolRef{},
+		metadata: map[string]ToolMetadata{},
+	}
+
+	for _, toolRef := range toolRefs {
+		name := options.toolName(toolRef.Name())
+		if !options.keep(name) {
+			continue
+		}
+		if name != toolRef.Name() {
+			tool, ok := toolRef.(ai.Tool)
+			if !ok {
+				return nil, fmt.Errorf("tool %q cannot be renamed", toolRef.Name())
+			}
+			toolRef = renameTool(tool, name)
+		}
+		if err := catalog.Add(options.metadataOf(name), toolRef); err != nil {
+			return nil, err
+		}
+	}
+
+	if !options.WithoutListTools {
+		// list_tools needs access to the final catalog, so the closure reads catalog.tools
+		listToolsTool := ai.NewTool("list_tools", "List all available tools with their descriptions and parameters",
+			func(ctx *ai.ToolContext, input ListToolsInput) (ListToolsResult, error) {
+				return listTools(catalog.tools), nil
+			},
+		)
+		if err := catalog.Add(ToolMetadata{ReadOnly: true}, listToolsTool); err != nil {
+			return nil, err
+		}
+	}
+	return catalog, nil
+}
+
+// NewMCPCatalog builds a catalog with the active tools of the MCP client
+func NewMCPCatalog(ctx context.Context, g *genkit.Genkit, mcpClient *mcp.GenkitMCPClient, options CatalogOptions) (*Catalog, error) {
+	toolsList, err := mcpClient.GetActiveTools(ctx, g)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the tools list: %w", err)
+	}
+	msg.DisplayMCPMessages(fmt.Sprintf("🟢 MCP 🛠️ Retrieved %v active tools from MCP Gateway", len(toolsList)))
+
+	// Keep MCP tools as ai.Tool (don't convert to ToolRef)
+	// This preserves the RunRaw() method needed for execution
+	toolRefs := make([]ai.ToolRef, 0, len(toolsList))
+	for _, tool := range toolsList {
+		toolRefs = append(toolRefs, tool)
+	}
+	return NewCatalog(toolRefs, options)
+}
+
+// Add adds tools with the same metadata (e.g. the tools of a local Registry)
+// and returns an error if a tool name is already in the catalog
+func (catalog *Catalog) Add(metadata ToolMetadata, toolRefs ...ai.ToolRef) error {
+	for _, toolRef := range toolRefs {
+		if _, exists := catalog.Lookup(toolRef.Name()); exists {
+			return fmt.Errorf("tool %q is defined twice", toolRef.Name())
+		}
+		catalog.tools = append(catalog.tools, toolRef)
+		catalog.metadata[toolRef.Name()] = metadata
+	}
+	return nil
+}
+
+// Tools returns all the tools of the catalog
+func (catalog *Catalog) Tools() []ai.ToolRef {
+	return slices.Clone(catalog.tools)
+}
+
+// Scope returns the subset of tools for an agent (names or patterns, see Filter)
+func (catalog *Catalog) Scope(patterns ...string) []ai.ToolRef {
+	return Filter(catalog.tools, patterns...)
+}
+
+// Lookup returns the tool with the given name
+func (catalog *Catalog) Lookup(name string) (ai.ToolRef, bool) {
+	for _, toolRef := range catalog.tools {
+		if toolRef.Name() == name {
+			return toolRef, true
+		}
+	}
+	return nil, false
+}
+
+// Metadata returns the metadata of a tool (zero value if unknown)
+func (catalog *Catalog) Metadata(name string) ToolMetadata {
+	return catalog.metadata[name]
+}
+
+// ReadOnlyTools returns the names of the read-only tools
+// (e.g. for agents.Config.ParallelSafeTools)
+func (catalog *Catalog) ReadOnlyTools() []string {
+	names := []string{}
+	for _, toolRef := range catalog.tools {
+		if catalog.metadata[toolRef.Name()].ReadOnly {
+			names = append(names, toolRef.Name())
+		}
+	}
+	return names
+}
+
+// ToolsWithoutConfirmation returns the names of the read-only tools that do not need a confirmation
+// (e.g. for the allow list of agents.ToolListApprover)
+func (catalog *Catalog) ToolsWithoutConfirmation() []string {
+	names := []string{}
+	for _, toolRef := range catalog.tools {
+		metadata := catalog.metadata[toolRef.Name()]
+		if metadata.ReadOnly && !metadata.NeedsConfirmation {
+			names = append(names, toolRef.Name())
+		}
+	}
+	return names
+}
+
+func (options CatalogOptions) toolName(name string) string {
+	name = strings.TrimPrefix(name, options.StripPrefix)
+	if newName, exists := options.Rename[name]; exists {
+		name = newName
+	}
+	return options.AddPrefix + name
+}
+
+func (options CatalogOptions) keep(name string) bool {
+	if len(options.Include) > 0 && !matchAny(options.Include, name) {
+		return false
+	}
+	return !matchAny(options.Exclude, name)
+}
+
+func (options CatalogOptions) metadataOf(name string) ToolMetadata {
+	// Exact names first, then the patterns in sorted order
+	if metadata, exists := options.Metadata[name]; exists {
+		return metadata
+	}
+	patterns := make([]string, 0, len(options.Metadata))
+	for pattern := range options.Metadata {
+		patterns = append(patterns, pattern)
+	}
+	slices.Sort(patterns)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return options.Metadata[pattern]
+		}
+	}
+	return ToolMetadata{}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// renameTool wraps a tool with a new name, the input schema and the execution are kept
+func renameTool(tool ai.Tool, name string) ai.Tool {
+	description := ""
+	inputSchema := map[string]any{}
+	if def := tool.Definition(); def != nil {
+		description = def.Description
+		if def.InputSchema != nil {
+			inputSchema = def.InputSchema
+		}
+	}
+	return ai.NewToolWithInputSchema(name, description, inputSchema,
+		func(ctx *ai.ToolContext, input any) (any, error) {
+			return tool.RunRaw(ctx, input)
+		},
+	)
+}
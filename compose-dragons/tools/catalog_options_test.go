@@ -0,0 +1,206 @@
+package tools
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+type roomInput struct {
+	Room string `json:"room"`
+}
+
+// testTools returns tools answering "<name> <room>"
+func testTools(names ...string) []ai.ToolRef {
+	toolRefs := []ai.ToolRef{}
+	for _, name := range names {
+		toolRefs = append(toolRefs, ai.NewTool(name, "The "+name+" tool",
+			func(ctx *ai.ToolContext, input roomInput) (string, error) {
+				return name + " " + input.Room, nil
+			},
+		))
+	}
+	return toolRefs
+}
+
+func toolNames(toolRefs []ai.ToolRef) []string {
+	names := []string{}
+	for _, toolRef := range toolRefs {
+		names = append(names, toolRef.Name())
+	}
+	return names
+}
+
+func TestNewCatalog(t *testing.T) {
+	gatewayTools := testTools("c&d_get_map", "c&d_get_room", "c&d_move", "c&d_roll_dice", "other")
+	tests := []struct {
+		name      string
+		options   CatalogOptions
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name:      "all the tools and list_tools",
+			options:   CatalogOptions{},
+			wantNames: []string{"c&d_get_map", "c&d_get_room", "c&d_move", "c&d_roll_dice", "other", "list_tools"},
+		},
+		{
+			name:      "strip prefix",
+			options:   CatalogOptions{StripPrefix: "c&d_", WithoutListTools: true},
+			wantNames: []string{"get_map", "get_room", "move", "roll_dice", "other"},
+		},
+		{
+			// The names are renamed after StripPrefix, then prefixed
+			name: "strip prefix, rename and add prefix",
+			options: CatalogOptions{
+				StripPrefix:      "c&d_",
+				Rename:           map[string]string{"roll_dice": "dice", "c&d_move": "walk"},
+				AddPrefix:        "dm_",
+				WithoutListTools: true,
+			},
+			wantNames: []string{"dm_get_map", "dm_get_room", "dm_move", "dm_dice", "dm_other"},
+		},
+		{
+			// Include and Exclude match the new names
+			name: "include and exclude after the renaming",
+			options: CatalogOptions{
+				StripPrefix:      "c&d_",
+				Rename:           map[string]string{"roll_dice": "dice"},
+				AddPrefix:        "dm_",
+				Include:          []string{"dm_get_*", "dm_dice", "c&d_move", "roll_dice"},
+				Exclude:          []string{"dm_get_room"},
+				WithoutListTools: true,
+			},
+			wantNames: []string{"dm_get_map", "dm_dice"},
+		},
+		{
+			name:      "exclude only",
+			options:   CatalogOptions{Exclude: []string{"c&d_get_*", "other"}},
+			wantNames: []string{"c&d_move", "c&d_roll_dice", "list_tools"},
+		},
+		{
+			name:    "collision after the renaming",
+			options: CatalogOptions{StripPrefix: "c&d_", Rename: map[string]string{"move": "other"}},
+			wantErr: `tool "other" is defined twice`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			catalog, err := NewCatalog(gatewayTools, test.options)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := toolNames(catalog.Tools()); !slices.Equal(names, test.wantNames) {
+				t.Fatalf("tools %v, want %v", names, test.wantNames)
+			}
+		})
+	}
+}
+
+func TestCatalogMetadata(t *testing.T) {
+	catalog, err := NewCatalog(testTools("get_map", "get_room", "give_item", "move", "roll_dice"), CatalogOptions{
+		Metadata: map[string]ToolMetadata{
+			// The exact names win over the patterns, then the first pattern in sorted order ("g*" < "get_*")
+			"get_map": {ReadOnly: true, NeedsConfirmation: true},
+			"get_*":   {ReadOnly: true, Tags: []string{"get"}},
+			"g*":      {Tags: []string{"g"}},
+			"roll_*":  {ReadOnly: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want ToolMetadata
+	}{
+		{"get_map", ToolMetadata{ReadOnly: true, NeedsConfirmation: true}},
+		{"get_room", ToolMetadata{Tags: []string{"g"}}},
+		{"give_item", ToolMetadata{Tags: []string{"g"}}},
+		{"roll_dice", ToolMetadata{ReadOnly: true}},
+		{"move", ToolMetadata{}},
+		{"list_tools", ToolMetadata{ReadOnly: true}},
+		{"unknown", ToolMetadata{}},
+	}
+	for _, test := range tests {
+		if got := catalog.Metadata(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("metadata of %s = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	if names := catalog.ReadOnlyTools(); !slices.Equal(names, []string{"get_map", "roll_dice", "list_tools"}) {
+		t.Fatalf("read-only tools %v", names)
+	}
+	if names := catalog.ToolsWithoutConfirmation(); !slices.Equal(names, []string{"roll_dice", "list_tools"}) {
+		t.Fatalf("tools without confirmation %v", names)
+	}
+}
+
+func TestCatalogScope(t *testing.T) {
+	catalog, err := NewCatalog(testTools("get_map", "get_room", "move", "roll_dice"), CatalogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"no patterns", nil, []string{}},
+		{"all", []string{"*"}, []string{"get_map", "get_room", "move", "roll_dice", "list_tools"}},
+		{"overlapping patterns in the catalog order", []string{"move", "get_*", "get_map"}, []string{"get_map", "get_room", "move"}},
+		{"unknown tool", []string{"fly"}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if names := toolNames(catalog.Scope(test.patterns...)); !slices.Equal(names, test.want) {
+				t.Fatalf("scope %v, want %v", names, test.want)
+			}
+		})
+	}
+
+	// The scope is a copy
+	scope := catalog.Scope("*")
+	scope[0] = nil
+	if _, exists := catalog.Lookup("get_map"); !exists {
+		t.Fatal("the scope modified the catalog")
+	}
+}
+
+func TestRenamedToolCallsTheOriginalTool(t *testing.T) {
+	original := testTools("c&d_get_room")[0].(ai.Tool)
+	catalog, err := NewCatalog([]ai.ToolRef{original}, CatalogOptions{StripPrefix: "c&d_", WithoutListTools: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toolRef, exists := catalog.Lookup("get_room")
+	if !exists {
+		t.Fatalf("tools %v", toolNames(catalog.Tools()))
+	}
+	renamed := toolRef.(ai.Tool)
+
+	if !reflect.DeepEqual(renamed.Definition().InputSchema, original.Definition().InputSchema) {
+		t.Fatalf("input schema %v, want %v", renamed.Definition().InputSchema, original.Definition().InputSchema)
+	}
+	if renamed.Definition().Description != "The c&d_get_room tool" {
+		t.Fatalf("description %q", renamed.Definition().Description)
+	}
+	output, err := renamed.RunRaw(context.Background(), map[string]any{"room": "room_0_2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "c&d_get_room room_0_2" {
+		t.Fatalf("output %v, want the output of the original tool", output)
+	}
+}
@@ -0,0 +1,90 @@
+package tools
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+type DiceRollInput struct {
+	NumDice  int `json:"num_dice"`
+	NumFaces int `json:"num_faces"`
+}
+
+type DiceRollResult struct {
+	Rolls []int `json:"rolls"`
+	Total int   `json:"total"`
+}
+
+type CharacterNameInput struct {
+	Race string `json:"race"`
+}
+
+type CharacterNameResult struct {
+	Name string `json:"name"`
+	Race string `json:"race"`
+}
+
+// RegisterGameTools registers the local game tools:
+// roll_dice and generate_character_name
+func RegisterGameTools(registry *Registry) error {
+	_, err := Register(registry, "roll_dice", "Roll n dice with n faces each",
+		func(ctx *ai.ToolContext, input DiceRollInput) (DiceRollResult, error) {
+			if input.NumDice <= 0 || input.NumFaces <= 0 {
+				return DiceRollResult{}, fmt.Errorf("num_dice and num_faces must be positive (got %d and %d)", input.NumDice, input.NumFaces)
+			}
+			return RollDice(input.NumDice, input.NumFaces), nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = Register(registry, "generate_character_name", "Generate a D&D character name for a specific race",
+		func(ctx *ai.ToolContext, input CharacterNameInput) (CharacterNameResult, error) {
+			return GenerateCharacterName(input.Race), nil
+		},
+	)
+	return err
+}
+
+// RollDice rolls numDice dice with numFaces faces each
+func RollDice(numDice, numFaces int) DiceRollResult {
+	rolls := make([]int, numDice)
+	total := 0
+
+	for i := 0; i < numDice; i++ {
+		roll := rand.Intn(numFaces) + 1
+		rolls[i] = roll
+		total += roll
+	}
+
+	return DiceRollResult{
+		Rolls: rolls,
+		Total: total,
+	}
+}
+
+// GenerateCharacterName picks a random name for the race (human names for the unknown races)
+func GenerateCharacterName(race string) CharacterNameResult {
+	namesByRace := map[string][]string{
+		"elf":      {"Aerdrie", "Ahvonna", "Aramil", "Aranea", "Berrian", "Caelynn", "Carric", "Dayereth", "Enna", "Galinndan"},
+		"dwarf":    {"Adrik", "Baern", "Darrak", "Eberk", "Fargrim", "Gardain", "Harbek", "Kildrak", "Morgran", "Thorek"},
+		"human":    {"Aerdrie", "Aramil", "Berris", "Cithreth", "Dayereth", "Enna", "Galinndan", "Hadarai", "Immeral", "Lamlis"},
+		"halfling": {"Alton", "Ander", "Bernie", "Bobbin", "Cade", "Callus", "Corrin", "Dannad", "Garret", "Lindal"},
+		"orc":      {"Gash", "Gell", "Henk", "Holg", "Imsh", "Keth", "Krusk", "Mhurren", "Ront", "Shump"},
+		"tiefling": {"Akmenos", "Amnon", "Barakas", "Damakos", "Ekemon", "Iados", "Kairon", "Leucis", "Melech", "Mordai"},
+	}
+
+	names, exists := namesByRace[strings.ToLower(race)]
+	if !exists {
+		names = namesByRace["human"] // Default to human names
+	}
+
+	return CharacterNameResult{
+		Name: names[rand.Intn(len(names))],
+		Race: race,
+	}
+}
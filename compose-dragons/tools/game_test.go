@@ -0,0 +1,74 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRollDiceTool(t *testing.T) {
+	registry := NewRegistry("local")
+	if err := RegisterGameTools(registry); err != nil {
+		t.Fatal(err)
+	}
+	rollDice, _ := registry.Lookup("local_roll_dice")
+
+	tests := []struct {
+		name     string
+		numDice  int
+		numFaces int
+		wantErr  bool
+	}{
+		{"3d6", 3, 6, false},
+		{"1d1", 1, 1, false},
+		{"zero dice", 0, 6, true},
+		{"negative dice", -2, 6, true},
+		{"zero faces", 2, 0, true},
+		{"negative faces", 2, -6, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := rollDice.RunRaw(context.Background(), map[string]any{"num_dice": test.numDice, "num_faces": test.numFaces})
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "num_dice and num_faces must be positive") {
+					t.Fatalf("error %v, want the validation error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result DiceRollResult
+			data, _ := json.Marshal(output)
+			if err := json.Unmarshal(data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Rolls) != test.numDice {
+				t.Fatalf("%d rolls, want %d", len(result.Rolls), test.numDice)
+			}
+			total := 0
+			for _, roll := range result.Rolls {
+				if roll < 1 || roll > test.numFaces {
+					t.Fatalf("roll %d of a %d-faced dice", roll, test.numFaces)
+				}
+				total += roll
+			}
+			if result.Total != total {
+				t.Fatalf("total %d, want %d", result.Total, total)
+			}
+		})
+	}
+}
+
+func TestGenerateCharacterName(t *testing.T) {
+	elfNames := []string{"Aerdrie", "Ahvonna", "Aramil", "Aranea", "Berrian", "Caelynn", "Carric", "Dayereth", "Enna", "Galinndan"}
+	result := GenerateCharacterName("Elf")
+	if result.Race != "Elf" || !slices.Contains(elfNames, result.Name) {
+		t.Fatalf("character %+v, want an elf name", result)
+	}
+	if result := GenerateCharacterName("dragonborn"); result.Name == "" || result.Race != "dragonborn" {
+		t.Fatalf("character %+v, want a human name", result)
+	}
+}
@@ -0,0 +1,507 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/msg"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ConnectionState is the state of an MCPConnection
+type ConnectionState string
+
+const (
+	// MCPConnecting: the first connection is in progress
+	MCPConnecting ConnectionState = "connecting"
+	// MCPConnected: the MCP server answered the last request or health check
+	MCPConnected ConnectionState = "connected"
+	// MCPDisconnected: the MCP server is unreachable, the connection retries in the background
+	MCPDisconnected ConnectionState = "disconnected"
+	// MCPClosed: the connection was closed with Close
+	MCPClosed ConnectionState = "closed"
+)
+
+// MCPTransport is the transport used to reach an MCP server
+type MCPTransport string
+
+const (
+	// MCPStreamableHTTP: remote server or docker-mcp-gateway (default)
+	MCPStreamableHTTP MCPTransport = "streamable-http"
+	// MCPSSE: remote server with the (legacy) HTTP+SSE transport
+	MCPSSE MCPTransport = "sse"
+	// MCPStdio: local server started as a child process
+	MCPStdio MCPTransport = "stdio"
+)
+
+// valid reports whether the transport is known (empty means MCPStreamableHTTP)
+func (transport MCPTransport) valid() bool {
+	switch transport {
+	case MCPStreamableHTTP, MCPSSE, MCPStdio, "":
+		return true
+	}
+	return false
+}
+
+// MCPConnectionOptions configures a managed connection to an MCP server
+// (e.g. the docker-mcp-gateway, or a local server started with the stdio transport)
+type MCPConnectionOptions struct {
+	// Name is the namespace of the tools: "c&d" → "c&d_get_dungeon_map" (no prefix if empty)
+	Name string
+	// Transport is MCPStreamableHTTP when empty
+	Transport MCPTransport
+
+	// BaseURL and Headers are used by the HTTP transports
+	BaseURL string
+	Headers map[string]string
+	// Timeout is the HTTP timeout of one request (0 means the default of the transport)
+	Timeout time.Duration
+
+	// Command, Args and Env (KEY=value) are used by the stdio transport.
+	// The process is started again when the connection is restored.
+	Command string
+	Args    []string
+	Env     []string
+
+	// StartupTimeout is the maximum wait for the first connection (default 60s)
+	StartupTimeout time.Duration
+	// InitialBackoff is the delay before the first retry (default 500ms)
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between two retries (default 10s)
+	MaxBackoff time.Duration
+	// HealthCheckInterval is the delay between two pings (default 10s, negative to disable)
+	HealthCheckInterval time.Duration
+
+	// OnStateChange is called when the state changes (err is the cause of a disconnection)
+	OnStateChange func(name string, state ConnectionState, err error)
+}
+
+// MCPConnection is a managed connection to an MCP server:
+// it retries at startup, reconnects when the server restarts
+// and refreshes the tools list on the "tools/list_changed" notifications.
+//
+// The tools returned by Tools() call the server through the connection,
+// so they keep working after a reconnection.
+//
+//	ConnectMCP
+//	  connect ─❌─> wait (exponential backoff) ─> connect ... (until StartupTimeout)
+//	  ✅ initialize + list the tools
+//	health check (every HealthCheckInterval)
+//	  ping ─❌─> disconnected ─> reconnect with backoff ─> list the tools (Version++)
+//	notification "tools/list_changed" ─> list the tools (Version++)
+//	tool call ─❌─> ping ─❌─> reconnect once and call again
+type MCPConnection struct {
+	options MCPConnectionOptions
+
+	mu     sync.RWMutex
+	client *client.Client
+	// clientCtx is cancelled when the client is replaced or closed,
+	// so the pending requests of a dead server (e.g. a stdio process that exited) do not wait forever
+	clientCtx    context.Context
+	clientCancel context.CancelFunc
+	state        ConnectionState
+	lastErr      error
+	mcpTools     []mcp.Tool
+	version      int
+
+	// reconnectMu serializes the reconnections (health check and tool calls)
+	reconnectMu sync.Mutex
+	// ctx lives as long as the connection (the stdio process is killed when it is done)
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ConnectMCP connects to the MCP server, retrying with backoff until StartupTimeout,
+// then starts the health check in the background
+func ConnectMCP(ctx context.Context, options MCPConnectionOptions) (*MCPConnection, error) {
+	// No retries for a configuration error
+	if !options.Transport.valid() {
+		return nil, fmt.Errorf("unknown MCP transport %q", options.Transport)
+	}
+	conn := &MCPConnection{
+		options: options,
+		state:   MCPConnecting,
+	}
+	conn.ctx, conn.cancel = context.WithCancel(ctx)
+
+	startupCtx, cancelStartup := context.WithTimeout(conn.ctx, options.startupTimeout())
+	defer cancelStartup()
+	if err := conn.connectWithBackoff(startupCtx, nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to connect to the MCP server %s: %w", options.endpoint(), err)
+	}
+
+	if options.HealthCheckInterval >= 0 {
+		go conn.healthCheck(conn.ctx)
+	}
+	return conn, nil
+}
+
+// Name returns the namespace of the tools of the connection
+func (conn *MCPConnection) Name() string {
+	return conn.options.Name
+}
+
+// State returns the state of the connection and the last connection error
+func (conn *MCPConnection) State() (ConnectionState, error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return conn.state, conn.lastErr
+}
+
+// Version is incremented each time the tools list is updated
+// (compare it with a previous value to rebuild the catalog of an agent)
+func (conn *MCPConnection) Version() int {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return conn.version
+}
+
+// Tools returns the current tools of the MCP server as tool references (for NewCatalog or agents.Config.Tools)
+func (conn *MCPConnection) Tools() []ai.ToolRef {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+
+	toolRefs := make([]ai.ToolRef, 0, len(conn.mcpTools))
+	for _, mcpTool := range conn.mcpTools {
+		toolRefs = append(toolRefs, conn.newTool(mcpTool))
+	}
+	return toolRefs
+}
+
+// CallTool calls a tool of the MCP server (name without the namespace).
+// If the call fails because the server is unreachable, the connection is restored and the call is retried once.
+func (conn *MCPConnection) CallTool(ctx context.Context, name string, arguments map[string]any) (*mcp.CallToolResult, error) {
+	mcpClient, clientCtx, err := conn.currentClient()
+	if err != nil {
+		return nil, err
+	}
+	result, err := callTool(ctx, clientCtx, mcpClient, name, arguments)
+	if err == nil {
+		return result, nil
+	}
+
+	// The tool can fail for its own reasons: reconnect only if the server does not answer anymore
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("failed to call tool %s: %w", name, err)
+	}
+	if pingErr := mcpClient.Ping(ctx); pingErr == nil {
+		return nil, fmt.Errorf("failed to call tool %s: %w", name, err)
+	}
+	conn.setState(MCPDisconnected, err)
+	if reconnectErr := conn.reconnect(ctx, mcpClient); reconnectErr != nil {
+		return nil, fmt.Errorf("failed to call tool %s, the MCP server is unreachable: %w", name, reconnectErr)
+	}
+
+	mcpClient, clientCtx, err = conn.currentClient()
+	if err != nil {
+		return nil, err
+	}
+	result, err = callTool(ctx, clientCtx, mcpClient, name, arguments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call tool %s: %w", name, err)
+	}
+	return result, nil
+}
+
+// Refresh lists the tools of the MCP server again
+func (conn *MCPConnection) Refresh(ctx context.Context) error {
+	mcpClient, _, err := conn.currentClient()
+	if err != nil {
+		return err
+	}
+	mcpTools, err := listMCPTools(ctx, mcpClient)
+	if err != nil {
+		return fmt.Errorf("error getting the tools list: %w", err)
+	}
+
+	conn.mu.Lock()
+	conn.mcpTools = mcpTools
+	conn.version++
+	conn.mu.Unlock()
+
+	msg.DisplayMCPMessages(fmt.Sprintf("🔄 MCP 🛠️ Refreshed %v tools from %s", len(mcpTools), conn.options.endpoint()))
+	return nil
+}
+
+// Close stops the health check and closes the connection
+func (conn *MCPConnection) Close() error {
+	conn.cancel()
+	conn.mu.Lock()
+	mcpClient := conn.client
+	conn.client = nil
+	conn.clientCtx, conn.clientCancel = nil, nil
+	conn.mu.Unlock()
+
+	conn.setState(MCPClosed, nil)
+	if mcpClient == nil {
+		return nil
+	}
+	return mcpClient.Close()
+}
+
+// connectWithBackoff connects until it succeeds or the context is done.
+// stale is the client that failed (nil at startup): nothing is done if it was already replaced.
+func (conn *MCPConnection) connectWithBackoff(ctx context.Context, stale *client.Client) error {
+	backoff := conn.options.initialBackoff()
+	for {
+		err := conn.reconnect(ctx, stale)
+		if err == nil {
+			return nil
+		}
+		conn.setState(MCPDisconnected, err)
+		msg.DisplayError(fmt.Sprintf("😡 MCP server %s unreachable, retrying in %v:", conn.options.endpoint(), backoff), err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, conn.options.maxBackoff())
+	}
+}
+
+// reconnect replaces the stale client with a new connection
+func (conn *MCPConnection) reconnect(ctx context.Context, stale *client.Client) error {
+	conn.reconnectMu.Lock()
+	defer conn.reconnectMu.Unlock()
+
+	conn.mu.RLock()
+	replaced := conn.client != stale
+	conn.mu.RUnlock()
+	if replaced {
+		// Another goroutine already reconnected
+		return nil
+	}
+	return conn.connect(ctx)
+}
+
+// connect creates a new client, initializes the session and lists the tools
+func (conn *MCPConnection) connect(ctx context.Context) error {
+	mcpTransport, err := conn.options.newTransport()
+	if err != nil {
+		return fmt.Errorf("failed to create the transport: %w", err)
+	}
+
+	mcpClient := client.NewClient(mcpTransport)
+	mcpClient.OnNotification(func(notification mcp.JSONRPCNotification) {
+		if notification.Method == mcp.MethodNotificationToolsListChanged {
+			// Not in the notification handler: the refresh sends a request with the same client
+			go func() {
+				if err := conn.Refresh(context.Background()); err != nil {
+					msg.DisplayError("😡 Error refreshing the MCP tools:", err)
+				}
+			}()
+		}
+	})
+	// The transport must outlive the startup (e.g. the stdio process): it is started with the context of the connection
+	if err := mcpClient.Start(conn.ctx); err != nil {
+		return fmt.Errorf("failed to start the MCP client: %w", err)
+	}
+
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcp.Implementation{
+		Name:    conn.options.Name,
+		Version: "1.0.0",
+	}
+	if _, err := mcpClient.Initialize(ctx, initRequest); err != nil {
+		mcpClient.Close()
+		return fmt.Errorf("failed to initialize the MCP session: %w", err)
+	}
+
+	mcpTools, err := listMCPTools(ctx, mcpClient)
+	if err != nil {
+		mcpClient.Close()
+		return fmt.Errorf("error getting the tools list: %w", err)
+	}
+
+	conn.mu.Lock()
+	previous, previousCancel := conn.client, conn.clientCancel
+	conn.client = mcpClient
+	conn.clientCtx, conn.clientCancel = context.WithCancel(conn.ctx)
+	conn.mcpTools = mcpTools
+	conn.version++
+	conn.mu.Unlock()
+	if previous != nil {
+		previousCancel()
+		previous.Close()
+	}
+
+	msg.DisplayMCPMessages(fmt.Sprintf("🟢 MCP 🛠️ Retrieved %v tools from %s", len(mcpTools), conn.options.endpoint()))
+	conn.setState(MCPConnected, nil)
+	return nil
+}
+
+// healthCheck pings the server and reconnects (with backoff) when it does not answer
+func (conn *MCPConnection) healthCheck(ctx context.Context) {
+	ticker := time.NewTicker(conn.options.healthCheckInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		conn.mu.RLock()
+		mcpClient := conn.client
+		conn.mu.RUnlock()
+		if mcpClient == nil {
+			return
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, conn.options.healthCheckInterval())
+		err := mcpClient.Ping(pingCtx)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		conn.setState(MCPDisconnected, err)
+		conn.connectWithBackoff(ctx, mcpClient)
+	}
+}
+
+func (conn *MCPConnection) currentClient() (*client.Client, context.Context, error) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.client == nil {
+		return nil, nil, fmt.Errorf("the MCP connection to %s is %s", conn.options.endpoint(), conn.state)
+	}
+	return conn.client, conn.clientCtx, nil
+}
+
+func (conn *MCPConnection) setState(state ConnectionState, err error) {
+	conn.mu.Lock()
+	changed := conn.state != state
+	conn.state = state
+	conn.lastErr = err
+	conn.mu.Unlock()
+
+	if changed && conn.options.OnStateChange != nil {
+		conn.options.OnStateChange(conn.options.Name, state, err)
+	}
+}
+
+// newTool converts an MCP tool to a genkit tool calling the server through the connection
+func (conn *MCPConnection) newTool(mcpTool mcp.Tool) ai.Tool {
+	name := mcpTool.Name
+	if conn.options.Name != "" {
+		name = conn.options.Name + "_" + mcpTool.Name
+	}
+
+	inputSchema := map[string]any{}
+	if schema, err := json.Marshal(mcpTool.InputSchema); err == nil {
+		json.Unmarshal(schema, &inputSchema)
+	}
+
+	return ai.NewToolWithInputSchema(name, mcpTool.Description, inputSchema,
+		func(ctx *ai.ToolContext, input any) (any, error) {
+			arguments := map[string]any{}
+			if input != nil {
+				data, err := json.Marshal(input)
+				if err != nil {
+					return nil, fmt.Errorf("invalid arguments for tool %s: %w", mcpTool.Name, err)
+				}
+				if err := json.Unmarshal(data, &arguments); err != nil {
+					return nil, fmt.Errorf("invalid arguments for tool %s: %w", mcpTool.Name, err)
+				}
+			}
+			return conn.CallTool(ctx, mcpTool.Name, arguments)
+		},
+	)
+}
+
+// callTool calls the tool until the request is done or the client is replaced
+func callTool(ctx, clientCtx context.Context, mcpClient *client.Client, name string, arguments map[string]any) (*mcp.CallToolResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(clientCtx, cancel)
+	defer stop()
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = name
+	request.Params.Arguments = arguments
+	return mcpClient.CallTool(ctx, request)
+}
+
+func listMCPTools(ctx context.Context, mcpClient *client.Client) ([]mcp.Tool, error) {
+	result, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return result.Tools, nil
+}
+
+func (options MCPConnectionOptions) newTransport() (transport.Interface, error) {
+	switch options.Transport {
+	case MCPStdio:
+		if options.Command == "" {
+			return nil, fmt.Errorf("the stdio transport needs a command")
+		}
+		return transport.NewStdio(options.Command, options.Env, options.Args...), nil
+	case MCPSSE:
+		sseOptions := []transport.ClientOption{}
+		if options.Headers != nil {
+			sseOptions = append(sseOptions, transport.WithHeaders(options.Headers))
+		}
+		return transport.NewSSE(options.BaseURL, sseOptions...)
+	case MCPStreamableHTTP, "":
+		httpOptions := []transport.StreamableHTTPCOption{}
+		if options.Headers != nil {
+			httpOptions = append(httpOptions, transport.WithHTTPHeaders(options.Headers))
+		}
+		if options.Timeout > 0 {
+			httpOptions = append(httpOptions, transport.WithHTTPTimeout(options.Timeout))
+		}
+		return transport.NewStreamableHTTP(options.BaseURL, httpOptions...)
+	default:
+		return nil, fmt.Errorf("unknown MCP transport %q", options.Transport)
+	}
+}
+
+// endpoint describes the server in the messages (URL or command)
+func (options MCPConnectionOptions) endpoint() string {
+	if options.Transport == MCPStdio {
+		return strings.TrimSpace(options.Command + " " + strings.Join(options.Args, " "))
+	}
+	return options.BaseURL
+}
+
+func (options MCPConnectionOptions) startupTimeout() time.Duration {
+	if options.StartupTimeout > 0 {
+		return options.StartupTimeout
+	}
+	return 60 * time.Second
+}
+
+func (options MCPConnectionOptions) initialBackoff() time.Duration {
+	if options.InitialBackoff > 0 {
+		return options.InitialBackoff
+	}
+	return 500 * time.Millisecond
+}
+
+func (options MCPConnectionOptions) maxBackoff() time.Duration {
+	if options.MaxBackoff > 0 {
+		return options.MaxBackoff
+	}
+	return 10 * time.Second
+}
+
+func (options MCPConnectionOptions) healthCheckInterval() time.Duration {
+	if options.HealthCheckInterval > 0 {
+		return options.HealthCheckInterval
+	}
+	return 10 * time.Second
+}
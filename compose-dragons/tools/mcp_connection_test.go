@@ -0,0 +1,294 @@
+package tools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// testMCPServer is an in-process MCP server (SSE transport) that can be stopped and restarted on the same URL
+type testMCPServer struct {
+	httpServer *httptest.Server
+
+	mu        sync.Mutex
+	mcpServer *server.MCPServer
+	sseServer *server.SSEServer
+	down      bool
+
+	// connections, pings and sseRequests count the initialize requests, the pings and the connection attempts
+	connections atomic.Int32
+	pings       atomic.Int32
+	sseRequests atomic.Int32
+}
+
+// newTestMCPServer starts a server with a "roll" tool
+func newTestMCPServer(t *testing.T) *testMCPServer {
+	t.Helper()
+	testServer := &testMCPServer{}
+	testServer.httpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sse") {
+			testServer.sseRequests.Add(1)
+		}
+		testServer.mu.Lock()
+		down, sseServer := testServer.down, testServer.sseServer
+		testServer.mu.Unlock()
+		if down {
+			http.Error(w, "the server is down", http.StatusServiceUnavailable)
+			return
+		}
+		sseServer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		testServer.httpServer.CloseClientConnections()
+		testServer.httpServer.Close()
+	})
+	testServer.Restart()
+	return testServer
+}
+
+// Restart replaces the MCP server: the sessions of the previous one are unknown to the new one
+func (testServer *testMCPServer) Restart() {
+	hooks := &server.Hooks{}
+	hooks.AddBeforeInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest) {
+		testServer.connections.Add(1)
+	})
+	hooks.AddBeforePing(func(ctx context.Context, id any, message *mcp.PingRequest) {
+		testServer.pings.Add(1)
+	})
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithHooks(hooks))
+	mcpServer.AddTool(mcp.NewTool("roll", mcp.WithDescription("Roll a dice")),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("6"), nil
+		})
+
+	testServer.mu.Lock()
+	defer testServer.mu.Unlock()
+	testServer.mcpServer = mcpServer
+	testServer.sseServer = server.NewSSEServer(mcpServer, server.WithBaseURL(testServer.httpServer.URL))
+	testServer.down = false
+}
+
+// Stop answers all the requests with an error and closes the open streams
+func (testServer *testMCPServer) Stop() {
+	testServer.mu.Lock()
+	testServer.down = true
+	testServer.mu.Unlock()
+	testServer.httpServer.CloseClientConnections()
+}
+
+// MCPServer returns the current MCP server (e.g. to add tools)
+func (testServer *testMCPServer) MCPServer() *server.MCPServer {
+	testServer.mu.Lock()
+	defer testServer.mu.Unlock()
+	return testServer.mcpServer
+}
+
+// stateRecorder records the states of a connection (OnStateChange)
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ConnectionState
+}
+
+func (recorder *stateRecorder) record(name string, state ConnectionState, err error) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.states = append(recorder.states, state)
+}
+
+func (recorder *stateRecorder) States() []ConnectionState {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return slices.Clone(recorder.states)
+}
+
+// testConnectionOptions returns fast retries and no health check
+func testConnectionOptions(testServer *testMCPServer, recorder *stateRecorder) MCPConnectionOptions {
+	return MCPConnectionOptions{
+		Name:                "c&d",
+		Transport:           MCPSSE,
+		BaseURL:             testServer.httpServer.URL + "/sse",
+		StartupTimeout:      time.Second,
+		InitialBackoff:      10 * time.Millisecond,
+		MaxBackoff:          20 * time.Millisecond,
+		HealthCheckInterval: -1,
+		OnStateChange:       recorder.record,
+	}
+}
+
+// eventually waits until the condition is true (2s at most)
+func eventually(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", description)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConnectMCPGivesUpAfterTheStartupTimeout(t *testing.T) {
+	testServer := newTestMCPServer(t)
+	testServer.Stop()
+	recorder := &stateRecorder{}
+	options := testConnectionOptions(testServer, recorder)
+	options.StartupTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	conn, err := ConnectMCP(context.Background(), options)
+	if err == nil {
+		conn.Close()
+		t.Fatal("connected to a stopped server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("gave up after %v, want about the startup timeout", elapsed)
+	}
+	// 10ms, then 20ms between the attempts
+	if attempts := testServer.sseRequests.Load(); attempts < 3 || attempts > 20 {
+		t.Fatalf("%d connection attempts", attempts)
+	}
+	if states := recorder.States(); !slices.Equal(states, []ConnectionState{MCPDisconnected, MCPClosed}) {
+		t.Fatalf("states %v", states)
+	}
+}
+
+func TestConnectMCPRetriesUntilTheServerStarts(t *testing.T) {
+	testServer := newTestMCPServer(t)
+	testServer.Stop()
+	time.AfterFunc(50*time.Millisecond, testServer.Restart)
+
+	conn, err := ConnectMCP(context.Background(), testConnectionOptions(testServer, &stateRecorder{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if attempts := testServer.sseRequests.Load(); attempts < 2 {
+		t.Fatalf("%d connection attempts, want retries", attempts)
+	}
+	if tools := conn.Tools(); len(tools) != 1 || tools[0].Name() != "c&d_roll" {
+		t.Fatalf("tools %v, want c&d_roll", tools)
+	}
+}
+
+func TestCallToolReconnectsOnce(t *testing.T) {
+	testServer := newTestMCPServer(t)
+	recorder := &stateRecorder{}
+	conn, err := ConnectMCP(context.Background(), testConnectionOptions(testServer, recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A failing tool call on a live server does not reconnect
+	if _, err := conn.CallTool(context.Background(), "unknown", nil); err == nil {
+		t.Fatal("unknown tool called")
+	}
+	if connections := testServer.connections.Load(); connections != 1 {
+		t.Fatalf("%d connections after a tool error, want 1", connections)
+	}
+
+	// The restarted server does not know the session: the call reconnects and succeeds
+	testServer.Restart()
+	result, err := conn.CallTool(context.Background(), "roll", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; text != "6" {
+		t.Fatalf("result %q, want 6", text)
+	}
+	if connections := testServer.connections.Load(); connections != 2 || conn.Version() != 2 {
+		t.Fatalf("%d connections and version %d after the restart, want 2 and 2", connections, conn.Version())
+	}
+	if states := recorder.States(); !slices.Equal(states, []ConnectionState{MCPConnected, MCPDisconnected, MCPConnected}) {
+		t.Fatalf("states %v", states)
+	}
+
+	// The stopped server is reconnected once, then the call fails
+	testServer.Stop()
+	attempts := testServer.sseRequests.Load()
+	if _, err := conn.CallTool(context.Background(), "roll", nil); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Fatalf("error %v, want an unreachable server", err)
+	}
+	if reconnections := testServer.sseRequests.Load() - attempts; reconnections != 1 {
+		t.Fatalf("%d reconnections, want 1", reconnections)
+	}
+	if state, err := conn.State(); state != MCPDisconnected || err == nil {
+		t.Fatalf("state %s (%v), want disconnected", state, err)
+	}
+}
+
+func TestToolsListChanged(t *testing.T) {
+	testServer := newTestMCPServer(t)
+	conn, err := ConnectMCP(context.Background(), testConnectionOptions(testServer, &stateRecorder{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Version() != 1 {
+		t.Fatalf("version %d, want 1", conn.Version())
+	}
+
+	testServer.MCPServer().AddTool(mcp.NewTool("open_door", mcp.WithDescription("Open a door")),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("the door is open"), nil
+		})
+	eventually(t, "the refresh of the tools", func() bool { return conn.Version() == 2 })
+
+	names := []string{}
+	for _, tool := range conn.Tools() {
+		names = append(names, tool.Name())
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"c&d_open_door", "c&d_roll"}) {
+		t.Fatalf("tools %v", names)
+	}
+	if connections := testServer.connections.Load(); connections != 1 {
+		t.Fatalf("%d connections, want the same session", connections)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	testServer := newTestMCPServer(t)
+	recorder := &stateRecorder{}
+	options := testConnectionOptions(testServer, recorder)
+	options.HealthCheckInterval = 10 * time.Millisecond
+	conn, err := ConnectMCP(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the pings", func() bool { return testServer.pings.Load() >= 2 })
+
+	// The restarted server is reconnected by the health check
+	testServer.Restart()
+	eventually(t, "the reconnection", func() bool { return testServer.connections.Load() == 2 && conn.Version() == 2 })
+	eventually(t, "the connected state", func() bool {
+		state, _ := conn.State()
+		return state == MCPConnected
+	})
+
+	// Close stops the health check
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	pings := testServer.pings.Load()
+	time.Sleep(50 * time.Millisecond)
+	if after := testServer.pings.Load(); after != pings {
+		t.Fatalf("%d pings after Close", after-pings)
+	}
+	if state, _ := conn.State(); state != MCPClosed {
+		t.Fatalf("state %s, want closed", state)
+	}
+	if states := recorder.States(); states[len(states)-1] != MCPClosed {
+		t.Fatalf("states %v", states)
+	}
+}
@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/helpers"
+
+	"github.com/firebase/genkit/go/ai"
+	"gopkg.in/yaml.v3"
+)
+
+// MCPServerConfig is the definition of one MCP server of a MCPServersConfig file
+type MCPServerConfig struct {
+	// Name is the prefix of the tools of the server ("c&d" → "c&d_get_dungeon_map")
+	Name      string            `yaml:"name"`
+	Transport MCPTransport      `yaml:"transport"`
+	URL       string            `yaml:"url"`
+	Headers   map[string]string `yaml:"headers"`
+	Command   string            `yaml:"command"`
+	Args      []string          `yaml:"args"`
+	Env       []string          `yaml:"env"`
+	Timeout   time.Duration     `yaml:"timeout"`
+}
+
+// MCPServersConfig is the list of MCP servers used by the agents.
+// The environment variables are expanded before parsing, with an optional default value:
+//
+//	servers:
+//	  # the Docker MCP gateway
+//	  - name: c&d
+//	    url: ${MCP_SERVER_BASE_URL:-http://localhost:9011/mcp}
+//	  # local servers started as child processes (no Docker needed)
+//	  - name: toc
+//	    transport: stdio
+//	    command: go
+//	    args: [-C, ../dungeon-toc-toc-mcp-server, run, .]
+//	    env: [MCP_TRANSPORT=stdio]
+type MCPServersConfig struct {
+	Servers []MCPServerConfig `yaml:"servers"`
+}
+
+// LoadMCPServersConfig reads and parses a MCP servers file (*.yaml or *.yml)
+func LoadMCPServersConfig(configPath string) (MCPServersConfig, error) {
+	content, err := helpers.ReadTextFile(configPath)
+	if err != nil {
+		return MCPServersConfig{}, err
+	}
+	config := MCPServersConfig{}
+	if err := yaml.Unmarshal([]byte(helpers.ExpandEnvWithDefaults(content)), &config); err != nil {
+		return MCPServersConfig{}, fmt.Errorf("error parsing the MCP servers file %s: %w", configPath, err)
+	}
+	if len(config.Servers) == 0 {
+		return MCPServersConfig{}, fmt.Errorf("no MCP server in %s", configPath)
+	}
+	names := map[string]bool{}
+	for _, server := range config.Servers {
+		if names[server.Name] {
+			return MCPServersConfig{}, fmt.Errorf("MCP server %q is defined twice in %s", server.Name, configPath)
+		}
+		if !server.Transport.valid() {
+			return MCPServersConfig{}, fmt.Errorf("MCP server %q: unknown transport %q in %s", server.Name, server.Transport, configPath)
+		}
+		names[server.Name] = true
+	}
+	return config, nil
+}
+
+// MCPServers is a group of managed MCP connections whose tools are merged
+// (each server has its own prefix, so the tool names do not collide)
+type MCPServers struct {
+	connections []*MCPConnection
+}
+
+// ConnectMCPServers connects to all the servers of the config (concurrently).
+// The defaults options are used for the retries, the health checks and OnStateChange.
+// If one server cannot be reached, the other connections are closed and an error is returned.
+func ConnectMCPServers(ctx context.Context, config MCPServersConfig, defaults MCPConnectionOptions) (*MCPServers, error) {
+	connections := make([]*MCPConnection, len(config.Servers))
+	errs := make([]error, len(config.Servers))
+
+	var wg sync.WaitGroup
+	for i, server := range config.Servers {
+		options := defaults
+		options.Name = server.Name
+		options.Transport = server.Transport
+		options.BaseURL = server.URL
+		options.Headers = server.Headers
+		options.Command = server.Command
+		options.Args = server.Args
+		options.Env = server.Env
+		if server.Timeout > 0 {
+			options.Timeout = server.Timeout
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			connections[i], errs[i] = ConnectMCP(ctx, options)
+		}()
+	}
+	wg.Wait()
+
+	servers := &MCPServers{}
+	var firstErr error
+	for i, conn := range connections {
+		if errs[i] != nil && firstErr == nil {
+			firstErr = fmt.Errorf("MCP server %q: %w", config.Servers[i].Name, errs[i])
+		}
+		if conn != nil {
+			servers.connections = append(servers.connections, conn)
+		}
+	}
+	if firstErr != nil {
+		servers.Close()
+		return nil, firstErr
+	}
+	return servers, nil
+}
+
+// Connections returns the connections in the order of the config
+func (servers *MCPServers) Connections() []*MCPConnection {
+	return servers.connections
+}
+
+// Tools returns the tools of all the servers (for NewCatalog or agents.Config.Tools)
+func (servers *MCPServers) Tools() ([]ai.ToolRef, error) {
+	toolSets := make([][]ai.ToolRef, 0, len(servers.connections))
+	for _, conn := range servers.connections {
+		toolSets = append(toolSets, conn.Tools())
+	}
+	return Merge(toolSets...)
+}
+
+// Version changes each time the tools list of one of the servers is updated
+func (servers *MCPServers) Version() int {
+	version := 0
+	for _, conn := range servers.connections {
+		version += conn.Version()
+	}
+	return version
+}
+
+// Close closes all the connections
+func (servers *MCPServers) Close() error {
+	var firstErr error
+	for _, conn := range servers.connections {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
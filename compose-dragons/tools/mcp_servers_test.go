@@ -0,0 +1,251 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadMCPServersConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		want    []MCPServerConfig
+		wantErr string
+	}{
+		{
+			name: "servers",
+			content: `servers:
+  - name: c&d
+    url: ${TEST_MCP_URL:-http://localhost:9011/mcp}
+    headers:
+      Authorization: Bearer ${TEST_MCP_TOKEN:-secret}
+    timeout: 5s
+  - name: toc
+    transport: stdio
+    command: go
+    args: [-C, ../dungeon-toc-toc-mcp-server, run, .]
+    env: [MCP_TRANSPORT=stdio]
+`,
+			want: []MCPServerConfig{
+				{Name: "c&d", URL: "http://localhost:9011/mcp", Headers: map[string]string{"Authorization": "Bearer secret"}, Timeout: 5 * time.Second},
+				{Name: "toc", Transport: MCPStdio, Command: "go", Args: []string{"-C", "../dungeon-toc-toc-mcp-server", "run", "."}, Env: []string{"MCP_TRANSPORT=stdio"}},
+			},
+		},
+		{
+			name:    "environment variables",
+			content: "servers:\n  - name: c&d\n    transport: sse\n    url: ${TEST_MCP_URL:-http://localhost:9011/mcp}\n",
+			env:     map[string]string{"TEST_MCP_URL": "http://mcp-gateway:9011/sse"},
+			want:    []MCPServerConfig{{Name: "c&d", Transport: MCPSSE, URL: "http://mcp-gateway:9011/sse"}},
+		},
+		{
+			name:    "no server",
+			content: "servers: []\n",
+			wantErr: "no MCP server in",
+		},
+		{
+			name:    "duplicate name",
+			content: "servers:\n  - name: c&d\n    url: http://localhost:9011/mcp\n  - name: c&d\n    url: http://localhost:9012/mcp\n",
+			wantErr: `MCP server "c&d" is defined twice`,
+		},
+		{
+			name:    "unknown transport",
+			content: "servers:\n  - name: c&d\n    transport: websocket\n    url: ws://localhost:9011\n",
+			wantErr: `MCP server "c&d": unknown transport "websocket"`,
+		},
+		{
+			name:    "invalid YAML",
+			content: "servers:\n  - name: [c&d\n",
+			wantErr: "error parsing the MCP servers file",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"TEST_MCP_URL", "TEST_MCP_TOKEN"} {
+				t.Setenv(name, test.env[name])
+			}
+			configPath := filepath.Join(t.TempDir(), "mcp.servers.yaml")
+			if err := os.WriteFile(configPath, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadMCPServersConfig(configPath)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(config.Servers) != len(test.want) {
+				t.Fatalf("servers %+v, want %+v", config.Servers, test.want)
+			}
+			for i, server := range config.Servers {
+				want := test.want[i]
+				if server.Name != want.Name || server.Transport != want.Transport || server.URL != want.URL ||
+					server.Command != want.Command || !slices.Equal(server.Args, want.Args) || !slices.Equal(server.Env, want.Env) ||
+					server.Timeout != want.Timeout || len(server.Headers) != len(want.Headers) ||
+					server.Headers["Authorization"] != want.Headers["Authorization"] {
+					t.Fatalf("server %d: %+v, want %+v", i, server, want)
+				}
+			}
+		})
+	}
+
+	if _, err := LoadMCPServersConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("loaded a missing file")
+	}
+}
+
+// serverStates records the states of the connections by server name, with the time of the change
+type serverStates struct {
+	mu     sync.Mutex
+	states map[string][]ConnectionState
+	times  map[string][]time.Time
+}
+
+func (recorder *serverStates) record(name string, state ConnectionState, err error) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.states[name] = append(recorder.states[name], state)
+	recorder.times[name] = append(recorder.times[name], time.Now())
+}
+
+// connectedAt returns the time of the first connection of the server (zero if it was never connected)
+func (recorder *serverStates) connectedAt(name string) time.Time {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	for i, state := range recorder.states[name] {
+		if state == MCPConnected {
+			return recorder.times[name][i]
+		}
+	}
+	return time.Time{}
+}
+
+func (recorder *serverStates) last(name string) ConnectionState {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	states := recorder.states[name]
+	if len(states) == 0 {
+		return ""
+	}
+	return states[len(states)-1]
+}
+
+// testServersDefaults returns the default options of the connections (fast retries, no health check)
+func testServersDefaults(recorder *serverStates) MCPConnectionOptions {
+	return MCPConnectionOptions{
+		StartupTimeout:      300 * time.Millisecond,
+		InitialBackoff:      10 * time.Millisecond,
+		MaxBackoff:          20 * time.Millisecond,
+		HealthCheckInterval: -1,
+		OnStateChange:       recorder.record,
+	}
+}
+
+func TestConnectMCPServers(t *testing.T) {
+	cdServer, tocServer := newTestMCPServer(t), newTestMCPServer(t)
+	config := MCPServersConfig{Servers: []MCPServerConfig{
+		{Name: "c&d", Transport: MCPSSE, URL: cdServer.httpServer.URL + "/sse"},
+		{Name: "toc", Transport: MCPSSE, URL: tocServer.httpServer.URL + "/sse"},
+	}}
+	recorder := &serverStates{states: map[string][]ConnectionState{}, times: map[string][]time.Time{}}
+
+	servers, err := ConnectMCPServers(context.Background(), config, testServersDefaults(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer servers.Close()
+
+	// Each server has its own prefix, the tools are merged in the order of the config
+	toolRefs, err := servers.Tools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := toolNames(toolRefs); !slices.Equal(names, []string{"c&d_roll", "toc_roll"}) {
+		t.Fatalf("tools %v", names)
+	}
+	names := []string{}
+	for _, conn := range servers.Connections() {
+		names = append(names, conn.Name())
+	}
+	if !slices.Equal(names, []string{"c&d", "toc"}) {
+		t.Fatalf("connections %v", names)
+	}
+	if servers.Version() != 2 {
+		t.Fatalf("version %d, want 2", servers.Version())
+	}
+
+	if err := servers.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.last("c&d") != MCPClosed || recorder.last("toc") != MCPClosed {
+		t.Fatalf("states %v after Close", recorder.states)
+	}
+}
+
+func TestConnectMCPServersWithAFailingServer(t *testing.T) {
+	failingServer, cdServer, tocServer := newTestMCPServer(t), newTestMCPServer(t), newTestMCPServer(t)
+	failingServer.Stop()
+	config := MCPServersConfig{Servers: []MCPServerConfig{
+		{Name: "down", Transport: MCPSSE, URL: failingServer.httpServer.URL + "/sse"},
+		{Name: "c&d", Transport: MCPSSE, URL: cdServer.httpServer.URL + "/sse"},
+		{Name: "toc", Transport: MCPSSE, URL: tocServer.httpServer.URL + "/sse"},
+	}}
+	recorder := &serverStates{states: map[string][]ConnectionState{}, times: map[string][]time.Time{}}
+	defaults := testServersDefaults(recorder)
+
+	start := time.Now()
+	servers, err := ConnectMCPServers(context.Background(), config, defaults)
+	if err == nil {
+		servers.Close()
+		t.Fatal("connected with a stopped server")
+	}
+	if !strings.Contains(err.Error(), `MCP server "down"`) {
+		t.Fatalf("error %v, want the name of the stopped server", err)
+	}
+
+	// The other servers are connected concurrently (before the startup timeout of the stopped server),
+	// then closed
+	for _, name := range []string{"c&d", "toc"} {
+		connectedAt := recorder.connectedAt(name)
+		if connectedAt.IsZero() || connectedAt.Sub(start) >= defaults.StartupTimeout {
+			t.Fatalf("%s connected after %v, want a concurrent connection", name, connectedAt.Sub(start))
+		}
+		if state := recorder.last(name); state != MCPClosed {
+			t.Fatalf("%s is %s, want closed", name, state)
+		}
+	}
+}
+
+func TestConnectMCPServersWithAnUnknownTransport(t *testing.T) {
+	testServer := newTestMCPServer(t)
+	config := MCPServersConfig{Servers: []MCPServerConfig{
+		{Name: "c&d", Transport: MCPSSE, URL: testServer.httpServer.URL + "/sse"},
+		{Name: "ws", Transport: "websocket", URL: "ws://localhost:0"},
+	}}
+	recorder := &serverStates{states: map[string][]ConnectionState{}, times: map[string][]time.Time{}}
+	defaults := testServersDefaults(recorder)
+	defaults.StartupTimeout = 10 * time.Second
+
+	start := time.Now()
+	_, err := ConnectMCPServers(context.Background(), config, defaults)
+	if err == nil || !strings.Contains(err.Error(), `MCP server "ws": unknown MCP transport "websocket"`) {
+		t.Fatalf("error %v, want the unknown transport", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("failed after %v, want no retries", elapsed)
+	}
+	if state := recorder.last("c&d"); state != MCPClosed {
+		t.Fatalf("c&d is %s, want closed", state)
+	}
+}
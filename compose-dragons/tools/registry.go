@@ -0,0 +1,131 @@
+package tools
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Registry holds local Go tools (typed functions) that can be merged with the MCP tools.
+// The tools are created with ai.NewTool and are not registered in a genkit instance,
+// so a registry can be used with any agent (and several times with the shared genkit instance).
+//
+// The tool names are prefixed with the namespace, like the MCP tools ("c&d_get_dungeon_map"):
+//
+//	registry := tools.NewRegistry("local")
+//	tools.Register(registry, "roll_dice", "Roll n dice with n faces each",
+//		func(ctx *ai.ToolContext, input tools.DiceRollInput) (tools.DiceRollResult, error) {
+//			return tools.RollDice(input.NumDice, input.NumFaces), nil
+//		},
+//	)
+//	// registry.Tools() → [local_roll_dice]
+type Registry struct {
+	Namespace string
+
+	mu    sync.RWMutex
+	tools []ai.Tool
+}
+
+// NewRegistry creates an empty registry (an empty namespace means no prefix)
+func NewRegistry(namespace string) *Registry {
+	return &Registry{
+		Namespace: namespace,
+		tools:     []ai.Tool{},
+	}
+}
+
+// Register adds a typed Go function to the registry.
+// The input and output JSON schemas are inferred from the In and Out types (use the json tags).
+func Register[In, Out any](registry *Registry, name, description string, fn func(ctx *ai.ToolContext, input In) (Out, error)) (ai.Tool, error) {
+	toolName := registry.toolName(name)
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, tool := range registry.tools {
+		if tool.Name() == toolName {
+			return nil, fmt.Errorf("tool %q is already registered", toolName)
+		}
+	}
+	tool := ai.NewTool(toolName, description, fn)
+	registry.tools = append(registry.tools, tool)
+	return tool, nil
+}
+
+// Tools returns the tools of the registry as tool references (for agents.Config.Tools)
+func (registry *Registry) Tools() []ai.ToolRef {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	toolRefs := make([]ai.ToolRef, 0, len(registry.tools))
+	for _, tool := range registry.tools {
+		toolRefs = append(toolRefs, tool)
+	}
+	return toolRefs
+}
+
+// Names returns the (namespaced) names of the tools of the registry
+func (registry *Registry) Names() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	names := make([]string, 0, len(registry.tools))
+	for _, tool := range registry.tools {
+		names = append(names, tool.Name())
+	}
+	return names
+}
+
+// Lookup returns the tool with the given (namespaced) name
+func (registry *Registry) Lookup(name string) (ai.Tool, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	for _, tool := range registry.tools {
+		if tool.Name() == name {
+			return tool, true
+		}
+	}
+	return nil, false
+}
+
+func (registry *Registry) toolName(name string) string {
+	if registry.Namespace == "" {
+		return name
+	}
+	return registry.Namespace + "_" + name
+}
+
+// Merge concatenates tool sets (e.g. the MCP catalog and a local registry)
+// and returns an error if two tools have the same name
+func Merge(toolSets ...[]ai.ToolRef) ([]ai.ToolRef, error) {
+	merged := []ai.ToolRef{}
+	names := map[string]bool{}
+	for _, toolSet := range toolSets {
+		for _, tool := range toolSet {
+			if names[tool.Name()] {
+				return nil, fmt.Errorf("tool %q is defined twice", tool.Name())
+			}
+			names[tool.Name()] = true
+			merged = append(merged, tool)
+		}
+	}
+	return merged, nil
+}
+
+// Filter keeps the tools matching one of the patterns (exact names or path.Match patterns like "local_*"),
+// in the order of the tools
+func Filter(toolRefs []ai.ToolRef, patterns ...string) []ai.ToolRef {
+	filtered := []ai.ToolRef{}
+	for _, tool := range toolRefs {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, tool.Name()); matched || pattern == tool.Name() {
+				filtered = append(filtered, tool)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+func TestRegistryNamespace(t *testing.T) {
+	tests := []struct {
+		namespace string
+		wantNames []string
+	}{
+		{"local", []string{"local_roll_dice", "local_generate_character_name"}},
+		{"", []string{"roll_dice", "generate_character_name"}},
+	}
+	for _, test := range tests {
+		registry := NewRegistry(test.namespace)
+		if err := RegisterGameTools(registry); err != nil {
+			t.Fatal(err)
+		}
+		if names := registry.Names(); !slices.Equal(names, test.wantNames) {
+			t.Fatalf("namespace %q: names %v, want %v", test.namespace, names, test.wantNames)
+		}
+		if names := toolNames(registry.Tools()); !slices.Equal(names, test.wantNames) {
+			t.Fatalf("namespace %q: tools %v, want %v", test.namespace, names, test.wantNames)
+		}
+		if _, exists := registry.Lookup(test.wantNames[0]); !exists {
+			t.Fatalf("namespace %q: %s not found", test.namespace, test.wantNames[0])
+		}
+	}
+
+	// The names are looked up with the namespace
+	registry := NewRegistry("local")
+	if err := RegisterGameTools(registry); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := registry.Lookup("roll_dice"); exists {
+		t.Fatal("roll_dice found without the namespace")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	registry := NewRegistry("local")
+	if err := RegisterGameTools(registry); err != nil {
+		t.Fatal(err)
+	}
+	err := RegisterGameTools(registry)
+	if err == nil || !strings.Contains(err.Error(), `tool "local_roll_dice" is already registered`) {
+		t.Fatalf("error %v, want the tool already registered", err)
+	}
+	if names := registry.Names(); len(names) != 2 {
+		t.Fatalf("names %v after the second registration", names)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	registry := NewRegistry("local")
+	if err := RegisterGameTools(registry); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		toolSets  [][]ai.ToolRef
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name:      "MCP tools and local tools",
+			toolSets:  [][]ai.ToolRef{testTools("c&d_get_map", "c&d_move"), registry.Tools()},
+			wantNames: []string{"c&d_get_map", "c&d_move", "local_roll_dice", "local_generate_character_name"},
+		},
+		{
+			name:      "no tools",
+			toolSets:  [][]ai.ToolRef{nil, {}},
+			wantNames: []string{},
+		},
+		{
+			name:     "collision between the sets",
+			toolSets: [][]ai.ToolRef{testTools("c&d_get_map", "local_roll_dice"), registry.Tools()},
+			wantErr:  `tool "local_roll_dice" is defined twice`,
+		},
+		{
+			name:     "collision in a set",
+			toolSets: [][]ai.ToolRef{testTools("c&d_move", "c&d_move")},
+			wantErr:  `tool "c&d_move" is defined twice`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, err := Merge(test.toolSets...)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := toolNames(merged); !slices.Equal(names, test.wantNames) {
+				t.Fatalf("merged tools %v, want %v", names, test.wantNames)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	toolRefs := testTools("c&d_get_map", "c&d_move", "local_roll_dice", "local_generate_character_name")
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{nil, []string{}},
+		{[]string{"local_*"}, []string{"local_roll_dice", "local_generate_character_name"}},
+		{[]string{"local_roll_dice", "c&d_*", "local_*"}, []string{"c&d_get_map", "c&d_move", "local_roll_dice", "local_generate_character_name"}},
+		{[]string{"*_move", "unknown"}, []string{"c&d_move"}},
+	}
+	for _, test := range tests {
+		if names := toolNames(Filter(toolRefs, test.patterns...)); !slices.Equal(names, test.want) {
+			t.Errorf("filter %v: %v, want %v", test.patterns, names, test.want)
+		}
+	}
+}
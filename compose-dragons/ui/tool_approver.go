@@ -0,0 +1,24 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/agents"
+)
+
+// TUIToolApprover asks the player to approve the tool calls with the terminal prompt (y/n/q)
+// It implements agents.ToolApprover
+type TUIToolApprover struct {
+	Color string
+}
+
+// Approve prompts the player with GetChoice
+func (approver *TUIToolApprover) Approve(ctx context.Context, request agents.ToolApprovalRequest) (agents.ToolApproval, error) {
+	color := approver.Color
+	if color == "" {
+		color = Yellow
+	}
+	message := fmt.Sprintf("Do you want to execute tool %q - %v?", request.ToolName, request.Input)
+	return agents.ChoiceToApproval(GetChoice(color, message, []string{"y", "n", "q"}, "y")), nil
+}
@@ -43,7 +43,11 @@ func main() {
 	}
 
 	// Register MCP tools once
-	toolsRefs := tools.MCPCatalog(ctx, mcpClient)
+	toolsRefs, err := tools.MCPCatalog(ctx, mcpClient)
+	if err != nil {
+		fmt.Println("😡 Error getting the tools list:", err)
+		os.Exit(1)
+	}
 
 	config := agents.Config{
 		EngineURL:    engineURL,
@@ -1,11 +1,13 @@
 package data
 
+import "errors"
+
 // Response schemas for JSON completion mode
-// These schemas are used by NPCAgent.JsonCompletion() to enforce structured outputs
+// These schemas are used by agents.JsonCompletionAs() to enforce structured outputs
 //
 // Usage example:
 //
-//	agent.JsonCompletion(ctx, config, Room{}, "Create a dungeon entrance room")
+//	room, err := agents.JsonCompletionAs(ctx, agent, config, "Create a dungeon entrance room", ValidateRoom)
 //
 // Note: When using tools with conversation history, the Dungeon Master agent
 // resets its message history after each interaction to avoid tool call accumulation.
@@ -24,3 +26,28 @@ type Monster struct {
 	Kind        string `json:"kind"`
 }
 
+
+// ValidateRoom checks the generated room (the model is re-prompted with the error)
+func ValidateRoom(room Room) error {
+	if room.Name == "" {
+		return errors.New("the room name must not be empty")
+	}
+	if room.Description == "" {
+		return errors.New("the room description must not be empty")
+	}
+	return nil
+}
+
+// ValidateMonster checks the generated monster (the model is re-prompted with the error)
+func ValidateMonster(monster Monster) error {
+	if monster.Name == "" {
+		return errors.New("the monster name must not be empty")
+	}
+	if monster.Health <= 0 {
+		return errors.New("the monster health must be greater than 0")
+	}
+	if monster.Strength <= 0 {
+		return errors.New("the monster strength must be greater than 0")
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"dungeon-mcp-server/data"
 	"dungeon-mcp-server/tools"
@@ -19,6 +20,16 @@ import (
 
 func main() {
 
+	// ---------------------------------------------------------
+	// [STDIO] MCP_TRANSPORT=stdio runs the server as a child process (e.g. of the dungeon master, without Docker)
+	// The JSON-RPC messages use the standard output, so the other messages go to the standard error
+	// ---------------------------------------------------------
+	mcpTransport := helpers.GetEnvOrDefault("MCP_TRANSPORT", "streamable-http")
+	stdout := os.Stdout
+	if mcpTransport == "stdio" {
+		os.Stdout = os.Stderr
+	}
+
 	// ---------------------------------------------------------
 	// NOTE: Create [MCP Server]
 	// ---------------------------------------------------------
@@ -45,10 +56,15 @@ func main() {
 		EngineURL:   baseURL,
 		Temperature: temperature,
 		ChatModelId: "openai/" + dungeonModel,
+		// Re-prompt the model when the generated room or monster is not valid
+		JsonRepairAttempts: helpers.StringToInt(helpers.GetEnvOrDefault("DUNGEON_MODEL_JSON_REPAIR_ATTEMPTS", "2")),
 	}
 
-	dungeonAgent := agents.NPCAgent{}
-	dungeonAgent.Initialize(ctx, config, "dungeon-agent")
+	factory := agents.NewAgentFactory(ctx, config.EngineURL)
+	dungeonAgent, err := factory.NewAgent("dungeon-agent")
+	if err != nil {
+		log.Fatal("😡 Error creating the dungeon agent:", err)
+	}
 
 	// ---------------------------------------------------------
 	// Game initialisation
@@ -103,26 +119,15 @@ func main() {
 	dungeonAgentRoomSystemInstruction := helpers.GetEnvOrDefault("DUNGEON_AGENT_ROOM_SYSTEM_INSTRUCTION", "You are a Dungeon Master. You create rooms in a dungeon. Each room has a name and a short description.")
 	dungeonAgent.SetSystemInstructions(dungeonAgentRoomSystemInstruction)
 
-	response, err := dungeonAgent.JsonCompletion(ctx, config, data.Room{}, `
+	roomResponse, err := agents.JsonCompletionAs(ctx, dungeonAgent, config, `
 		Create an dungeon entrance room with a name and a short description.
-	`)
+	`, data.ValidateRoom)
 
 	if err != nil {
 		fmt.Println("🔴 Error generating room:", err)
 		return
 	}
 
-	fmt.Println("📝 Dungeon Entrance Room Response:", response)
-
-	var roomResponse struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-	}
-	if err = json.Unmarshal([]byte(response), &roomResponse); err != nil {
-		fmt.Println("Error unmarshaling room response:", err)
-		return
-	}
-
 	fmt.Println("👋🏰 Entrance Room:", roomResponse)
 	// ---------------------------------------------------------
 	// END: of Generate the entrance room with the dungeon agent
@@ -196,6 +201,17 @@ func main() {
 	isPlayerInSameRoomAsNPCToolInstance := tools.IsPlayerInSameRoomAsNPCTool()
 	s.AddTool(isPlayerInSameRoomAsNPCToolInstance, tools.IsPlayerInSameRoomAsNPCToolHandler(&currentPlayer, &dungeon))
 
+	// ---------------------------------------------------------
+	// NOTE: Start the [stdio MCP server]
+	// ---------------------------------------------------------
+	if mcpTransport == "stdio" {
+		log.Println("[Dungeon]MCP stdio server is running")
+		if err := server.NewStdioServer(s).Listen(ctx, os.Stdin, stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// ---------------------------------------------------------
 	// NOTE: Start the [Streamable HTTP MCP server]
 	// ---------------------------------------------------------
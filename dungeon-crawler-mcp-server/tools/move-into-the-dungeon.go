@@ -4,7 +4,6 @@ import (
 	"context"
 	"dungeon-mcp-server/data"
 	"dungeon-mcp-server/types"
-	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -45,7 +44,7 @@ func GetMovePlayerTool() mcp.Tool {
 
 }
 
-func MoveByDirectionToolHandler(player *types.Player, dungeon *types.Dungeon, dungeonAgent agents.NPCAgent, config agents.Config) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func MoveByDirectionToolHandler(player *types.Player, dungeon *types.Dungeon, dungeonAgent *agents.NPCAgent, config agents.Config) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
@@ -132,7 +131,7 @@ func MoveByDirectionToolHandler(player *types.Player, dungeon *types.Dungeon, du
 			fmt.Println(message)
 			fmt.Println(strings.Repeat("+", 50))
 
-			response, err := dungeonAgent.JsonCompletion(ctx, config, data.Room{}, message)
+			roomResponse, err := agents.JsonCompletionAs(ctx, dungeonAgent, config, message, data.ValidateRoom)
 
 			// NOTE: for debugging, display the message history
 			dungeonAgent.DisplayHistory()
@@ -142,17 +141,6 @@ func MoveByDirectionToolHandler(player *types.Player, dungeon *types.Dungeon, du
 				return mcp.NewToolResultText(""), err
 
 			}
-			fmt.Println("📝 Dungeon Room Response:", response)
-
-			var roomResponse struct {
-				Name        string `json:"name"`
-				Description string `json:"description"`
-			}
-			err = json.Unmarshal([]byte(response), &roomResponse)
-			if err != nil {
-				fmt.Println("🔴 Error unmarshaling room response:", err)
-				return mcp.NewToolResultText(""), err
-			}
 			fmt.Println("👋🏰 Room:", roomResponse)
 
 			// ---------------------------------------------------------
@@ -262,27 +250,19 @@ func MoveByDirectionToolHandler(player *types.Player, dungeon *types.Dungeon, du
 
 				// NOTE: run the completion to get the monster
 
-				response, err := dungeonAgent.JsonCompletion(ctx, config, data.Monster{}, `
+				monsterResponse, err := agents.JsonCompletionAs(ctx, dungeonAgent, config, `
 					Create a new monster with a name and a short description..
-				`)
+				`, data.ValidateMonster)
 
 				if err != nil {
 					fmt.Println("🔴 Error generating monster:", err)
 					return mcp.NewToolResultText(""), err
 
 				}
-				fmt.Println("📝 Monster Response:", response)
-
-				var monsterResponse types.Monster
-				err = json.Unmarshal([]byte(response), &monsterResponse)
-				if err != nil {
-					fmt.Println("🔴 Error unmarshaling monster response:", err)
-					return mcp.NewToolResultText(""), err
-				}
 				fmt.Println("👋👹 Monster:", monsterResponse)
 
 				monster = types.Monster{
-					Kind:        monsterResponse.Kind,
+					Kind:        types.Kind(monsterResponse.Kind),
 					Name:        monsterResponse.Name,
 					Description: monsterResponse.Description,
 					Health:      monsterResponse.Health,
@@ -2,23 +2,27 @@ package main
 
 import (
 	"context"
-	npcagents "dungeon-master/npc-agents"
 	"encoding/json"
 	"log"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/agents"
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/helpers"
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/rag"
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/tools"
 	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/ui"
 
 	"fmt"
 
 	"github.com/firebase/genkit/go/ai"
-	"github.com/firebase/genkit/go/plugins/mcp"
 )
 
 var agentsTeam map[string]*agents.NPCAgent
+var npcTeam map[string]*agents.SpecAgent
 var selectedAgent *agents.NPCAgent
 
 // // MCPRoomCheckResult represents the structure of MCP tool call results
@@ -53,23 +57,75 @@ func main() {
 	// similaritySearchLimit := helpers.StringToFloat(helpers.GetEnvOrDefault("SIMILARITY_LIMIT", "0.5"))
 	// similaritySearchMaxResults := helpers.StringToInt(helpers.GetEnvOrDefault("SIMILARITY_MAX_RESULTS", "2"))
 
-	// [MCP Client] to connect to the [MCP Dungeon Server]
-	mcpClient, err := mcp.NewGenkitMCPClient(mcp.MCPClientOptions{
-		Name: "c&d",
-		StreamableHTTP: &mcp.StreamableHTTPConfig{
-			BaseURL: helpers.GetEnvOrDefault("MCP_SERVER_BASE_URL", "http://localhost:9011/mcp"), // docker-mcp-gateway
-		},
+	// [MCP Servers] the docker-mcp-gateway by default,
+	// or the servers of MCP_SERVERS_CONFIG (e.g. local servers started with stdio, see ./mcp.servers.yaml)
+	mcpServersConfig := tools.MCPServersConfig{
+		Servers: []tools.MCPServerConfig{{Name: "c&d", URL: mcpHost}},
+	}
+	if mcpServersConfigPath := helpers.GetEnvOrDefault("MCP_SERVERS_CONFIG", ""); mcpServersConfigPath != "" {
+		config, err := tools.LoadMCPServersConfig(mcpServersConfigPath)
+		if err != nil {
+			log.Fatal("😡:", err)
+		}
+		mcpServersConfig = config
+	}
+	// [MCP Connections] they retry while the servers are starting and reconnect when a server restarts
+	mcpServers, err := tools.ConnectMCPServers(ctx, mcpServersConfig, tools.MCPConnectionOptions{
+		StartupTimeout:      helpers.StringToDuration(helpers.GetEnvOrDefault("MCP_CONNECT_TIMEOUT", "60s")),
+		HealthCheckInterval: helpers.StringToDuration(helpers.GetEnvOrDefault("MCP_HEALTH_CHECK_INTERVAL", "10s")),
+		OnStateChange:       DisplayMCPState,
 	})
 	if err != nil {
-		panic(fmt.Errorf("failed to create MCP client: %v", err))
+		log.Fatal("😡:", err)
 	}
+	defer mcpServers.Close()
 
 	ui.Println(ui.Orange, "MCP Client initialized successfully")
 
+	// ---------------------------------------------------------
+	// FACTORY: one genkit instance shared by all the agents
+	// ---------------------------------------------------------
+	agentFactory := agents.NewAgentFactory(ctx, llmURL)
+
 	// ---------------------------------------------------------
 	// Get the [MCP Tools Index] from the [MCP Client]
 	// ---------------------------------------------------------
-	toolsRefs := tools.MCPCatalog(ctx, mcpClient)
+	// The "c&d_" prefix of the gateway is removed: "c&d_get_dungeon_map" → "get_dungeon_map"
+	readOnlyToolsMetadata := map[string]tools.ToolMetadata{}
+	for _, pattern := range helpers.SplitNonEmpty(helpers.GetEnvOrDefault("DUNGEON_MASTER_READ_ONLY_TOOLS", "get_*,is_player_in_same_room_as_npc"), ",") {
+		readOnlyToolsMetadata[pattern] = tools.ToolMetadata{ReadOnly: true}
+	}
+	catalogOptions := tools.CatalogOptions{
+		StripPrefix: helpers.GetEnvOrDefault("MCP_TOOLS_PREFIX", "c&d_"),
+		Exclude:     helpers.SplitNonEmpty(helpers.GetEnvOrDefault("MCP_TOOLS_EXCLUDE", ""), ","),
+		Metadata:    readOnlyToolsMetadata,
+	}
+	// [SCOPE] the Dungeon Master only gets the tools it needs (all the tools by default)
+	dungeonMasterToolsScope := helpers.SplitNonEmpty(helpers.GetEnvOrDefault("DUNGEON_MASTER_TOOLS", "*"), ",")
+
+	toolsVersion := mcpServers.Version()
+	mcpToolsRefs, err := mcpServers.Tools()
+	if err != nil {
+		log.Fatal("😡:", err)
+	}
+	catalog, err := tools.NewCatalog(mcpToolsRefs, catalogOptions)
+	if err != nil {
+		log.Fatal("😡:", err)
+	}
+	toolsRefs := catalog.Tools()
+	dungeonMasterToolsRefs := catalog.Scope(dungeonMasterToolsScope...)
+
+	// ---------------------------------------------------------
+	// [LOCAL TOOLS] Go tools for the NPCs (no MCP server needed)
+	// ---------------------------------------------------------
+	localTools := tools.NewRegistry("local")
+	if err := tools.RegisterGameTools(localTools); err != nil {
+		log.Fatal("😡:", err)
+	}
+	npcToolsRefs, err := tools.Merge(toolsRefs, localTools.Tools())
+	if err != nil {
+		log.Fatal("😡:", err)
+	}
 
 	// ---------------------------------------------------------
 	// AGENT: This is the Dungeon Master Agent using tools
@@ -78,32 +134,118 @@ func main() {
 	dungeonMasterModeltemperature := helpers.StringToFloat(helpers.GetEnvOrDefault("DUNGEON_MASTER_MODEL_TEMPERATURE", "0.0"))
 	dungeonMasterModeltopP := helpers.StringToFloat(helpers.GetEnvOrDefault("DUNGEON_MASTER_MODEL_TOP_P", "0.9"))
 
+	// [RESILIENCE] timeouts and retries while the models are loading
+	resiliencePolicy := agents.ResiliencePolicy{
+		RequestTimeout: helpers.StringToDuration(helpers.GetEnvOrDefault("MODEL_REQUEST_TIMEOUT", "120s")),
+		MaxRetries:     helpers.StringToInt(helpers.GetEnvOrDefault("MODEL_MAX_RETRIES", "2")),
+	}
+
+	// [TOOL APPROVAL] no confirmation for the read-only tools, ask the player for the others
+	toolApprover := &agents.ToolListApprover{
+		Allow:    append(catalog.ToolsWithoutConfirmation(), localTools.Names()...),
+		Fallback: &ui.TUIToolApprover{Color: ui.Yellow},
+	}
+
 	dungeonMasterConfig := agents.Config{
 		EngineURL:    llmURL,
 		Temperature:  dungeonMasterModeltemperature,
 		TopP:         dungeonMasterModeltopP,
 		ChatModelId:  dungeonMasterModel,
 		ToolsModelId: dungeonMasterModel,
-		Tools:        toolsRefs,
+		Tools:        dungeonMasterToolsRefs,
+		ToolApprover: toolApprover,
+		// [PARALLEL TOOL CALLS] the read-only tools of one turn run concurrently
+		ParallelToolCalls: helpers.StringToInt(helpers.GetEnvOrDefault("DUNGEON_MASTER_PARALLEL_TOOL_CALLS", "1")),
+		ParallelSafeTools: catalog.ReadOnlyTools(),
+		// [LOOP GUARDS] a small model must not request tools forever
+		ToolLoopGuards: agents.ToolLoopGuards{
+			MaxIterations: helpers.StringToInt(helpers.GetEnvOrDefault("DUNGEON_MASTER_MAX_TOOL_ITERATIONS", "10")),
+			MaxDuration:   helpers.StringToDuration(helpers.GetEnvOrDefault("DUNGEON_MASTER_TOOL_LOOP_TIMEOUT", "300s")),
+		},
+		Resilience: resiliencePolicy,
 	}
 
 	// SYSTEM MESSAGE:
 	instructions := fmt.Sprintf(`Your name is "%s the Dungeon Master".`, dungeonMasterToolsAgentName) + "\n" + helpers.GetEnvOrDefault("DUNGEON_MASTER_SYSTEM_INSTRUCTIONS", dungeonMasterToolsAgentName)
 
-	dungeonMasterToolsAgent := &agents.NPCAgent{}
-	dungeonMasterToolsAgent.Initialize(ctx, dungeonMasterConfig, dungeonMasterToolsAgentName)
+	dungeonMasterToolsAgent, err := agentFactory.NewAgent(dungeonMasterToolsAgentName)
+	if err != nil {
+		log.Fatal("😡:", err)
+	}
 
 	dungeonMasterToolsAgent.SetSystemInstructions(instructions)
 
 	// ---------------------------------------------------------
-	// AGENTS:
+	// AGENTS: Non Player Characters loaded from the agent specs
 	// ---------------------------------------------------------
-	guardAgent := npcagents.GetGuardAgent(ctx)
-	sorcererAgent := npcagents.GetSorcererAgent(ctx)
-	healerAgent := npcagents.GetHealerAgent(ctx)
-	merchantAgent := npcagents.GetMerchantAgent(ctx)
-
-	bossAgent := npcagents.GetBossAgent(ctx)
+	// 👀 Look at ./data/agents/*.agent.yaml
+	npcAgentsPath := helpers.GetEnvOrDefault("NPC_AGENTS_PATH", "./data/agents")
+	// [HNSW] SIMILARITY_INDEX=hnsw for the large documents (rulebooks, campaign notes), exact search by default
+	var similarityIndex *rag.HNSWOptions
+	if helpers.GetEnvOrDefault("SIMILARITY_INDEX", "exact") == "hnsw" {
+		similarityIndex = &rag.HNSWOptions{
+			M:              helpers.StringToInt(helpers.GetEnvOrDefault("SIMILARITY_INDEX_M", "16")),
+			EfConstruction: helpers.StringToInt(helpers.GetEnvOrDefault("SIMILARITY_INDEX_EF_CONSTRUCTION", "100")),
+			EfSearch:       helpers.StringToInt(helpers.GetEnvOrDefault("SIMILARITY_INDEX_EF_SEARCH", "64")),
+		}
+	}
+	// [HYBRID] SIMILARITY_RERANK_MODEL: chat model reranking the candidates of the similarity search (no rerank if empty)
+	var similarityReranker rag.Reranker
+	if rerankModel := helpers.GetEnvOrDefault("SIMILARITY_RERANK_MODEL", ""); rerankModel != "" {
+		similarityReranker = rag.NewLLMReranker(agentFactory.Genkit(), "openai/"+rerankModel)
+	}
+	// [RAG] VECTOR_STORE_FORMAT=binary for the compact vector store files (the JSON files are migrated)
+	vectorStoreEncoding, err := rag.ParseVectorEncoding(helpers.GetEnvOrDefault("VECTOR_STORE_ENCODING", "float32"))
+	if err != nil {
+		log.Fatal("😡:", err)
+	}
+	embeddingModel := helpers.GetEnvOrDefault("EMBEDDING_MODEL", "ai/mxbai-embed-large:latest")
+	// [MEMORY] NPC_MEMORY: the NPCs remember the conversations and the game events (world memory)
+	memoryConfig := agents.MemoryConfig{
+		Enabled:    helpers.StringToBool(helpers.GetEnvOrDefault("NPC_MEMORY", "false")),
+		Directory:  helpers.GetEnvOrDefault("NPC_MEMORY_PATH", "./data/memory"),
+		MaxResults: helpers.StringToInt(helpers.GetEnvOrDefault("NPC_MEMORY_MAX_RESULTS", "3")),
+		Options: rag.MemoryOptions{
+			DuplicateThreshold: helpers.StringToFloat(helpers.GetEnvOrDefault("NPC_MEMORY_DUPLICATE_THRESHOLD", "0.92")),
+			HalfLife:           helpers.StringToDuration(helpers.GetEnvOrDefault("NPC_MEMORY_HALF_LIFE", "72h")),
+			MaxMemories:        helpers.StringToInt(helpers.GetEnvOrDefault("NPC_MEMORY_MAX_MEMORIES", "500")),
+		},
+	}
+	worldMemoryPath := ""
+	if memoryConfig.Enabled {
+		memoryConfig.World = agentFactory.NewMemoryStore(embeddingModel, memoryConfig.Options)
+		if memoryConfig.Directory != "" {
+			if err := os.MkdirAll(memoryConfig.Directory, 0755); err != nil {
+				log.Fatal("😡:", err)
+			}
+			worldMemoryPath = filepath.Join(memoryConfig.Directory, "world.memory.json")
+			if err := memoryConfig.World.Load(ctx, worldMemoryPath); err != nil {
+				log.Fatal("😡:", err)
+			}
+		}
+	}
+	npcDefaultConfig := agents.Config{
+		EmbeddingsModelId:          embeddingModel,
+		SimilaritySearchLimit:      helpers.StringToFloat(helpers.GetEnvOrDefault("SIMILARITY_LIMIT", "0.5")),
+		SimilaritySearchMaxResults: helpers.StringToInt(helpers.GetEnvOrDefault("SIMILARITY_MAX_RESULTS", "2")),
+		SimilarityIndex:            similarityIndex,
+		SimilarityHybridSearch:     helpers.StringToBool(helpers.GetEnvOrDefault("SIMILARITY_HYBRID_SEARCH", "false")),
+		SimilarityReranker:         similarityReranker,
+		ChunkMaxTokens:             helpers.StringToInt(helpers.GetEnvOrDefault("CHUNK_MAX_TOKENS", "400")),
+		ChunkOverlapTokens:         helpers.StringToInt(helpers.GetEnvOrDefault("CHUNK_OVERLAP_TOKENS", "64")),
+		EmbeddingBatchSize:         helpers.StringToInt(helpers.GetEnvOrDefault("EMBEDDING_BATCH_SIZE", "16")),
+		EmbeddingConcurrency:       helpers.StringToInt(helpers.GetEnvOrDefault("EMBEDDING_CONCURRENCY", "2")),
+		VectorStoreBinary:          helpers.GetEnvOrDefault("VECTOR_STORE_FORMAT", "json") == "binary",
+		VectorStoreEncoding:        vectorStoreEncoding,
+		Tools:                      npcToolsRefs,
+		ToolApprover:               toolApprover,
+		Resilience:                 resiliencePolicy,
+		Memory:                     memoryConfig,
+	}
+	npcAgents, err := agentFactory.LoadAgentsFromDirectory(ctx, npcAgentsPath, npcDefaultConfig)
+	if err != nil {
+		log.Fatal("😡:", err)
+	}
 
 	// ---------------------------------------------------------
 	// [REMOTE] AGENT: This is the Boss agent
@@ -111,25 +253,17 @@ func main() {
 	// [TODO]
 
 	// ---------------------------------------------------------
-	// AGENTS: Creating the Agents Team of the Dungeon
+	// TEAM: Assemble the agents into a team
 	// ---------------------------------------------------------
 	idDungeonMasterToolsAgent := strings.ToLower(dungeonMasterToolsAgentName)
-	idGuardAgent := strings.ToLower(guardAgent.Name)
-	idSorcererAgent := strings.ToLower(sorcererAgent.Name)
-	idHealerAgent := strings.ToLower(healerAgent.Name)
-	idMerchantAgent := strings.ToLower(merchantAgent.Name)
-	idBossAgent := strings.ToLower(bossAgent.Name)
 
-	// ---------------------------------------------------------
-	// TEAM: Assemble the agents into a team
-	// ---------------------------------------------------------
 	agentsTeam = map[string]*agents.NPCAgent{
 		idDungeonMasterToolsAgent: dungeonMasterToolsAgent,
-		idGuardAgent:              guardAgent,
-		idSorcererAgent:           sorcererAgent,
-		idHealerAgent:             healerAgent,
-		idMerchantAgent:           merchantAgent,
-		idBossAgent:               bossAgent,
+	}
+	npcTeam = map[string]*agents.SpecAgent{}
+	for _, npc := range npcAgents {
+		agentsTeam[strings.ToLower(npc.Agent.Name)] = npc.Agent
+		npcTeam[strings.ToLower(npc.Agent.Name)] = npc
 	}
 	selectedAgent = agentsTeam[idDungeonMasterToolsAgent]
 
@@ -188,10 +322,60 @@ func main() {
 		// [COMMAND] `/tools` Get the TOOLS list
 		// ---------------------------------------------------------
 		if strings.HasPrefix(content.Input, "/tools") {
-			DisplayToolsCatalog(toolsRefs)
+			DisplayToolsCatalog(dungeonMasterToolsRefs)
 			continue
 		}
+
+		// ---------------------------------------------------------
+		// [COMMAND] `/mcp` Get the state of the MCP connections
+		// ---------------------------------------------------------
+		if strings.HasPrefix(content.Input, "/mcp") {
+			for _, mcpConnection := range mcpServers.Connections() {
+				state, err := mcpConnection.State()
+				DisplayMCPState(mcpConnection.Name(), state, err)
+			}
+			continue
+		}
+		// ---------------------------------------------------------
+
+		// ---------------------------------------------------------
+		// [MCP TOOLS REFRESH] the tools list changed (gateway restarted or "tools/list_changed")
+		// ---------------------------------------------------------
+		if version := mcpServers.Version(); version != toolsVersion {
+			refreshedToolsRefs, err := mcpServers.Tools()
+			var refreshedCatalog *tools.Catalog
+			if err == nil {
+				refreshedCatalog, err = tools.NewCatalog(refreshedToolsRefs, catalogOptions)
+			}
+			if err != nil {
+				ui.Println(ui.Red, "❌ Error refreshing the MCP tools:", err)
+			} else {
+				toolsVersion = version
+				catalog = refreshedCatalog
+				dungeonMasterToolsRefs = refreshedCatalog.Scope(dungeonMasterToolsScope...)
+				dungeonMasterConfig.Tools = dungeonMasterToolsRefs
+				dungeonMasterConfig.ParallelSafeTools = refreshedCatalog.ReadOnlyTools()
+				toolApprover.Allow = append(refreshedCatalog.ToolsWithoutConfirmation(), localTools.Names()...)
+				ui.Println(ui.Orange, "🔄 MCP tools refreshed:", len(dungeonMasterToolsRefs), "tools")
+			}
+		}
+
+		// ---------------------------------------------------------
+		// [COMMAND] `/why [name]` Display the chunks and memories behind the last answer of an NPC
 		// ---------------------------------------------------------
+		if strings.HasPrefix(content.Input, "/why") {
+			whyAgent := selectedAgent
+			if name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(content.Input, "/why"))); name != "" {
+				agent, exists := agentsTeam[name]
+				if !exists {
+					ui.Printf(ui.Red, "❌ Agent %q not found.\n", name)
+					continue
+				}
+				whyAgent = agent
+			}
+			DisplayRetrievalTrace(whyAgent.Name, whyAgent.LastRetrieval())
+			continue
+		}
 
 		// ---------------------------------------------------------
 		// DEBUG:
@@ -209,23 +393,27 @@ func main() {
 			ui.Println(ui.Yellow, "<", selectedAgent.Name, "speaking...>")
 
 			// [TOOL CALLS] DETECTION
-			toolCallsResult, err := selectedAgent.DetectAndExecuteToolCallsWithConfirmation(ctx, dungeonMasterConfig, content.Input)
+			toolCallsResult, err := selectedAgent.StreamDetectAndExecuteToolCallsWithConfirmation(ctx, dungeonMasterConfig, content.Input, DisplayToolEvent)
 			if err != nil {
-				log.Fatal("😡:", err)
+				// The game goes on: the player can try again
+				ui.Println(ui.Red, "❌ Error executing the tool calls:", err)
+				continue
 			}
 			if toolCallsResult.TotalCalls == 0 {
 				// [TODO]
 			}
 			if toolCallsResult.TotalCalls > 0 {
 
+				// [MEMORY] the game events are remembered by all the NPCs
+				RememberGameEvents(ctx, memoryConfig.World, worldMemoryPath, toolCallsResult, dungeonMasterConfig.ParallelSafeTools)
+
 				toolName, value := GetResultOfToolCall(toolCallsResult)
 				ui.Println(ui.Green, "🛠️ Tool called:", toolName)
 				ui.Println(ui.Blue, "🛠️ Text:", value)
 
 				switch toolName {
 
-				
-				case "c&d_speak_to_somebody":
+				case "speak_to_somebody":
 					// Switch to the selected agent
 					var answer struct {
 						Name string `json:"name"`
@@ -238,17 +426,21 @@ func main() {
 					// ---------------------------------------------------------
 					// [Check if you are in the same room as the NPC]
 					// ---------------------------------------------------------
-					// [DIRECT CALL TO MCP]
-					strResult, err := dungeonMasterToolsAgent.DirectExecuteTool(ctx, dungeonMasterConfig,
+					// [DIRECT CALL TO MCP] through the whole catalog: the tool can be out of the scope of the Dungeon Master
+					roomCheckConfig := dungeonMasterConfig
+					roomCheckConfig.Tools = catalog.Tools()
+					strResult, err := dungeonMasterToolsAgent.DirectExecuteTool(ctx, roomCheckConfig,
 						&ai.ToolRequest{
-							Name: "c&d_is_player_in_same_room_as_npc",
+							Name: "is_player_in_same_room_as_npc",
 							Input: map[string]any{
 								"name": answer.Name,
 							},
 							Ref: "",
 						},
 					)
-					if err == nil {
+					if err != nil {
+						ui.Printf(ui.Red, "❌ Unable to check if you are in the same room as %q: %v\n", answer.Name, err)
+					} else {
 						//ui.Println(ui.Blue, "Ⓜ️ Information Message:\n", strResult)
 
 						type RoomCheckResult struct {
@@ -293,7 +485,7 @@ func main() {
 
 					}
 
-				} // End case "c&d_speak_to_somebody"
+				} // End case "speak_to_somebody"
 
 			}
 
@@ -308,134 +500,53 @@ func main() {
 			selectedAgent.ResetMessages() // Clear history after each interaction to avoid tool call accumulation
 
 		// ---------------------------------------------------------
-		// TALK TO: AGENT:: **GUARD** + [RAG]
-		// ---------------------------------------------------------
-		case guardAgent.Name:
-
-			ui.Println(ui.Brown, "<", selectedAgent.Name, "speaking...>")
-
-			_, err = selectedAgent.StreamCompletionWithSimilaritySearch(ctx, npcagents.GetGuardAgentConfig(), content.Input, func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
-				fmt.Print(chunk.Text())
-				return nil
-			})
-
-			if err != nil {
-				ui.Println(ui.Red, "Error:", err)
-			}
-
-		// ---------------------------------------------------------
-		// TALK TO: AGENT:: **SORCERER** + [RAG]
-		// ---------------------------------------------------------
-		case sorcererAgent.Name:
-
-			ui.Println(ui.Purple, "<", selectedAgent.Name, "speaking...>")
-
-			_, err = selectedAgent.StreamCompletionWithSimilaritySearch(ctx, npcagents.GetSorcererAgentConfig(), content.Input, func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
-				fmt.Print(chunk.Text())
-				return nil
-			})
-
-			if err != nil {
-				ui.Println(ui.Red, "Error:", err)
-			}
-
-		// ---------------------------------------------------------
-		// TALK TO: AGENT:: **HEALER** + [RAG]
+		// TALK TO: AGENT:: **NPC** (from the agent specs) + [RAG]
 		// ---------------------------------------------------------
-		case healerAgent.Name:
-
-			ui.Println(ui.Magenta, "<", selectedAgent.Name, "speaking...>")
-
-			_, err = selectedAgent.StreamCompletionWithSimilaritySearch(ctx, npcagents.GetHealerAgentConfig(), content.Input, func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
-				fmt.Print(chunk.Text())
-				return nil
-			})
-
-			if err != nil {
-				ui.Println(ui.Red, "Error:", err)
+		default:
+			npc, exists := npcTeam[strings.ToLower(selectedAgent.Name)]
+			if !exists {
+				ui.Printf(ui.Cyan, "\n🤖 %s is thinking...\n", selectedAgent.Name)
+				break
 			}
 
-		// ---------------------------------------------------------
-		// TALK TO: AGENT:: **MERCHANT** + [RAG]
-		// ---------------------------------------------------------
-		case merchantAgent.Name:
-
-			ui.Println(ui.Cyan, "<", selectedAgent.Name, "speaking...>")
-
-			_, err = selectedAgent.StreamCompletionWithSimilaritySearch(ctx, npcagents.GetMerchantAgentConfig(), content.Input, func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
-				fmt.Print(chunk.Text())
-				return nil
-			})
+			ui.Println(npc.Spec.Color, "<", selectedAgent.Name, "speaking...>")
 
-			if err != nil {
-				ui.Println(ui.Red, "Error:", err)
+			// [LOCAL TOOLS] the NPC can use its own tools (see the tools of the agent spec)
+			userMessage := content.Input
+			if len(npc.Config.Tools) > 0 {
+				npcToolCallsResult, err := selectedAgent.StreamDetectAndExecuteToolCallsWithConfirmation(ctx, npc.Config, content.Input, DisplayToolEvent)
+				if err != nil {
+					ui.Println(ui.Red, "Error:", err)
+				} else {
+					userMessage = WithToolResults(content.Input, npcToolCallsResult)
+				}
 			}
 
-		// ---------------------------------------------------------
-		// TALK TO: AGENT:: **BOSS**
-		// ---------------------------------------------------------
-		case bossAgent.Name:
-
-			ui.Println(ui.Red, "<", selectedAgent.Name, "speaking...>")
-
-			answer, err := selectedAgent.StreamCompletionWithSimilaritySearch(ctx, npcagents.GetBossAgentConfig(), content.Input, func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+			answer, err := selectedAgent.StreamCompletionWithSimilaritySearch(ctx, npc.Config, userMessage, func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
 				fmt.Print(chunk.Text())
 				return nil
 			})
 
 			if err != nil {
 				ui.Println(ui.Red, "Error:", err)
-			}
-			// IMPORTANT: Check if the player has defeated the boss
-			// 👀 Look at /data/boss_system_instructions.md
-
-			// ---------------------------------------------------------
-			// You lose 😢
-			// ---------------------------------------------------------
-			if strings.Contains(strings.ToLower(answer), "you are trapped") {
-				ui.Println(ui.Red, "\n💀 You have been defeated by the Boss! Game Over! 💀")
-				ui.Println(ui.Red, "👹 The Boss reigns supreme in the dungeon! 👹")
-				ui.Println(ui.Red, "🎲 Better luck next time! 🎲")
-
-				// [DIRECT CALL TO MCP]
-				strResult, err := dungeonMasterToolsAgent.DirectExecuteTool(ctx, dungeonMasterConfig,
-					&ai.ToolRequest{
-						Name:  "c&d_get_player_info",
-						Input: map[string]any{},
-						Ref:   "",
-					},
-				)
-				if err == nil {
-					ui.Println(ui.Red, "📝 Your player information:\n", strResult)
+			} else if npc.Config.Memory.Enabled {
+				// [MEMORY] the NPC remembers the notable facts of the exchange
+				if _, err := selectedAgent.RememberExchange(ctx, npc.Config, content.Input, answer); err != nil {
+					ui.Println(ui.Red, "❌ Error remembering the conversation:", err)
 				}
-
-				continue
 			}
+
 			// ---------------------------------------------------------
-			// You win 🎉
+			// AGENT:: **BOSS**
 			// ---------------------------------------------------------
-			if strings.Contains(strings.ToLower(answer), "you are free") {
-				ui.Println(ui.Green, "\n💀 You have defeated the Boss! Congratulations, brave adventurer! 💀")
-				ui.Println(ui.Green, "👑 You are now the new ruler of the dungeon! 👑")
-				ui.Println(ui.Green, "🎉 Thanks for playing! 🎉")
-
-				// [DIRECT CALL TO MCP]
-				strResult, err := dungeonMasterToolsAgent.DirectExecuteTool(ctx, dungeonMasterConfig,
-					&ai.ToolRequest{
-						Name:  "c&d_get_player_info",
-						Input: map[string]any{},
-						Ref:   "",
-					},
-				)
-				if err == nil {
-					ui.Println(ui.Green, "📝 Your player information:\n", strResult)
+			if npc.Spec.Id == "boss" {
+				// IMPORTANT: Check if the player has defeated the boss
+				// 👀 Look at /data/boss_system_instructions.md
+				if CheckEndOfGame(ctx, dungeonMasterToolsAgent, dungeonMasterConfig, answer) {
+					continue
 				}
-				continue
 			}
 
-		default:
-			ui.Printf(ui.Cyan, "\n🤖 %s is thinking...\n", selectedAgent.Name)
-
 		}
 		fmt.Println()
 		fmt.Println()
@@ -463,22 +574,185 @@ func DisplayToolsCatalog(tools []ai.ToolRef) {
 	fmt.Println()
 }
 
+// DisplayMCPState shows the state of an MCP connection (called when the state changes)
+func DisplayMCPState(name string, state tools.ConnectionState, err error) {
+	switch state {
+	case tools.MCPConnected:
+		ui.Println(ui.Green, "🟢 MCP server", name, "connected")
+	case tools.MCPDisconnected:
+		ui.Println(ui.Red, "🔴 MCP server", name, "disconnected, reconnecting...", err)
+	default:
+		ui.Println(ui.Orange, "🟠 MCP server", name, state)
+	}
+}
+
+// DisplayToolEvent shows each tool invocation live.
+// The text deltas of the tool calls loop are not displayed: the answer is streamed
+// by the completion that follows the loop (otherwise the reply is displayed twice).
+func DisplayToolEvent(ctx context.Context, event agents.ToolEvent) error {
+	switch event.Type {
+	case agents.ToolEventToolRequest:
+		ui.Println(ui.Orange, "\n🛠️ Calling tool:", event.ToolName, event.Input)
+	case agents.ToolEventConfirmationNeeded:
+		ui.Println(ui.Yellow, "✋ Confirmation needed for:", event.ToolName)
+	case agents.ToolEventToolResult:
+		ui.Println(ui.Green, "✅ Tool result:", event.ToolName)
+	case agents.ToolEventDone:
+		ui.Println(ui.Gray, "\n🏁 Tool calls:", event.Result.TotalCalls, "stop reason:", event.Result.StopReason)
+	}
+	return nil
+}
+
+// DisplayRetrievalTrace displays the lore chunks and the memories added to the context of the last answer
+func DisplayRetrievalTrace(agentName string, trace *agents.RetrievalTrace) {
+	if trace == nil {
+		ui.Printf(ui.Gray, "🔎 %s has not searched the lore yet.\n", agentName)
+		return
+	}
+	ui.Printf(ui.Cyan, "🔎 Why %s answered %q (%s)\n", agentName, trace.Query, trace.Time.Format(time.Kitchen))
+	ui.Printf(ui.Gray, "   limit: %.2f, max results: %d, hybrid: %t\n", trace.Limit, trace.MaxResults, trace.Hybrid)
+	if len(trace.Chunks) == 0 {
+		ui.Println(ui.Orange, "   No lore chunk above the similarity limit.")
+	}
+	for i, chunk := range trace.Chunks {
+		scores := fmt.Sprintf("similarity: %.4f", chunk.CosineSimilarity)
+		if chunk.RRFScore != 0 {
+			scores += fmt.Sprintf(", BM25: %.4f, RRF: %.4f", chunk.BM25Score, chunk.RRFScore)
+		}
+		if chunk.RerankScore != 0 {
+			scores += fmt.Sprintf(", rerank: %.1f", chunk.RerankScore)
+		}
+		ui.Printf(ui.Green, "📘 %d. %s (%s)\n", i+1, strings.Join(chunk.Headings, " > "), scores)
+		ui.Printf(ui.Gray, "   id: %s, source: %s\n", chunk.Id, chunk.Source)
+		ui.Printf(ui.Gray, "   %s\n", preview(chunk.Content, 160))
+	}
+	for _, memory := range trace.Memories {
+		ui.Printf(ui.Pink, "🧠 %s (score: %.4f, strength: %.2f, mentions: %d)\n", memory.Record.Prompt, memory.Score, memory.Strength, memory.Mentions())
+	}
+	if trace.Answer != "" {
+		ui.Printf(ui.Gray, "💬 %s\n", preview(trace.Answer, 160))
+	}
+}
+
+// preview returns the first characters of a text on one line
+func preview(text string, length int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) > length {
+		return string(runes[:length]) + "…"
+	}
+	return string(runes)
+}
+
+// WithToolResults adds the outputs of the successful tool calls to the user message
+func WithToolResults(userMessage string, toolCallsResult *agents.ToolCallsResult) string {
+	toolResults := []string{}
+	for _, record := range toolCallsResult.Results {
+		if record.Succeeded() {
+			toolResults = append(toolResults, fmt.Sprintf("- %s: %s", record.ToolName, record.Text()))
+		}
+	}
+	if len(toolResults) == 0 {
+		return userMessage
+	}
+	return userMessage + "\n\nResults of your tools:\n" + strings.Join(toolResults, "\n")
+}
+
+// GetResultOfToolCall returns the name and the text output of the last successful tool call,
+// the "speak to somebody" call first because it switches the selected agent
+// (empty strings if no tool call succeeded)
 func GetResultOfToolCall(toolCallsResult *agents.ToolCallsResult) (string, string) {
-	toolCalled := toolCallsResult.Results[0]["tool_name"]
-	result := toolCallsResult.Results[0]["tool_output"]
-	resultText := ""
-	// Extract content from the result map
-	if resultMap, ok := result.(map[string]any); ok {
-		if content, ok := resultMap["content"]; ok {
-			// content is an array, get the first element
-			if contentArray, ok := content.([]any); ok && len(contentArray) > 0 {
-				if contentItem, ok := contentArray[0].(map[string]any); ok {
-					if text, ok := contentItem["text"]; ok {
-						resultText = text.(string)
-					}
-				}
-			}
+	for _, failedCall := range toolCallsResult.Failed() {
+		ui.Println(ui.Red, "❌ Tool call", failedCall.Status, failedCall.ToolName, failedCall.Err)
+	}
+
+	record, found := toolCallsResult.LastSucceededCall("speak_to_somebody")
+	if !found {
+		record, found = toolCallsResult.LastSucceededCall("")
+	}
+	if !found {
+		return "", ""
+	}
+	return record.ToolName, record.Text()
+}
+
+// RememberGameEvents saves the successful tool calls changing the game (not the read-only tools)
+// in the world memory shared by the NPCs, and saves the memory file if path is not empty
+func RememberGameEvents(ctx context.Context, worldMemory *rag.MemoryStore, path string, toolCallsResult *agents.ToolCallsResult, readOnlyTools []string) {
+	if worldMemory == nil {
+		return
+	}
+	remembered := 0
+	for _, record := range toolCallsResult.Results {
+		if !record.Succeeded() || slices.Contains(readOnlyTools, record.ToolName) {
+			continue
+		}
+		input, _ := json.Marshal(record.Input)
+		output := []rune(record.Text())
+		if len(output) > 300 {
+			output = append(output[:300], '…')
+		}
+		event := fmt.Sprintf("Game event %s %s: %s", record.ToolName, input, string(output))
+		_, _, err := worldMemory.Remember(ctx, event, rag.RecordMetadata{
+			Source: "game",
+			Tags:   []string{"event", record.ToolName},
+		})
+		if err != nil {
+			ui.Println(ui.Red, "❌ Error remembering the game event:", err)
+			continue
+		}
+		remembered++
+	}
+	if remembered > 0 && path != "" {
+		if err := worldMemory.Save(path); err != nil {
+			ui.Println(ui.Red, "❌ Error saving the world memory:", err)
+		}
+	}
+}
+
+// CheckEndOfGame checks the answer of the Boss and displays the player information
+// if the player has won or lost. It returns true if the game is over.
+func CheckEndOfGame(ctx context.Context, dungeonMasterToolsAgent *agents.NPCAgent, dungeonMasterConfig agents.Config, answer string) bool {
+	// ---------------------------------------------------------
+	// You lose 😢
+	// ---------------------------------------------------------
+	if strings.Contains(strings.ToLower(answer), "you are trapped") {
+		ui.Println(ui.Red, "\n💀 You have been defeated by the Boss! Game Over! 💀")
+		ui.Println(ui.Red, "👹 The Boss reigns supreme in the dungeon! 👹")
+		ui.Println(ui.Red, "🎲 Better luck next time! 🎲")
+
+		// [DIRECT CALL TO MCP]
+		strResult, err := dungeonMasterToolsAgent.DirectExecuteTool(ctx, dungeonMasterConfig,
+			&ai.ToolRequest{
+				Name:  "get_player_info",
+				Input: map[string]any{},
+				Ref:   "",
+			},
+		)
+		if err == nil {
+			ui.Println(ui.Red, "📝 Your player information:\n", strResult)
+		}
+		return true
+	}
+	// ---------------------------------------------------------
+	// You win 🎉
+	// ---------------------------------------------------------
+	if strings.Contains(strings.ToLower(answer), "you are free") {
+		ui.Println(ui.Green, "\n💀 You have defeated the Boss! Congratulations, brave adventurer! 💀")
+		ui.Println(ui.Green, "👑 You are now the new ruler of the dungeon! 👑")
+		ui.Println(ui.Green, "🎉 Thanks for playing! 🎉")
+
+		// [DIRECT CALL TO MCP]
+		strResult, err := dungeonMasterToolsAgent.DirectExecuteTool(ctx, dungeonMasterConfig,
+			&ai.ToolRequest{
+				Name:  "get_player_info",
+				Input: map[string]any{},
+				Ref:   "",
+			},
+		)
+		if err == nil {
+			ui.Println(ui.Green, "📝 Your player information:\n", strResult)
 		}
+		return true
 	}
-	return toolCalled.(string), resultText
+	return false
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Compose-and-Dragons/dungeon.v2/compose-dragons/agents"
+)
+
+func TestGetResultOfToolCall(t *testing.T) {
+	mcpText := func(text string) map[string]any {
+		return map[string]any{"content": []any{map[string]any{"type": "text", "text": text}}}
+	}
+	tests := []struct {
+		name      string
+		records   []agents.ToolCallRecord
+		wantTool  string
+		wantValue string
+	}{
+		{
+			name:    "no tool call",
+			records: nil,
+		},
+		{
+			name: "last successful call",
+			records: []agents.ToolCallRecord{
+				{ToolName: "get_room", Status: agents.ToolCallSucceeded, Output: mcpText("room_0_0")},
+				{ToolName: "move", Status: agents.ToolCallSucceeded, Output: mcpText("room_0_1")},
+				{ToolName: "roll_dice", Status: agents.ToolCallFailed, Err: errors.New("invalid dice")},
+			},
+			wantTool:  "move",
+			wantValue: "room_0_1",
+		},
+		{
+			name: "speak to somebody first",
+			records: []agents.ToolCallRecord{
+				{ToolName: "speak_to_somebody", Status: agents.ToolCallSucceeded, Output: mcpText(`{"name":"Lyralei"}`)},
+				{ToolName: "speak_to_somebody", Status: agents.ToolCallFailed, Err: errors.New("not here")},
+				{ToolName: "get_room", Status: agents.ToolCallSucceeded, Output: mcpText("room_0_0")},
+			},
+			wantTool:  "speak_to_somebody",
+			wantValue: `{"name":"Lyralei"}`,
+		},
+		{
+			name: "only failed calls",
+			records: []agents.ToolCallRecord{
+				{ToolName: "speak_to_somebody", Status: agents.ToolCallCancelled, Err: errors.New("denied")},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			toolName, value := GetResultOfToolCall(&agents.ToolCallsResult{Results: test.records})
+			if toolName != test.wantTool || value != test.wantValue {
+				t.Fatalf("result %q %q, want %q %q", toolName, value, test.wantTool, test.wantValue)
+			}
+		})
+	}
+}
@@ -53,6 +53,15 @@ func main() {
 		return mcp.NewToolResultText(string(responseJSON)), nil
 	})
 
+	// MCP_TRANSPORT=stdio runs the server as a child process (e.g. of the dungeon master, without Docker)
+	if os.Getenv("MCP_TRANSPORT") == "stdio" {
+		log.Println("MCP 👋 Toc Toc 🌍 stdio server is running")
+		if err := server.ServeStdio(s); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Start the HTTP server
 	httpPort := os.Getenv("MCP_HTTP_PORT")
 	if httpPort == "" {
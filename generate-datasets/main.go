@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -45,8 +46,11 @@ func main() {
 		ChatModelId: chatModelId,
 	}
 
-	dataSetAgent := agents.NPCAgent{}
-	dataSetAgent.Initialize(ctx, config, agentName)
+	factory := agents.NewAgentFactory(ctx, config.EngineURL)
+	dataSetAgent, err := factory.NewAgent(agentName)
+	if err != nil {
+		log.Fatal("😡 Error creating the agent:", err)
+	}
 
 	systemMsg := helpers.GetEnvOrDefault("SYSTEM_INSTRUCTIONS", ``)
 	fmt.Println("✅ System Instructions set.", systemMsg)
@@ -110,7 +114,7 @@ func main() {
 
 		fmt.Println("Ⓜ️ Generating dataset entries for the iteration 1:")
 
-		entries, jsonString, err := GenerateDatasetEntries(ctx, config, &dataSetAgent, userMessage, nameOfTheNPC, maxGenerationRetries)
+		entries, jsonString, err := GenerateDatasetEntries(ctx, config, dataSetAgent, userMessage, nameOfTheNPC, maxGenerationRetries)
 		if err != nil {
 			log.Println("😡 Error generating dataset entries for chunk, skipping:", err)
 			continue
@@ -127,7 +131,7 @@ func main() {
 			%s
 			`, jsonString, userMessage)
 
-			entries, newJsonString, err := GenerateDatasetEntries(ctx, config, &dataSetAgent, userMessage, nameOfTheNPC, maxGenerationRetries)
+			entries, newJsonString, err := GenerateDatasetEntries(ctx, config, dataSetAgent, userMessage, nameOfTheNPC, maxGenerationRetries)
 			if err != nil {
 				log.Println("😡 Error generating dataset entries for chunk, skipping:", err)
 				continue
@@ -172,32 +176,23 @@ func main() {
 
 func GenerateDatasetEntries(ctx context.Context, config agents.Config, dataSetAgent *agents.NPCAgent, userMessage string, nameOfTheNPC string, maxGenerationRetries int) ([]DataSetEntry, string, error) {
 
-	var response string
-	maxRetries := maxGenerationRetries
+	// The invalid answers (bad JSON, schema violations, empty entries) are sent back to the model to be fixed
+	config.JsonRepairAttempts = max(maxGenerationRetries-1, 0)
 
-	var err error
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-
-		response, err = dataSetAgent.JsonCompletion(ctx, config, []DataSetEntry{}, userMessage)
-		if err == nil {
-			break
-		}
-		log.Printf("😡 JSON Completion attempt %d/%d failed: %v\n", attempt, maxRetries, err)
+	entries, err := agents.JsonCompletionAs(ctx, dataSetAgent, config, userMessage, validateDatasetEntries)
+	if err != nil {
+		log.Printf("😡 JSON Completion failed after %d attempts, skipping iteration\n", maxGenerationRetries)
+		return nil, "", err
 	}
 
+	responseBytes, err := json.Marshal(entries)
 	if err != nil {
-		log.Printf("😡 JSON Completion failed after %d attempts, skipping iteration\n", maxRetries)
 		return nil, "", err
-		//continue
 	}
+	response := string(responseBytes)
 
 	fmt.Println("🗂️ Dataset Entries Response:\n", response)
 
-	var entries []DataSetEntry
-	if err := json.Unmarshal([]byte(response), &entries); err != nil {
-		log.Fatal("😡 Error unmarshaling dataset entries:", err)
-	}
-
 	for _, entry := range entries {
 		fmt.Printf("📝 Prompt: %s\n", entry.Prompt)
 		fmt.Printf("💡 Response: %s\n", entry.Response)
@@ -205,6 +200,19 @@ func GenerateDatasetEntries(ctx context.Context, config agents.Config, dataSetAg
 	return entries, response, nil
 }
 
+// validateDatasetEntries rejects empty answers and entries without prompt or response
+func validateDatasetEntries(entries []DataSetEntry) error {
+	if len(entries) == 0 {
+		return errors.New("the dataset entries list is empty")
+	}
+	for i, entry := range entries {
+		if strings.TrimSpace(entry.Prompt) == "" || strings.TrimSpace(entry.Response) == "" {
+			return fmt.Errorf("the dataset entry %d must have a prompt and a response", i+1)
+		}
+	}
+	return nil
+}
+
 func process(t *template.Template, vars interface{}) (string, error) {
 	var tmplBytes bytes.Buffer
 